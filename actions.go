@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var (
+	InvalidAction = errors.New("invalid action, a built-in action may have been used")
+)
+
+// ActionHandler is called whenever a handler returns the custom Action that it was set for (see
+// Server.SetActionHandler), with the context that the handler was called with and the connection that the
+// packet was received on. It returns the built-in Action (NONE, CLOSE, UPDATE or SHUTDOWN) that is then
+// applied, and any custom Action that it returns is treated as NONE.
+//
+// For connections served by the event loop (see Server.SetEventLoop), the connection is nil.
+type ActionHandler func(ctx context.Context, conn *Async) Action
+
+// actionHandlers is the lookup table for the ActionHandlers of custom actions
+type actionHandlers map[Action]ActionHandler
+
+// set sets the ActionHandler for the given custom action, removing it if handler is nil
+func (a *actionHandlers) set(action Action, handler ActionHandler) error {
+	if action <= SHUTDOWN {
+		return InvalidAction
+	}
+	if handler == nil {
+		delete(*a, action)
+		return nil
+	}
+	if *a == nil {
+		*a = make(actionHandlers)
+	}
+	(*a)[action] = handler
+	return nil
+}
+
+// resolve returns the built-in Action to apply for the given action, running the ActionHandler if it is a custom
+// action. Custom actions without an ActionHandler are resolved to NONE, and if the ActionHandler panics, NONE
+// is returned along with the *PanicError for the packet with the given operation.
+func (a actionHandlers) resolve(ctx context.Context, conn *Async, operation uint16, action Action) (Action, *PanicError) {
+	if action <= SHUTDOWN {
+		return action, nil
+	}
+	handler, ok := a[action]
+	if !ok {
+		return NONE, nil
+	}
+	if err := recoverPanic(operation, func() {
+		action = handler(ctx, conn)
+	}); err != nil {
+		return NONE, err
+	}
+	if action <= SHUTDOWN {
+		return action, nil
+	}
+	return NONE, nil
+}
+
+// handlerContext is the context that a connection's handlers are called with, which is replaced
+// by the result of the UpdateContext function whenever a handler returns the UPDATE action
+type handlerContext struct {
+	mu  sync.RWMutex
+	ctx context.Context
+}
+
+func newHandlerContext(ctx context.Context) *handlerContext {
+	return &handlerContext{
+		ctx: ctx,
+	}
+}
+
+func (h *handlerContext) load() context.Context {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.ctx
+}
+
+// update replaces the context with the one returned by the given UpdateContext function, if it is not nil. If the
+// UpdateContext function panics, the context is kept and the *PanicError for the packet with the given operation
+// is returned.
+func (h *handlerContext) update(updateContext func(context.Context, *Async) context.Context, conn *Async, operation uint16) *PanicError {
+	if updateContext == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return recoverPanic(operation, func() {
+		h.ctx = updateContext(h.ctx, conn)
+	})
+}
@@ -0,0 +1,217 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+	"github.com/loopholelabs/testing/conn/pair"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+type updateContextKey struct{}
+
+const testAction = Action(16)
+
+func TestServerActions(t *testing.T) {
+	t.Parallel()
+
+	newServer := func(t *testing.T, action Action) *Server {
+		serverHandlerTable := make(HandlerTable)
+		serverHandlerTable[metadata.PacketPing] = func(ctx context.Context, incoming *packet.Packet) (outgoing *packet.Packet, _ Action) {
+			updates, _ := ctx.Value(updateContextKey{}).(int)
+			outgoing = incoming
+			outgoing.Content.Write([]byte{byte(updates)})
+			outgoing.Metadata.ContentLength = uint32(outgoing.Content.Len())
+			return outgoing, action
+		}
+
+		emptyLogger := logging.Test(t, logging.Noop, t.Name())
+		s, err := NewServer(serverHandlerTable, context.Background(), WithLogger(emptyLogger))
+		require.NoError(t, err)
+
+		s.SetConcurrency(1)
+		s.UpdateContext = func(ctx context.Context, _ *Async) context.Context {
+			updates, _ := ctx.Value(updateContextKey{}).(int)
+			return context.WithValue(ctx, updateContextKey{}, updates+1)
+		}
+		return s
+	}
+
+	ping := func(t *testing.T, c *Async) *packet.Packet {
+		p := packet.Get()
+		p.Metadata.Operation = metadata.PacketPing
+		err := c.WritePacket(p)
+		require.NoError(t, err)
+		packet.Put(p)
+
+		p, err = c.ReadPacket()
+		require.NoError(t, err)
+		return p
+	}
+
+	t.Run("update", func(t *testing.T) {
+		t.Parallel()
+
+		s := newServer(t, UPDATE)
+
+		serverConn, clientConn, err := pair.New()
+		require.NoError(t, err)
+
+		go s.ServeConn(serverConn)
+
+		emptyLogger := logging.Test(t, logging.Noop, t.Name())
+		c := NewAsync(clientConn, emptyLogger)
+
+		// Each response contains the number of times the context had been updated when it was handled
+		for i := 0; i < 3; i++ {
+			p := ping(t, c)
+			assert.Equal(t, []byte{byte(i)}, p.Content.Bytes())
+			packet.Put(p)
+		}
+
+		err = c.Close()
+		assert.NoError(t, err)
+		err = s.Shutdown()
+		assert.NoError(t, err)
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		t.Parallel()
+
+		s := newServer(t, SHUTDOWN)
+
+		serverConn, clientConn, err := pair.New()
+		require.NoError(t, err)
+
+		go s.ServeConn(serverConn)
+
+		emptyLogger := logging.Test(t, logging.Noop, t.Name())
+		c := NewAsync(clientConn, emptyLogger)
+
+		p := ping(t, c)
+		packet.Put(p)
+
+		require.Eventually(t, func() bool {
+			return s.shutdown.Load()
+		}, DefaultDeadline, time.Millisecond*10)
+
+		_, err = c.ReadPacket()
+		require.ErrorIs(t, err, ConnectionClosed)
+
+		err = c.Close()
+		assert.NoError(t, err)
+		err = s.Shutdown()
+		assert.NoError(t, err)
+	})
+
+	t.Run("custom", func(t *testing.T) {
+		t.Parallel()
+
+		s := newServer(t, testAction)
+
+		err := s.SetActionHandler(CLOSE, func(_ context.Context, _ *Async) Action {
+			return NONE
+		})
+		assert.ErrorIs(t, err, InvalidAction)
+
+		called := make(chan *Async, 1)
+		err = s.SetActionHandler(testAction, func(ctx context.Context, conn *Async) Action {
+			called <- conn
+			return CLOSE
+		})
+		require.NoError(t, err)
+
+		serverConn, clientConn, err := pair.New()
+		require.NoError(t, err)
+
+		go s.ServeConn(serverConn)
+
+		emptyLogger := logging.Test(t, logging.Noop, t.Name())
+		c := NewAsync(clientConn, emptyLogger)
+
+		p := ping(t, c)
+		packet.Put(p)
+
+		select {
+		case conn := <-called:
+			assert.NotNil(t, conn)
+		case <-time.After(DefaultDeadline):
+			t.Fatal("timed out waiting for action handler")
+		}
+
+		// The connection is closed because of the CLOSE action returned by the action handler
+		_, err = c.ReadPacket()
+		require.ErrorIs(t, err, ConnectionClosed)
+		assert.False(t, s.shutdown.Load())
+
+		err = c.Close()
+		assert.NoError(t, err)
+		err = s.Shutdown()
+		assert.NoError(t, err)
+	})
+}
+
+func TestClientActions(t *testing.T) {
+	t.Parallel()
+
+	actions := make(chan Action, 2)
+	contexts := make(chan int, 2)
+	clientHandlerTable := make(HandlerTable)
+	clientHandlerTable[metadata.PacketPing] = func(ctx context.Context, _ *packet.Packet) (outgoing *packet.Packet, action Action) {
+		updates, _ := ctx.Value(updateContextKey{}).(int)
+		contexts <- updates
+		return nil, <-actions
+	}
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	c, err := NewClient(clientHandlerTable, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	c.UpdateContext = func(ctx context.Context, _ *Async) context.Context {
+		return context.WithValue(ctx, updateContextKey{}, 1)
+	}
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+
+	err = c.FromConn(clientConn)
+	require.NoError(t, err)
+
+	server := NewAsync(serverConn, emptyLogger)
+
+	actions <- UPDATE
+	actions <- SHUTDOWN
+	p := packet.Get()
+	p.Metadata.Operation = metadata.PacketPing
+	for i := 0; i < 2; i++ {
+		err = server.WritePacket(p)
+		require.NoError(t, err)
+	}
+	packet.Put(p)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case updates := <-contexts:
+			assert.Equal(t, i, updates)
+		case <-time.After(DefaultDeadline):
+			t.Fatal("timed out waiting for handler")
+		}
+	}
+
+	// The client is closed because of the SHUTDOWN action
+	_, err = server.ReadPacket()
+	require.ErrorIs(t, err, ConnectionClosed)
+	require.Eventually(t, c.Closed, DefaultDeadline, time.Millisecond*10)
+
+	_ = c.Close()
+	_ = server.Close()
+}
@@ -0,0 +1,193 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// AdaptiveAlgorithm is used to select how an AdaptiveExecutor adjusts its concurrency limit
+//
+//	AdaptiveAIMD: increase the limit by one while it is being reached and every task completes within the
+//	LatencyThreshold, and multiply it by the BackoffRatio whenever a task takes longer than that (default)
+//	AdaptiveGradient: scale the limit by the ratio between the long-term average latency and the latest latency,
+//	so the limit shrinks as soon as latency starts rising above its usual level and grows while it is stable
+type AdaptiveAlgorithm int
+
+const (
+	// AdaptiveAIMD uses additive-increase/multiplicative-decrease based on a latency threshold (default)
+	AdaptiveAIMD = AdaptiveAlgorithm(iota)
+
+	// AdaptiveGradient uses the gradient between the long-term and the latest latency
+	AdaptiveGradient
+)
+
+const (
+	DefaultAdaptiveInitialLimit     = 20
+	DefaultAdaptiveMinLimit         = 1
+	DefaultAdaptiveMaxLimit         = 1000
+	DefaultAdaptiveLatencyThreshold = time.Millisecond * 100
+	DefaultAdaptiveBackoffRatio     = 0.9
+	DefaultAdaptiveTolerance        = 1.5
+	DefaultAdaptiveSmoothing        = 0.2
+)
+
+// AdaptiveOptions is used to configure an AdaptiveExecutor
+//
+// The default values are:
+//
+//	var DefaultAdaptiveOptions = AdaptiveOptions {
+//		Algorithm:        AdaptiveAIMD,
+//		InitialLimit:     DefaultAdaptiveInitialLimit,
+//		MinLimit:         DefaultAdaptiveMinLimit,
+//		MaxLimit:         DefaultAdaptiveMaxLimit,
+//		LatencyThreshold: DefaultAdaptiveLatencyThreshold,
+//		BackoffRatio:     DefaultAdaptiveBackoffRatio,
+//		Tolerance:        DefaultAdaptiveTolerance,
+//		Smoothing:        DefaultAdaptiveSmoothing,
+//	}
+//
+// The latency of a task is the time it spends waiting for the limit (its queue time) plus the time it takes to run.
+// LatencyThreshold and BackoffRatio are only used by AdaptiveAIMD, and Tolerance (how much higher than the long-term
+// average the latest latency can be before the limit shrinks) and Smoothing are only used by AdaptiveGradient.
+type AdaptiveOptions struct {
+	Algorithm        AdaptiveAlgorithm
+	InitialLimit     uint64
+	MinLimit         uint64
+	MaxLimit         uint64
+	LatencyThreshold time.Duration
+	BackoffRatio     float64
+	Tolerance        float64
+	Smoothing        float64
+}
+
+func loadAdaptiveOptions(options AdaptiveOptions) AdaptiveOptions {
+	if options.MinLimit == 0 {
+		options.MinLimit = DefaultAdaptiveMinLimit
+	}
+	if options.MaxLimit == 0 {
+		options.MaxLimit = DefaultAdaptiveMaxLimit
+	}
+	if options.MaxLimit < options.MinLimit {
+		options.MaxLimit = options.MinLimit
+	}
+	if options.InitialLimit == 0 {
+		options.InitialLimit = DefaultAdaptiveInitialLimit
+	}
+	options.InitialLimit = min(max(options.InitialLimit, options.MinLimit), options.MaxLimit)
+	if options.LatencyThreshold == 0 {
+		options.LatencyThreshold = DefaultAdaptiveLatencyThreshold
+	}
+	if options.BackoffRatio <= 0 || options.BackoffRatio >= 1 {
+		options.BackoffRatio = DefaultAdaptiveBackoffRatio
+	}
+	if options.Tolerance < 1 {
+		options.Tolerance = DefaultAdaptiveTolerance
+	}
+	if options.Smoothing <= 0 || options.Smoothing > 1 {
+		options.Smoothing = DefaultAdaptiveSmoothing
+	}
+	return options
+}
+
+// AdaptiveExecutor runs every task in its own goroutine, and limits the number of tasks that can run at the same time
+// (across all connections) with a limit that is adjusted automatically based on the observed latency of the tasks.
+// Execute blocks while the limit is reached.
+type AdaptiveExecutor struct {
+	options  AdaptiveOptions
+	mu       sync.Mutex
+	cond     *sync.Cond
+	limit    float64
+	inFlight uint64
+	waiting  uint64
+	average  float64
+}
+
+// NewAdaptiveExecutor returns an AdaptiveExecutor configured using the given AdaptiveOptions.
+// Any unset fields in the AdaptiveOptions will use their default values.
+func NewAdaptiveExecutor(options AdaptiveOptions) *AdaptiveExecutor {
+	options = loadAdaptiveOptions(options)
+	e := &AdaptiveExecutor{
+		options: options,
+		limit:   float64(options.InitialLimit),
+	}
+	e.cond = sync.NewCond(&e.mu)
+	return e
+}
+
+func (e *AdaptiveExecutor) Execute(_ *Async, task func()) {
+	queued := time.Now()
+	e.mu.Lock()
+	for e.inFlight >= uint64(e.limit) {
+		e.waiting++
+		e.cond.Wait()
+		e.waiting--
+	}
+	e.inFlight++
+	e.mu.Unlock()
+	go func() {
+		task()
+		e.sample(time.Since(queued))
+	}()
+}
+
+func (e *AdaptiveExecutor) Release(_ *Async) {}
+
+// sample adjusts the limit based on the latency of a completed task
+func (e *AdaptiveExecutor) sample(latency time.Duration) {
+	e.mu.Lock()
+	// The limit is only increased if it was actually being reached, since otherwise
+	// the latency does not say anything about whether a higher limit could be handled
+	saturated := float64(e.inFlight) >= e.limit || e.waiting > 0
+	e.inFlight--
+	switch e.options.Algorithm {
+	case AdaptiveGradient:
+		sample := float64(latency)
+		if e.average == 0 {
+			e.average = sample
+		} else {
+			// The long-term average only drifts slowly, so that a sustained rise in latency keeps the limit low
+			e.average = e.average*0.99 + sample*0.01
+		}
+		gradient := math.Max(0.5, math.Min(1, e.options.Tolerance*e.average/sample))
+		if gradient < 1 || saturated {
+			limit := e.limit*gradient + math.Sqrt(e.limit)
+			if limit > e.limit && !saturated {
+				limit = e.limit
+			}
+			e.limit = e.limit*(1-e.options.Smoothing) + limit*e.options.Smoothing
+		}
+	default:
+		if latency > e.options.LatencyThreshold {
+			e.limit *= e.options.BackoffRatio
+		} else if saturated {
+			e.limit++
+		}
+	}
+	e.limit = math.Max(float64(e.options.MinLimit), math.Min(float64(e.options.MaxLimit), e.limit))
+	e.mu.Unlock()
+	e.cond.Broadcast()
+}
+
+// Limit returns the current concurrency limit
+func (e *AdaptiveExecutor) Limit() uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return uint64(e.limit)
+}
+
+// InFlight returns the number of tasks that are currently running
+func (e *AdaptiveExecutor) InFlight() uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.inFlight
+}
+
+// Waiting returns the number of calls to Execute that are currently blocked waiting for the limit
+func (e *AdaptiveExecutor) Waiting() uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.waiting
+}
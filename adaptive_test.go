@@ -0,0 +1,143 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+	"github.com/loopholelabs/testing/conn/pair"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+func TestAdaptiveExecutor(t *testing.T) {
+	t.Parallel()
+
+	const testSize = 100
+
+	// run executes testSize tasks that each take delay to complete, and returns the maximum number of
+	// tasks that ran at the same time and the lowest limit that was observed while submitting them
+	run := func(e *AdaptiveExecutor, delay time.Duration) (int64, uint64) {
+		var wg sync.WaitGroup
+		var running, maxRunning atomic.Int64
+		minLimit := e.Limit()
+		wg.Add(testSize)
+		for i := 0; i < testSize; i++ {
+			minLimit = min(minLimit, e.Limit())
+			e.Execute(nil, func() {
+				n := running.Add(1)
+				for m := maxRunning.Load(); n > m && !maxRunning.CompareAndSwap(m, n); m = maxRunning.Load() {
+				}
+				time.Sleep(delay)
+				running.Add(-1)
+				wg.Done()
+			})
+		}
+		wg.Wait()
+		return maxRunning.Load(), minLimit
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		t.Parallel()
+		e := NewAdaptiveExecutor(AdaptiveOptions{MinLimit: 10, MaxLimit: 5})
+		assert.Equal(t, uint64(10), e.Limit())
+		assert.Equal(t, uint64(10), e.options.MaxLimit)
+	})
+
+	t.Run("aimd", func(t *testing.T) {
+		t.Parallel()
+		e := NewAdaptiveExecutor(AdaptiveOptions{
+			InitialLimit:     8,
+			MaxLimit:         16,
+			LatencyThreshold: time.Millisecond * 5,
+		})
+
+		// Slow tasks shrink the limit
+		maxRunning, minLimit := run(e, time.Millisecond*10)
+		assert.LessOrEqual(t, maxRunning, int64(8))
+		assert.Less(t, minLimit, uint64(8))
+		assert.Less(t, e.Limit(), uint64(8))
+
+		// Fast tasks that reach the limit grow it again
+		run(e, 0)
+		assert.Equal(t, uint64(16), e.Limit())
+		assert.Equal(t, uint64(0), e.InFlight())
+		assert.Equal(t, uint64(0), e.Waiting())
+	})
+
+	t.Run("gradient", func(t *testing.T) {
+		t.Parallel()
+		e := NewAdaptiveExecutor(AdaptiveOptions{
+			Algorithm:    AdaptiveGradient,
+			InitialLimit: 8,
+			MaxLimit:     16,
+		})
+
+		// Steady latency while the limit is reached grows the limit
+		run(e, time.Millisecond)
+		grown := e.Limit()
+		assert.Greater(t, grown, uint64(8))
+
+		// Latency well above the long-term average shrinks the limit
+		_, minLimit := run(e, time.Millisecond*20)
+		assert.Less(t, minLimit, grown)
+	})
+}
+
+func TestServerAdaptiveConcurrency(t *testing.T) {
+	t.Parallel()
+
+	const testSize = 100
+
+	serverHandlerTable := make(HandlerTable)
+	serverHandlerTable[metadata.PacketPing] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		outgoing = incoming
+		return
+	}
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	s, err := NewServer(serverHandlerTable, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	assert.Equal(t, uint64(0), s.ConcurrencyLimit())
+	s.SetAdaptiveConcurrency(AdaptiveOptions{InitialLimit: 4})
+	assert.Equal(t, uint64(4), s.ConcurrencyLimit())
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+
+	go s.ServeConn(serverConn)
+
+	c := NewAsync(clientConn, emptyLogger)
+
+	p := packet.Get()
+	p.Metadata.Operation = metadata.PacketPing
+	for i := 0; i < testSize; i++ {
+		p.Metadata.Id = uint16(i)
+		err = c.WritePacket(p)
+		require.NoError(t, err)
+	}
+	packet.Put(p)
+
+	for i := 0; i < testSize; i++ {
+		p, err = c.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, metadata.PacketPing, p.Metadata.Operation)
+		packet.Put(p)
+	}
+	assert.GreaterOrEqual(t, s.ConcurrencyLimit(), uint64(4))
+
+	err = c.Close()
+	assert.NoError(t, err)
+	err = s.Shutdown()
+	assert.NoError(t, err)
+}
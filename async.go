@@ -3,21 +3,21 @@
 package frisbee
 
 import (
-	"bufio"
 	"context"
 	"crypto/tls"
 	"encoding/binary"
 	"errors"
+	"io"
 	"net"
+	"runtime"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/loopholelabs/common/pkg/queue"
-	"github.com/loopholelabs/logging/loggers/noop"
 	"github.com/loopholelabs/logging/types"
 
 	"github.com/loopholelabs/frisbee-go/internal/dialer"
+	"github.com/loopholelabs/frisbee-go/internal/queue"
 	"github.com/loopholelabs/frisbee-go/pkg/metadata"
 	"github.com/loopholelabs/frisbee-go/pkg/packet"
 )
@@ -26,13 +26,22 @@ import (
 // can handle the specific frisbee requirements. This is not meant to be used on its own, and instead is
 // meant to be used by frisbee client and server implementations
 type Async struct {
-	sync.Mutex
 	conn               net.Conn
+	options            *Options
 	closed             atomic.Bool
-	writer             *bufio.Writer
-	flushCh            chan struct{}
+	writeQueue         *queue.MPSC[*frame]
+	writeCh            chan struct{}
+	writeMu            sync.Mutex
+	drainedCh          chan struct{}
+	buffered           atomic.Int64
+	producers          atomic.Int64
+	vectored           bool
+	frames             []*frame
+	buffers            net.Buffers
+	scratch            []byte
 	closeCh            chan struct{}
 	incoming           *queue.Circular[packet.Packet, *packet.Packet]
+	dropped            atomic.Uint64
 	staleMu            sync.Mutex
 	stale              []*packet.Packet
 	logger             types.Logger
@@ -47,18 +56,28 @@ type Async struct {
 
 // ConnectAsync creates a new TCP connection (using net.Dial) and wraps it in a frisbee connection
 func ConnectAsync(addr string, keepAlive time.Duration, logger types.Logger, TLSConfig *tls.Config, streamHandler ...NewStreamHandler) (*Async, error) {
+	return ConnectAsyncWithOptions(addr, loadOptions(WithKeepAlive(keepAlive), WithLogger(logger), WithTLS(TLSConfig)), streamHandler...)
+}
+
+// ConnectAsyncWithOptions creates a new TCP connection (using net.Dial) and wraps it in a frisbee connection
+// that is configured using the given Options
+func ConnectAsyncWithOptions(addr string, options *Options, streamHandler ...NewStreamHandler) (*Async, error) {
+	if options == nil {
+		options = loadOptions()
+	}
+
 	var conn net.Conn
 	var err error
 
 	d := dialer.NewRetry()
 
-	if TLSConfig != nil {
-		conn, err = d.DialTLS("tcp", addr, TLSConfig)
+	if options.TLSConfig != nil {
+		conn, err = d.DialTLS("tcp", addr, options.TLSConfig)
 	} else {
 		conn, err = d.Dial("tcp", addr)
 		if err == nil {
 			_ = conn.(*net.TCPConn).SetKeepAlive(true)
-			_ = conn.(*net.TCPConn).SetKeepAlivePeriod(keepAlive)
+			_ = conn.(*net.TCPConn).SetKeepAlivePeriod(options.KeepAlive)
 		}
 	}
 
@@ -66,23 +85,38 @@ func ConnectAsync(addr string, keepAlive time.Duration, logger types.Logger, TLS
 		return nil, err
 	}
 
-	return NewAsync(conn, logger, streamHandler...), nil
+	return NewAsyncWithOptions(conn, options, streamHandler...), nil
 }
 
 // NewAsync takes an existing net.Conn object and wraps it in a frisbee connection
 func NewAsync(c net.Conn, logger types.Logger, streamHandler ...NewStreamHandler) (conn *Async) {
+	return NewAsyncWithOptions(c, loadOptions(WithLogger(logger)), streamHandler...)
+}
+
+// NewAsyncWithOptions takes an existing net.Conn object and wraps it in a frisbee connection
+// that is configured using the given Options. Any unset fields in the Options will use their default values.
+func NewAsyncWithOptions(c net.Conn, options *Options, streamHandler ...NewStreamHandler) (conn *Async) {
+	if options == nil {
+		options = loadOptions()
+	} else {
+		options = loadOptions(WithOptions(*options))
+	}
+
 	conn = &Async{
-		conn:     c,
-		writer:   bufio.NewWriterSize(c, DefaultBufferSize),
-		incoming: queue.NewCircular[packet.Packet, *packet.Packet](DefaultBufferSize),
-		flushCh:  make(chan struct{}, 3),
-		closeCh:  make(chan struct{}),
-		streams:  make(map[uint16]*Stream),
-		logger:   logger,
+		conn:       c,
+		options:    options,
+		writeQueue: queue.NewMPSC[*frame](),
+		writeCh:    make(chan struct{}, 1),
+		incoming:   queue.NewCircular[packet.Packet, *packet.Packet](uint64(options.BufferSize)),
+		closeCh:    make(chan struct{}),
+		drainedCh:  make(chan struct{}),
+		streams:    make(map[uint16]*Stream),
+		logger:     options.Logger,
 	}
 
-	if logger == nil {
-		conn.logger = noop.New(types.InfoLevel)
+	switch c.(type) {
+	case *net.TCPConn, *net.UnixConn:
+		conn.vectored = true
 	}
 
 	if len(streamHandler) > 0 && streamHandler[0] != nil {
@@ -90,13 +124,15 @@ func NewAsync(c net.Conn, logger types.Logger, streamHandler ...NewStreamHandler
 	}
 
 	conn.wg.Add(1)
-	go conn.flushLoop()
+	go conn.writeLoop()
 
 	conn.wg.Add(1)
 	go conn.readLoop()
 
-	conn.wg.Add(1)
-	go conn.pingLoop()
+	if options.PingInterval > 0 {
+		conn.wg.Add(1)
+		go conn.pingLoop()
+	}
 
 	return
 }
@@ -174,11 +210,14 @@ func (c *Async) WritePacket(p *packet.Packet) error {
 	if p.Metadata.Operation <= RESERVED9 {
 		return InvalidOperation
 	}
-	return c.writePacket(p, true)
+	return c.writePacket(p)
 }
 
 // ReadPacket is a blocking function that will wait until a Frisbee packet is available and then return it (and its content).
 // In the event that the connection is closed, ReadPacket will return an error.
+//
+// The packet should be returned with packet.Put once it is no longer needed, or with packet.Release if its content
+// is large (for example, if it was read directly into its content buffer, see WithDirectReadThreshold).
 func (c *Async) ReadPacket() (*packet.Packet, error) {
 	if c.closed.Load() {
 		c.staleMu.Lock()
@@ -214,7 +253,8 @@ func (c *Async) ReadPacket() (*packet.Packet, error) {
 	return readPacket, nil
 }
 
-// Flush allows for synchronous messaging by flushing the write buffer and instantly sending packets
+// Flush allows for synchronous messaging by waiting until every packet that has been queued
+// with WritePacket has been written to the underlying net.Conn
 func (c *Async) Flush() error {
 	err := c.flush()
 	if err != nil {
@@ -223,16 +263,25 @@ func (c *Async) Flush() error {
 	return nil
 }
 
-// WriteBufferSize returns the size of the underlying write buffer (used for internal packet handling and for heartbeat logic)
+// WriteBufferSize returns the number of bytes that have been queued but not yet written (used for internal packet handling and for heartbeat logic)
 func (c *Async) WriteBufferSize() int {
-	c.Lock()
 	if c.closed.Load() {
-		c.Unlock()
 		return 0
 	}
-	i := c.writer.Buffered()
-	c.Unlock()
-	return i
+	return int(c.buffered.Load())
+}
+
+// Dropped returns the number of incoming packets that have been dropped by the connection's OverflowPolicy.
+//
+// Packets dropped by the OverflowPolicy of a Stream are counted by Stream.Dropped instead.
+func (c *Async) Dropped() uint64 {
+	return c.dropped.Load()
+}
+
+// MaxContentLength returns the largest content length that is accepted for incoming packets,
+// or 0 if it is unlimited (see WithMaxContentLength).
+func (c *Async) MaxContentLength() int {
+	return c.options.MaxContentLength
 }
 
 // Logger returns the underlying logger of the frisbee connection
@@ -293,132 +342,290 @@ func (c *Async) Close() error {
 }
 
 // write packet is the internal write packet function that does not check for reserved operations.
-func (c *Async) writePacket(p *packet.Packet, closeOnErr bool) error {
+//
+// Packets are encoded into a frame and queued for the write loop, so the packet can be reused
+// as soon as this function returns. Packets with more content than the DirectWriteThreshold are
+// not copied, and this function instead waits until the write loop has written them.
+func (c *Async) writePacket(p *packet.Packet) error {
 	if int(p.Metadata.ContentLength) != p.Content.Len() {
 		return InvalidContentLength
 	}
 
-	encodedMetadata := metadata.GetBuffer()
-	binary.BigEndian.PutUint16(encodedMetadata[metadata.IdOffset:metadata.IdOffset+metadata.IdSize], p.Metadata.Id)
-	binary.BigEndian.PutUint16(encodedMetadata[metadata.OperationOffset:metadata.OperationOffset+metadata.OperationSize], p.Metadata.Operation)
-	binary.BigEndian.PutUint32(encodedMetadata[metadata.ContentLengthOffset:metadata.ContentLengthOffset+metadata.ContentLengthSize], p.Metadata.ContentLength)
-
-	c.Lock()
 	if c.closed.Load() {
-		c.Unlock()
 		return ConnectionClosed
 	}
-	err := c.conn.SetWriteDeadline(time.Now().Add(DefaultDeadline))
-	if err != nil {
-		c.Unlock()
-		if c.closed.Load() {
-			c.Logger().Debug().Err(ConnectionClosed).Uint16("Packet ID", p.Metadata.Id).Msg("error while setting write deadline before writing packet")
-			return ConnectionClosed
-		}
-		c.Logger().Debug().Err(err).Uint16("Packet ID", p.Metadata.Id).Msg("error while setting write deadline before writing packet")
-		if closeOnErr {
-			return c.closeWithError(err)
+
+	if c.options.DirectWriteThreshold > 0 && int(p.Metadata.ContentLength) > c.options.DirectWriteThreshold {
+		f := encodeFrame(p, true)
+		done := make(chan error, 1)
+		f.done = done
+		if err := c.enqueue(f); err != nil {
+			putFrame(f, c.options.BufferSize)
+			return err
 		}
+		return c.wait(done)
+	}
+
+	f := encodeFrame(p, false)
+	if err := c.enqueue(f); err != nil {
+		putFrame(f, c.options.BufferSize)
 		return err
 	}
-	_, err = c.writer.Write(encodedMetadata[:])
-	metadata.PutBuffer(encodedMetadata)
-	if err != nil {
-		c.Unlock()
-		if c.closed.Load() {
-			c.Logger().Debug().Err(ConnectionClosed).Uint16("Packet ID", p.Metadata.Id).Msg("error while writing encoded metadata")
+	return nil
+}
+
+// writeFrom is the internal function used to write a packet whose content is copied from r by the write loop,
+// which allows the content to be sent with sendfile or splice if the underlying net.Conn supports it.
+//
+// This function blocks until the packet has been written, and does not check for reserved operations.
+func (c *Async) writeFrom(id uint16, operation uint16, r io.Reader, contentLength uint32) error {
+	if c.closed.Load() {
+		return ConnectionClosed
+	}
+
+	f := framePool.Get()
+	f.buf = appendMetadata(f.buf, id, operation, contentLength)
+	f.reader = &io.LimitedReader{R: r, N: int64(contentLength)}
+	done := make(chan error, 1)
+	f.done = done
+	if err := c.enqueue(f); err != nil {
+		putFrame(f, c.options.BufferSize)
+		return err
+	}
+	return c.wait(done)
+}
+
+// flush is an internal function that waits for the write loop to write every frame that was queued before it,
+// however it is unique in that it does not call closeWithError (and so does not try and close the underlying connection)
+// when it encounters an error, and instead leaves that responsibility to its parent caller
+func (c *Async) flush() error {
+	if c.closed.Load() {
+		return ConnectionClosed
+	}
+
+	done := make(chan error, 1)
+	if err := c.enqueue(&frame{done: done}); err != nil {
+		return err
+	}
+	return c.wait(done)
+}
+
+// enqueue queues the frame for the write loop, or returns ConnectionClosed if the connection has been closed.
+//
+// Producers are counted while they enqueue, so that close can wait for every producer that saw the connection
+// open before it drains the write queue for the last time, and no frame is ever left in the queue unwritten.
+func (c *Async) enqueue(f *frame) error {
+	c.producers.Add(1)
+	if c.closed.Load() {
+		c.producers.Add(-1)
+		return ConnectionClosed
+	}
+	c.buffered.Add(int64(f.size()))
+	c.writeQueue.Push(f)
+	c.producers.Add(-1)
+	c.signalWrite()
+	return nil
+}
+
+// wait blocks until the write loop has written the frame that owns done. If the connection is closed
+// in the meantime, it waits for the remaining frames to be drained and returns ConnectionClosed if the
+// frame was never written.
+func (c *Async) wait(done chan error) error {
+	select {
+	case err := <-done:
+		return err
+	case <-c.closeCh:
+		<-c.drainedCh
+		select {
+		case err := <-done:
+			return err
+		default:
 			return ConnectionClosed
 		}
-		c.Logger().Debug().Err(err).Uint16("Packet ID", p.Metadata.Id).Msg("error while writing encoded metadata")
-		if closeOnErr {
-			return c.closeWithError(err)
+	}
+}
+
+// signalWrite wakes up the write loop without blocking if it has already been signalled
+func (c *Async) signalWrite() {
+	select {
+	case c.writeCh <- struct{}{}:
+	default:
+	}
+}
+
+// drainWrites pops every frame off of the write queue and writes them to the underlying net.Conn in batches.
+//
+// This function must only be called from the write loop while holding the writeMu, or once the write loop has exited.
+func (c *Async) drainWrites() error {
+	var size int
+	for f, ok := c.writeQueue.Pop(); ok; f, ok = c.writeQueue.Pop() {
+		c.frames = append(c.frames, f)
+		size += f.size()
+		if f.done != nil || size >= c.options.BufferSize {
+			if err := c.writeFrames(); err != nil {
+				return err
+			}
+			size = 0
 		}
-		return err
 	}
-	if p.Metadata.ContentLength != 0 {
-		_, err = c.writer.Write(p.Content.Bytes()[:p.Metadata.ContentLength])
-		if err != nil {
-			c.Unlock()
-			if c.closed.Load() {
-				c.Logger().Debug().Err(ConnectionClosed).Uint16("Packet ID", p.Metadata.Id).Msg("error while writing packet content")
-				return ConnectionClosed
+	return c.writeFrames()
+}
+
+// writeFrames writes the current batch of frames to the underlying net.Conn, using vectored writes
+// if the net.Conn supports it and coalesced writes otherwise, and then returns the frames to the pool.
+func (c *Async) writeFrames() error {
+	if len(c.frames) == 0 {
+		return nil
+	}
+
+	var size int
+	for _, f := range c.frames {
+		size += f.size()
+	}
+
+	err := c.conn.SetWriteDeadline(time.Now().Add(c.options.Deadline))
+	if err == nil {
+		for _, f := range c.frames {
+			if c.vectored {
+				c.buffers = append(c.buffers, f.buf)
+				if f.content != nil {
+					c.buffers = append(c.buffers, f.content)
+				}
+			} else {
+				c.scratch = append(c.scratch, f.buf...)
+				if f.content != nil {
+					if err = c.writePending(); err == nil {
+						_, err = c.conn.Write(f.content)
+					}
+				}
+			}
+			if err == nil && f.reader != nil {
+				if err = c.writePending(); err == nil {
+					_, err = io.Copy(c.conn, f.reader)
+					if err == nil && f.reader.N > 0 {
+						err = io.ErrUnexpectedEOF
+					}
+				}
 			}
-			c.Logger().Debug().Err(err).Uint16("Packet ID", p.Metadata.Id).Msg("error while writing packet content")
-			if closeOnErr {
-				return c.closeWithError(err)
+			if err != nil {
+				break
 			}
-			return err
+		}
+		if err == nil {
+			err = c.writePending()
 		}
 	}
 
-	if len(c.flushCh) == 0 {
-		select {
-		case c.flushCh <- struct{}{}:
-		default:
-		}
+	clear(c.buffers)
+	c.buffers = c.buffers[:0]
+	if cap(c.scratch) > c.options.BufferSize*4 {
+		c.scratch = nil
+	} else {
+		c.scratch = c.scratch[:0]
 	}
 
-	c.Unlock()
+	if err != nil && c.closed.Load() {
+		c.Logger().Debug().Err(ConnectionClosed).Msg("error while writing queued packets")
+		err = ConnectionClosed
+	} else if err != nil {
+		c.Logger().Debug().Err(err).Msg("error while writing queued packets")
+	}
 
-	return nil
+	for i, f := range c.frames {
+		if f.done != nil {
+			f.done <- err
+		}
+		putFrame(f, c.options.BufferSize)
+		c.frames[i] = nil
+	}
+	c.frames = c.frames[:0]
+	c.buffered.Add(-int64(size))
+
+	return err
 }
 
-// flush is an internal function for flushing data from the write buffer, however
-// it is unique in that it does not call closeWithError (and so does not try and close the underlying connection)
-// when it encounters an error, and instead leaves that responsibility to its parent caller
-func (c *Async) flush() error {
-	c.Lock()
-	if c.closed.Load() {
-		c.Unlock()
-		return ConnectionClosed
+// writePending writes any buffers or coalesced data that writeFrames has not yet written to the underlying net.Conn
+func (c *Async) writePending() (err error) {
+	if c.vectored {
+		if len(c.buffers) > 0 {
+			buffers := c.buffers
+			_, err = buffers.WriteTo(c.conn)
+			clear(c.buffers)
+			c.buffers = c.buffers[:0]
+		}
+	} else if len(c.scratch) > 0 {
+		_, err = c.conn.Write(c.scratch)
+		c.scratch = c.scratch[:0]
 	}
-	if c.writer.Buffered() > 0 {
-		err := c.conn.SetWriteDeadline(time.Now().Add(DefaultDeadline))
-		if err != nil {
-			c.Unlock()
-			return err
+	return
+}
+
+// push adds a packet to the given queue, using the given OverflowPolicy if the queue is full.
+//
+// Any packets that are dropped are returned to the packet pool and counted in dropped.
+func (c *Async) push(q *queue.Circular[packet.Packet, *packet.Packet], policy OverflowPolicy, dropped *atomic.Uint64, p *packet.Packet) error {
+	switch policy {
+	case OverflowDropNewest:
+		err := q.TryPush(p)
+		if errors.Is(err, queue.FullError) {
+			c.Logger().Trace().Uint16("Packet ID", p.Metadata.Id).Msg("packet queue full, dropping newest packet")
+			packet.Put(p)
+			dropped.Add(1)
+			return nil
 		}
-		err = c.writer.Flush()
+		return err
+	case OverflowDropOldest:
+		evicted, err := q.PushEvict(p)
 		if err != nil {
-			c.Unlock()
-			c.Logger().Error().Err(err).Msg("error while flushing data")
 			return err
 		}
+		if evicted != nil {
+			c.Logger().Trace().Uint16("Packet ID", evicted.Metadata.Id).Msg("packet queue full, dropping oldest packet")
+			packet.Put(evicted)
+			dropped.Add(1)
+		}
+		return nil
+	case OverflowClose:
+		err := q.TryPush(p)
+		if errors.Is(err, queue.FullError) {
+			packet.Put(p)
+			dropped.Add(1)
+			return QueueFull
+		}
+		return err
+	default:
+		return q.Push(p)
 	}
-	c.Unlock()
-	return nil
 }
 
 func (c *Async) close() error {
 	c.staleMu.Lock()
-	c.streamsMu.Lock()
 	if c.closed.CompareAndSwap(false, true) {
 		c.Logger().Debug().Msg("connection close called, killing goroutines")
-		c.Lock()
 		c.incoming.Close()
 		close(c.closeCh)
-		close(c.flushCh)
-		c.Unlock()
+		c.writeMu.Lock()
 		_ = c.conn.SetDeadline(pastTime)
+		c.writeMu.Unlock()
 		c.wg.Wait()
 		_ = c.conn.SetDeadline(emptyTime)
 		c.stale = c.incoming.Drain()
 		c.staleMu.Unlock()
+		// The streams are only locked once the read loop has exited, since it locks them while handling stream packets
+		c.streamsMu.Lock()
 		for _, stream := range c.streams {
 			_ = stream.closeSend(false)
 		}
 		c.streamsMu.Unlock()
-		c.Lock()
-		if c.writer.Buffered() > 0 {
-			_ = c.conn.SetWriteDeadline(time.Now().Add(DefaultDeadline))
-			_ = c.writer.Flush()
-			_ = c.conn.SetWriteDeadline(emptyTime)
+		for c.producers.Load() > 0 {
+			runtime.Gosched()
 		}
-		c.Unlock()
+		_ = c.drainWrites()
+		_ = c.conn.SetWriteDeadline(emptyTime)
+		close(c.drainedCh)
 		return nil
 	}
 	c.staleMu.Unlock()
-	c.streamsMu.Unlock()
 	return ConnectionClosed
 }
 
@@ -437,24 +644,33 @@ func (c *Async) closeWithError(err error) error {
 	return err
 }
 
-func (c *Async) flushLoop() {
+func (c *Async) writeLoop() {
 	var err error
 	for {
-		if _, ok := <-c.flushCh; !ok {
-			c.wg.Done()
-			return
-		}
-		err = c.flush()
-		if err != nil {
+		select {
+		case <-c.closeCh:
 			c.wg.Done()
-			_ = c.closeWithError(err)
 			return
+		case <-c.writeCh:
+			c.writeMu.Lock()
+			if c.closed.Load() {
+				c.writeMu.Unlock()
+				c.wg.Done()
+				return
+			}
+			err = c.drainWrites()
+			c.writeMu.Unlock()
+			if err != nil {
+				c.wg.Done()
+				_ = c.closeWithError(err)
+				return
+			}
 		}
 	}
 }
 
 func (c *Async) pingLoop() {
-	ticker := time.NewTicker(DefaultPingInterval)
+	ticker := time.NewTicker(c.options.PingInterval)
 	defer ticker.Stop()
 	var err error
 	for {
@@ -463,7 +679,7 @@ func (c *Async) pingLoop() {
 			c.wg.Done()
 			return
 		case <-ticker.C:
-			err = c.writePacket(PINGPacket, false)
+			err = c.writePacket(PINGPacket)
 			if err != nil {
 				c.wg.Done()
 				_ = c.closeWithError(err)
@@ -474,7 +690,7 @@ func (c *Async) pingLoop() {
 }
 
 func (c *Async) readLoop() {
-	buf := make([]byte, DefaultBufferSize)
+	buf := make([]byte, c.options.BufferSize)
 	var index int
 	var stream *Stream
 	var isStream bool
@@ -492,7 +708,7 @@ func (c *Async) readLoop() {
 		var err error
 		for n < metadata.Size {
 			var nn int
-			err = c.conn.SetReadDeadline(time.Now().Add(DefaultDeadline))
+			err = c.conn.SetReadDeadline(time.Now().Add(c.options.Deadline))
 			if err != nil {
 				c.Logger().Debug().Err(err).Msg("error setting read deadline during read loop, calling closeWithError")
 				c.wg.Done()
@@ -519,10 +735,18 @@ func (c *Async) readLoop() {
 			p.Metadata.ContentLength = binary.BigEndian.Uint32(buf[index+metadata.ContentLengthOffset : index+metadata.ContentLengthOffset+metadata.ContentLengthSize])
 			index += metadata.Size
 
+			if c.options.MaxContentLength > 0 && int64(p.Metadata.ContentLength) > int64(c.options.MaxContentLength) {
+				c.Logger().Debug().Uint32("contentLength", p.Metadata.ContentLength).Msg("content length exceeds the maximum during read loop, calling closeWithError")
+				packet.Put(p)
+				c.wg.Done()
+				_ = c.closeWithError(ContentLengthExceeded)
+				return
+			}
+
 			switch p.Metadata.Operation {
 			case PING:
 				c.Logger().Trace().Msg("PING Packet received by read loop, sending back PONG packet")
-				err = c.writePacket(PONGPacket, false)
+				err = c.writePacket(PONGPacket)
 				if err != nil {
 					c.wg.Done()
 					_ = c.closeWithError(err)
@@ -546,7 +770,34 @@ func (c *Async) readLoop() {
 				fallthrough
 			default:
 				if p.Metadata.ContentLength > 0 {
-					if n-index < int(p.Metadata.ContentLength) {
+					if c.options.DirectReadThreshold > 0 && n-index < int(p.Metadata.ContentLength) && int(p.Metadata.ContentLength) > c.options.DirectReadThreshold {
+						minSize := int(p.Metadata.ContentLength) - p.Content.Write(buf[index:n])
+						p.Content.Grow(minSize)
+						p.Content.MoveOffset(minSize)
+						content := p.Content.Bytes()[int(p.Metadata.ContentLength)-minSize:]
+						n = 0
+						for n < minSize {
+							var nn int
+							err = c.conn.SetReadDeadline(time.Now().Add(c.options.Deadline))
+							if err != nil {
+								c.wg.Done()
+								_ = c.closeWithError(err)
+								return
+							}
+							nn, err = c.conn.Read(content[n:])
+							n += nn
+							if err != nil {
+								if n < minSize {
+									c.wg.Done()
+									_ = c.closeWithError(err)
+									return
+								}
+								break
+							}
+						}
+						n = 0
+						index = 0
+					} else if n-index < int(p.Metadata.ContentLength) {
 						minSize := int(p.Metadata.ContentLength) - p.Content.Write(buf[index:n])
 						n = 0
 						for cap(buf) < minSize {
@@ -555,7 +806,7 @@ func (c *Async) readLoop() {
 						buf = buf[:cap(buf)]
 						for n < minSize {
 							var nn int
-							err = c.conn.SetReadDeadline(time.Now().Add(DefaultDeadline))
+							err = c.conn.SetReadDeadline(time.Now().Add(c.options.Deadline))
 							if err != nil {
 								c.wg.Done()
 								_ = c.closeWithError(err)
@@ -579,7 +830,7 @@ func (c *Async) readLoop() {
 					}
 				}
 				if !isStream {
-					err = c.incoming.Push(p)
+					err = c.push(c.incoming, c.options.IncomingOverflow, &c.dropped, p)
 					if err != nil {
 						c.Logger().Debug().Err(err).Msg("error while pushing to incoming packet queue")
 						c.wg.Done()
@@ -607,7 +858,7 @@ func (c *Async) readLoop() {
 								c.streamsMu.Unlock()
 								go newStreamHandler(stream)
 							}
-							err = stream.queue.Push(p)
+							err = c.push(stream.queue, c.options.StreamOverflow, &stream.dropped, p)
 							if err != nil {
 								c.Logger().Debug().Err(err).Msg("error while pushing to a stream queue packet queue")
 								c.wg.Done()
@@ -632,7 +883,7 @@ func (c *Async) readLoop() {
 				n = 0
 				for n < metadata.Size {
 					var nn int
-					err = c.conn.SetReadDeadline(time.Now().Add(DefaultDeadline))
+					err = c.conn.SetReadDeadline(time.Now().Add(c.options.Deadline))
 					if err != nil {
 						c.wg.Done()
 						_ = c.closeWithError(err)
@@ -664,7 +915,7 @@ func (c *Async) readLoop() {
 				n = 0
 				for n < minSize {
 					var nn int
-					err = c.conn.SetReadDeadline(time.Now().Add(DefaultDeadline))
+					err = c.conn.SetReadDeadline(time.Now().Add(c.options.Deadline))
 					if err != nil {
 						c.wg.Done()
 						_ = c.closeWithError(err)
@@ -4,10 +4,12 @@ package frisbee
 
 import (
 	"crypto/rand"
+	"encoding/binary"
 	"io"
 	"net"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -18,6 +20,7 @@ import (
 	"github.com/loopholelabs/polyglot/v2"
 	"github.com/loopholelabs/testing/conn/pair"
 
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
 	"github.com/loopholelabs/frisbee-go/pkg/packet"
 )
 
@@ -79,6 +82,111 @@ func TestNewAsync(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestNewAsyncWithOptions(t *testing.T) {
+	t.Parallel()
+
+	const packetSize = 512
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	reader, writer := net.Pipe()
+
+	options := &Options{
+		Logger:           emptyLogger,
+		Deadline:         time.Second,
+		PingInterval:     -1,
+		BufferSize:       64,
+		StreamBufferSize: 8,
+	}
+
+	readerConn := NewAsyncWithOptions(reader, options)
+	writerConn := NewAsyncWithOptions(writer, options)
+
+	assert.Equal(t, time.Second, writerConn.options.Deadline)
+	assert.Equal(t, time.Duration(-1), writerConn.options.PingInterval)
+	assert.Equal(t, 64, writerConn.options.BufferSize)
+	assert.Equal(t, 8, writerConn.options.StreamBufferSize)
+	assert.Equal(t, time.Minute*3, writerConn.options.KeepAlive)
+
+	data := make([]byte, packetSize)
+	_, _ = rand.Read(data)
+
+	p := packet.Get()
+	p.Metadata.Id = 64
+	p.Metadata.Operation = 32
+	p.Content.Write(data)
+	p.Metadata.ContentLength = packetSize
+
+	err := writerConn.WritePacket(p)
+	require.NoError(t, err)
+
+	packet.Put(p)
+
+	p, err = readerConn.ReadPacket()
+	require.NoError(t, err)
+	assert.NotNil(t, p.Metadata)
+	assert.Equal(t, uint16(64), p.Metadata.Id)
+	assert.Equal(t, uint16(32), p.Metadata.Operation)
+	assert.Equal(t, uint32(packetSize), p.Metadata.ContentLength)
+	expected := polyglot.NewBufferFromBytes(data)
+	expected.MoveOffset(len(data))
+	assert.Equal(t, expected.Bytes(), p.Content.Bytes())
+
+	packet.Put(p)
+
+	err = readerConn.Close()
+	assert.NoError(t, err)
+	err = writerConn.Close()
+	assert.NoError(t, err)
+}
+
+func TestAsyncInvalidBufferSize(t *testing.T) {
+	t.Parallel()
+
+	const packetSize = 512
+
+	for _, bufferSize := range []int{-1, 1} {
+		emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+		reader, writer := net.Pipe()
+
+		options := &Options{
+			Logger:           emptyLogger,
+			BufferSize:       bufferSize,
+			StreamBufferSize: -1,
+		}
+
+		readerConn := NewAsyncWithOptions(reader, options)
+		writerConn := NewAsyncWithOptions(writer, options)
+
+		data := make([]byte, packetSize)
+		_, _ = rand.Read(data)
+
+		p := packet.Get()
+		p.Metadata.Id = 64
+		p.Metadata.Operation = 32
+		p.Content.Write(data)
+		p.Metadata.ContentLength = packetSize
+
+		err := writerConn.WritePacket(p)
+		require.NoError(t, err)
+
+		packet.Put(p)
+
+		p, err = readerConn.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, uint32(packetSize), p.Metadata.ContentLength)
+		assert.Equal(t, data, p.Content.Bytes())
+
+		packet.Put(p)
+
+		err = readerConn.Close()
+		assert.NoError(t, err)
+		err = writerConn.Close()
+		assert.NoError(t, err)
+	}
+}
+
 func TestAsyncLargeWrite(t *testing.T) {
 	t.Parallel()
 
@@ -128,6 +236,219 @@ func TestAsyncLargeWrite(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestAsyncDirectRead(t *testing.T) {
+	t.Parallel()
+
+	const testSize = 10
+	const smallPacketSize = 512
+	const largePacketSize = 1 << 18
+
+	for _, threshold := range []int{DefaultBufferSize, -1} {
+		emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+		reader, writer, err := pair.New()
+		require.NoError(t, err)
+
+		readerConn := NewAsyncWithOptions(reader, &Options{Logger: emptyLogger, DirectReadThreshold: threshold})
+		writerConn := NewAsync(writer, emptyLogger)
+
+		randomData := make([][]byte, testSize)
+		p := packet.Get()
+		p.Metadata.Operation = 32
+		for i := 0; i < testSize; i++ {
+			if i%2 == 0 {
+				randomData[i] = make([]byte, largePacketSize)
+			} else {
+				randomData[i] = make([]byte, smallPacketSize)
+			}
+			_, _ = rand.Read(randomData[i])
+			p.Metadata.Id = uint16(i)
+			p.Metadata.ContentLength = uint32(len(randomData[i]))
+			p.Content.Write(randomData[i])
+			err = writerConn.WritePacket(p)
+			require.NoError(t, err)
+			p.Content.Reset()
+		}
+		packet.Put(p)
+
+		err = writerConn.Flush()
+		require.NoError(t, err)
+
+		for i := 0; i < testSize; i++ {
+			p, err = readerConn.ReadPacket()
+			require.NoError(t, err)
+			assert.Equal(t, uint16(i), p.Metadata.Id)
+			assert.Equal(t, uint32(len(randomData[i])), p.Metadata.ContentLength)
+			assert.Equal(t, randomData[i], p.Content.Bytes())
+			packet.Release(p)
+		}
+
+		err = readerConn.Close()
+		assert.NoError(t, err)
+		err = writerConn.Close()
+		assert.NoError(t, err)
+	}
+}
+
+func TestAsyncMaxContentLength(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	reader, writer, err := pair.New()
+	require.NoError(t, err)
+
+	readerConn := NewAsyncWithOptions(reader, &Options{Logger: emptyLogger, MaxContentLength: 1024})
+	writerConn := NewAsync(writer, emptyLogger)
+
+	p := packet.Get()
+	p.Metadata.Operation = 32
+	p.Content.Write(make([]byte, 1024))
+	p.Metadata.ContentLength = 1024
+	err = writerConn.WritePacket(p)
+	require.NoError(t, err)
+
+	// Packets with a larger content length close the connection before their content is read
+	p.Content.Write([]byte{0})
+	p.Metadata.ContentLength = 1025
+	err = writerConn.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	p, err = readerConn.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1024), p.Metadata.ContentLength)
+	packet.Put(p)
+
+	_, err = readerConn.ReadPacket()
+	assert.ErrorIs(t, err, ConnectionClosed)
+	assert.ErrorIs(t, readerConn.Error(), ContentLengthExceeded)
+
+	_ = readerConn.Close()
+	_ = writerConn.Close()
+}
+
+func TestAsyncDirectWrite(t *testing.T) {
+	t.Parallel()
+
+	const testSize = 10
+	const smallPacketSize = 512
+	const largePacketSize = 1 << 18
+
+	for _, network := range []string{"pipe", "tcp"} {
+		emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+		var reader, writer net.Conn
+		if network == "tcp" {
+			var err error
+			reader, writer, err = pair.New()
+			require.NoError(t, err)
+		} else {
+			reader, writer = net.Pipe()
+		}
+
+		readerConn := NewAsync(reader, emptyLogger)
+		writerConn := NewAsync(writer, emptyLogger)
+		assert.Equal(t, network == "tcp", writerConn.vectored)
+
+		randomData := make([][]byte, testSize)
+		for i := 0; i < testSize; i++ {
+			if i%2 == 0 {
+				randomData[i] = make([]byte, largePacketSize)
+			} else {
+				randomData[i] = make([]byte, smallPacketSize)
+			}
+			_, _ = rand.Read(randomData[i])
+		}
+
+		errCh := make(chan error, 1)
+		go func() {
+			p := packet.Get()
+			p.Metadata.Operation = 32
+			for i := 0; i < testSize; i++ {
+				p.Metadata.Id = uint16(i)
+				p.Metadata.ContentLength = uint32(len(randomData[i]))
+				p.Content.Write(randomData[i])
+				if err := writerConn.WritePacket(p); err != nil {
+					errCh <- err
+					return
+				}
+				p.Content.Reset()
+			}
+			packet.Put(p)
+			errCh <- writerConn.Flush()
+		}()
+
+		for i := 0; i < testSize; i++ {
+			p, err := readerConn.ReadPacket()
+			require.NoError(t, err)
+			assert.Equal(t, uint16(i), p.Metadata.Id)
+			assert.Equal(t, uint32(len(randomData[i])), p.Metadata.ContentLength)
+			assert.Equal(t, randomData[i], p.Content.Bytes())
+			packet.Put(p)
+		}
+		require.NoError(t, <-errCh)
+
+		err := readerConn.Close()
+		assert.NoError(t, err)
+		err = writerConn.Close()
+		assert.NoError(t, err)
+	}
+}
+
+func TestAsyncConcurrentWrite(t *testing.T) {
+	t.Parallel()
+
+	const writers = 8
+	const testSize = 1000
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	reader, writer, err := pair.New()
+	require.NoError(t, err)
+
+	readerConn := NewAsync(reader, emptyLogger)
+	writerConn := NewAsync(writer, emptyLogger)
+
+	var wg sync.WaitGroup
+	wg.Add(writers)
+	for i := 0; i < writers; i++ {
+		go func(id uint16) {
+			defer wg.Done()
+			p := packet.Get()
+			p.Metadata.Id = id
+			p.Metadata.Operation = 32
+			p.Metadata.ContentLength = 4
+			for j := uint32(0); j < testSize; j++ {
+				p.Content.Reset()
+				p.Content.Write(binary.BigEndian.AppendUint32(nil, j))
+				assert.NoError(t, writerConn.WritePacket(p))
+			}
+			packet.Put(p)
+		}(uint16(i))
+	}
+	wg.Wait()
+
+	err = writerConn.Flush()
+	require.NoError(t, err)
+	assert.Equal(t, 0, writerConn.WriteBufferSize())
+
+	next := make([]uint32, writers)
+	for i := 0; i < writers*testSize; i++ {
+		p, err := readerConn.ReadPacket()
+		require.NoError(t, err)
+		require.Equal(t, uint32(4), p.Metadata.ContentLength)
+		assert.Equal(t, next[p.Metadata.Id], binary.BigEndian.Uint32(p.Content.Bytes()))
+		next[p.Metadata.Id]++
+		packet.Put(p)
+	}
+
+	err = readerConn.Close()
+	assert.NoError(t, err)
+	err = writerConn.Close()
+	assert.NoError(t, err)
+}
+
 func TestAsyncRawConn(t *testing.T) {
 	t.Parallel()
 
@@ -343,6 +664,114 @@ func TestAsyncWriteClose(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestAsyncWriteCloseRace(t *testing.T) {
+	t.Parallel()
+
+	const writers = 8
+	const packets = 1000
+
+	for i := 0; i < 10; i++ {
+		emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+		reader, writer, err := pair.New()
+		require.NoError(t, err)
+
+		readerConn := NewAsync(reader, emptyLogger)
+		writerConn := NewAsync(writer, emptyLogger)
+
+		var written atomic.Int64
+		var wg sync.WaitGroup
+		wg.Add(writers)
+		for j := 0; j < writers; j++ {
+			go func() {
+				defer wg.Done()
+				// Large packets take longer to encode, which makes it more likely that the connection is closed while they are being queued
+				p := packet.Get()
+				p.Metadata.Operation = 32
+				p.Content.Write(make([]byte, DefaultBufferSize/8))
+				p.Metadata.ContentLength = uint32(p.Content.Len())
+				for k := 0; k < packets; k++ {
+					err := writerConn.WritePacket(p)
+					if err != nil {
+						assert.ErrorIs(t, err, ConnectionClosed)
+						break
+					}
+					written.Add(1)
+				}
+				packet.Put(p)
+			}()
+		}
+
+		runtime.Gosched()
+		err = writerConn.Close()
+		assert.NoError(t, err)
+		wg.Wait()
+
+		// Every packet that was queued without an error is written before the connection is closed
+		var read int64
+		for {
+			p, err := readerConn.ReadPacket()
+			if err != nil {
+				break
+			}
+			read++
+			packet.Put(p)
+		}
+		assert.Equal(t, written.Load(), read)
+
+		_ = readerConn.Close()
+	}
+}
+
+func TestAsyncEnqueueClose(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	reader, writer, err := pair.New()
+	require.NoError(t, err)
+
+	readerConn := NewAsync(reader, emptyLogger)
+	writerConn := NewAsync(writer, emptyLogger)
+
+	// A producer has seen that the connection is open, but has not queued its frame yet
+	writerConn.producers.Add(1)
+
+	closed := make(chan struct{})
+	go func() {
+		assert.NoError(t, writerConn.Close())
+		close(closed)
+	}()
+	require.Eventually(t, writerConn.Closed, DefaultDeadline, time.Millisecond)
+
+	p := packet.Get()
+	p.Metadata.Id = 64
+	p.Metadata.Operation = 32
+
+	// New producers see that the connection is closed
+	err = writerConn.WritePacket(p)
+	assert.ErrorIs(t, err, ConnectionClosed)
+
+	// Close waits for the producer before it drains the write queue for the last time
+	select {
+	case <-closed:
+		t.Fatal("close should wait for producers that are still queuing frames")
+	case <-time.After(time.Millisecond * 50):
+	}
+	writerConn.buffered.Add(int64(metadata.Size))
+	writerConn.writeQueue.Push(encodeFrame(p, false))
+	writerConn.producers.Add(-1)
+	packet.Put(p)
+	<-closed
+
+	p, err = readerConn.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(64), p.Metadata.Id)
+	packet.Put(p)
+
+	_ = readerConn.Close()
+}
+
 func TestAsyncTimeout(t *testing.T) {
 	t.Parallel()
 
@@ -422,6 +851,107 @@ func TestAsyncTimeout(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestAsyncOverflow(t *testing.T) {
+	t.Parallel()
+
+	const testSize = 100
+	const queueSize = 63
+
+	writePackets := func(t *testing.T, writerConn *Async) {
+		p := packet.Get()
+		p.Metadata.Operation = 32
+		for i := 0; i < testSize; i++ {
+			p.Metadata.Id = uint16(i)
+			err := writerConn.WritePacket(p)
+			require.NoError(t, err)
+		}
+		packet.Put(p)
+		err := writerConn.Flush()
+		require.NoError(t, err)
+	}
+
+	t.Run("drop newest", func(t *testing.T) {
+		emptyLogger := logging.Test(t, logging.Noop, t.Name())
+		reader, writer := net.Pipe()
+
+		readerConn := NewAsyncWithOptions(reader, &Options{Logger: emptyLogger, BufferSize: queueSize, IncomingOverflow: OverflowDropNewest})
+		writerConn := NewAsync(writer, emptyLogger)
+
+		writePackets(t, writerConn)
+		require.Eventually(t, func() bool {
+			return readerConn.Dropped() == testSize-queueSize
+		}, DefaultDeadline, time.Millisecond*10)
+
+		for i := 0; i < queueSize; i++ {
+			p, err := readerConn.ReadPacket()
+			require.NoError(t, err)
+			assert.Equal(t, uint16(i), p.Metadata.Id)
+			packet.Put(p)
+		}
+
+		assert.NoError(t, readerConn.Close())
+		assert.NoError(t, writerConn.Close())
+	})
+
+	t.Run("drop oldest", func(t *testing.T) {
+		emptyLogger := logging.Test(t, logging.Noop, t.Name())
+		reader, writer := net.Pipe()
+
+		readerConn := NewAsyncWithOptions(reader, &Options{Logger: emptyLogger, BufferSize: queueSize, IncomingOverflow: OverflowDropOldest})
+		writerConn := NewAsync(writer, emptyLogger)
+
+		writePackets(t, writerConn)
+		require.Eventually(t, func() bool {
+			return readerConn.Dropped() == testSize-queueSize
+		}, DefaultDeadline, time.Millisecond*10)
+
+		for i := testSize - queueSize; i < testSize; i++ {
+			p, err := readerConn.ReadPacket()
+			require.NoError(t, err)
+			assert.Equal(t, uint16(i), p.Metadata.Id)
+			packet.Put(p)
+		}
+
+		assert.NoError(t, readerConn.Close())
+		assert.NoError(t, writerConn.Close())
+	})
+
+	t.Run("close", func(t *testing.T) {
+		emptyLogger := logging.Test(t, logging.Noop, t.Name())
+		reader, writer := net.Pipe()
+
+		readerConn := NewAsyncWithOptions(reader, &Options{Logger: emptyLogger, BufferSize: queueSize, IncomingOverflow: OverflowClose})
+		writerConn := NewAsync(writer, emptyLogger)
+
+		p := packet.Get()
+		p.Metadata.Operation = 32
+		for i := 0; i < testSize; i++ {
+			p.Metadata.Id = uint16(i)
+			if writerConn.WritePacket(p) != nil {
+				break
+			}
+		}
+		packet.Put(p)
+		_ = writerConn.Flush()
+
+		require.Eventually(t, func() bool {
+			return readerConn.Closed()
+		}, DefaultDeadline, time.Millisecond*10)
+		assert.ErrorIs(t, readerConn.Error(), QueueFull)
+		assert.Equal(t, uint64(1), readerConn.Dropped())
+
+		for i := 0; i < queueSize; i++ {
+			p, err := readerConn.ReadPacket()
+			require.NoError(t, err)
+			assert.Equal(t, uint16(i), p.Metadata.Id)
+			packet.Put(p)
+		}
+
+		_ = readerConn.Close()
+		_ = writerConn.Close()
+	})
+}
+
 func BenchmarkAsyncThroughputPipe(b *testing.B) {
 	const testSize = 100
 
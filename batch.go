@@ -0,0 +1,108 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+const (
+	// DefaultBatchLimit is the maximum number of packets passed to a BatchHandler at once if no limit is set
+	DefaultBatchLimit = 128
+)
+
+// BatchHandler is an alternative to a Handler that handles many packets with the same operation at once. It is
+// called with every packet for its operation that has already been received on a connection (in the order they
+// were received), up to the operation's batch limit, and returns the responses for the batch (which are sent in
+// order, and can be the incoming packets themselves) and an Action that is used in the same way as the Action
+// returned by a Handler.
+//
+// A BatchHandler never waits for more packets to arrive, so the batches it is called with can contain as few as
+// one packet, and none of the incoming or outgoing packets can be used once it has returned, since they are
+// returned to the packet pool.
+type BatchHandler func(ctx context.Context, incoming []*packet.Packet) (outgoing []*packet.Packet, action Action)
+
+// batchOperation is the BatchHandler and batch limit for an operation
+type batchOperation struct {
+	handler BatchHandler
+	limit   int
+}
+
+// batchResult is the result of running a BatchHandler, which has no outgoing
+// packets and the NONE action if the BatchHandler panicked
+type batchResult struct {
+	outgoing []*packet.Packet
+	action   Action
+	panic    *PanicError
+}
+
+// batches holds the packets that have been received for each batched operation on a
+// connection but not yet handled, and is only used by the goroutine that reads the packets
+type batches map[uint16][]*packet.Packet
+
+// add adds the packet to the batch for its operation, and returns the batch if it has reached the given limit
+func (b batches) add(p *packet.Packet, limit int) []*packet.Packet {
+	batch := append(b[p.Metadata.Operation], p)
+	if len(batch) >= limit {
+		delete(b, p.Metadata.Operation)
+		return batch
+	}
+	b[p.Metadata.Operation] = batch
+	return nil
+}
+
+// flush calls f with every pending batch, and removes them
+func (b batches) flush(f func(operation uint16, batch []*packet.Packet)) {
+	for operation, batch := range b {
+		delete(b, operation)
+		f(operation, batch)
+	}
+}
+
+// release returns every pending packet to the packet pool, and removes them
+func (b batches) release() {
+	b.flush(func(_ uint16, batch []*packet.Packet) {
+		releaseBatch(batch, nil)
+	})
+}
+
+// callBatchHandler runs the BatchHandler for the incoming packets, recovering from any panic in the BatchHandler
+func callBatchHandler(ctx context.Context, handler BatchHandler, operation uint16, incoming []*packet.Packet) (result batchResult) {
+	if err := recoverPanic(operation, func() {
+		result.outgoing, result.action = handler(ctx, incoming)
+	}); err != nil {
+		return batchResult{panic: err}
+	}
+	return
+}
+
+// panicPackets returns an ERROR packet for every incoming packet in a batch whose BatchHandler panicked
+func panicPackets(incoming []*packet.Packet) []*packet.Packet {
+	outgoing := make([]*packet.Packet, 0, len(incoming))
+	for _, p := range incoming {
+		outgoing = append(outgoing, errorPacket(p.Metadata.Id, ErrorPanic, HandlerPanicked.Error()))
+	}
+	return outgoing
+}
+
+// releaseBatch returns the incoming and outgoing packets of a batch to the packet pool,
+// making sure that outgoing packets which are also incoming packets are only returned once
+func releaseBatch(incoming []*packet.Packet, outgoing []*packet.Packet) {
+	if len(outgoing) > 0 {
+		seen := make(map[*packet.Packet]struct{}, len(incoming))
+		for _, p := range incoming {
+			seen[p] = struct{}{}
+		}
+		for _, p := range outgoing {
+			if _, ok := seen[p]; !ok && p != nil {
+				seen[p] = struct{}{}
+				packet.Put(p)
+			}
+		}
+	}
+	for _, p := range incoming {
+		packet.Put(p)
+	}
+}
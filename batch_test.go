@@ -0,0 +1,147 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+	"github.com/loopholelabs/testing/conn/pair"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+func TestServerBatchHandler(t *testing.T) {
+	t.Parallel()
+
+	const (
+		limit   = 4
+		packets = 7
+	)
+
+	var mu sync.Mutex
+	var sizes []int
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	s, err := NewServer(make(HandlerTable), context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	err = s.SetBatchHandler(RESERVED9, limit, nil)
+	assert.ErrorIs(t, err, InvalidOperation)
+
+	err = s.SetBatchHandler(metadata.PacketPing, limit, func(_ context.Context, incoming []*packet.Packet) (outgoing []*packet.Packet, action Action) {
+		mu.Lock()
+		sizes = append(sizes, len(incoming))
+		first := len(sizes) == 1
+		mu.Unlock()
+		if first {
+			close(started)
+			<-release
+		}
+		// The incoming packets are sent back as the responses
+		return incoming, NONE
+	})
+	require.NoError(t, err)
+
+	// Batches are handled one at a time on the goroutine that reads the packets, so the
+	// packets that arrive while the first batch is being handled are queued
+	s.SetConcurrency(1)
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+
+	go s.ServeConn(serverConn)
+
+	c := NewAsync(clientConn, emptyLogger)
+
+	p := packet.Get()
+	p.Metadata.Operation = metadata.PacketPing
+	for i := 0; i < packets; i++ {
+		p.Metadata.Id = uint16(i)
+		err = c.WritePacket(p)
+		require.NoError(t, err)
+		if i == 0 {
+			<-started
+		}
+	}
+	packet.Put(p)
+
+	require.Eventually(t, func() bool {
+		s.connectionsMu.Lock()
+		defer s.connectionsMu.Unlock()
+		for conn := range s.connections {
+			return conn.(*Async).incoming.Length() == packets-1
+		}
+		return false
+	}, DefaultDeadline, time.Millisecond*10)
+	close(release)
+
+	for i := 0; i < packets; i++ {
+		p, err = c.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, uint16(i), p.Metadata.Id)
+		assert.Equal(t, metadata.PacketPing, p.Metadata.Operation)
+		packet.Put(p)
+	}
+
+	mu.Lock()
+	assert.Equal(t, []int{1, limit, packets - 1 - limit}, sizes)
+	mu.Unlock()
+	assert.Equal(t, uint64(0), s.InFlight())
+
+	err = c.Close()
+	assert.NoError(t, err)
+	err = s.Shutdown()
+	assert.NoError(t, err)
+}
+
+func TestServerBatchHandlerPanic(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	s, err := NewServer(make(HandlerTable), context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	s.SetPanicPolicy(PanicRespond)
+	err = s.SetBatchHandler(metadata.PacketPing, 0, func(_ context.Context, _ []*packet.Packet) (outgoing []*packet.Packet, action Action) {
+		panic("test panic")
+	})
+	require.NoError(t, err)
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+
+	go s.ServeConn(serverConn)
+
+	c := NewAsync(clientConn, emptyLogger)
+
+	p := packet.Get()
+	p.Metadata.Id = 8
+	p.Metadata.Operation = metadata.PacketPing
+	err = c.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	p, err = c.ReadPacket()
+	require.NoError(t, err)
+	remoteErr := RemoteErrorFromPacket(p)
+	require.NotNil(t, remoteErr)
+	assert.Equal(t, uint16(8), remoteErr.Id)
+	assert.Equal(t, ErrorPanic, remoteErr.Code)
+	packet.Put(p)
+	assert.Equal(t, uint64(1), s.Panics())
+
+	err = c.Close()
+	assert.NoError(t, err)
+	err = s.Shutdown()
+	assert.NoError(t, err)
+}
@@ -4,9 +4,11 @@ package frisbee
 
 import (
 	"context"
+	"fmt"
 	"net"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/loopholelabs/logging/types"
 
@@ -16,11 +18,35 @@ import (
 // Client connects to a frisbee Server and can send and receive frisbee packets
 type Client struct {
 	conn             *Async
-	handlerTable     HandlerTable
+	handlers         atomic.Pointer[handlerTables]
+	actionHandlers   actionHandlers
+	notFound         *notFound
+	handlerContext   *handlerContext
+	connHandlers     connectionHandlers
 	options          *Options
 	closed           atomic.Bool
 	wg               sync.WaitGroup
+	tasks            sync.WaitGroup
+	executor         Executor
+	overloaded       func(context.Context, *OverloadedError)
+	timedOut         func(context.Context, uint16)
+	remoteError      func(context.Context, *RemoteError)
+	final            func(context.Context, uint16)
+	timeouts         *timeouts
+	panicPolicy      PanicPolicy
+	panics           atomic.Uint64
 	heartbeatChannel chan struct{}
+	operationNames   []string
+	operations       map[string]uint16
+
+	// negotiationTimeout is how long to wait for the server's operations, and pending holds the packets that were
+	// received while waiting for them, which are handled before any others once the connection handler has started
+	negotiationTimeout time.Duration
+	pending            []*packet.Packet
+
+	interceptors            []Interceptor
+	streamOpenInterceptors  []StreamOpenInterceptor
+	streamCloseInterceptors []StreamCloseInterceptor
 
 	baseContext       context.Context
 	baseContextCancel context.CancelFunc
@@ -41,38 +67,181 @@ type Client struct {
 // NewClient returns an uninitialized frisbee Client with the registered ClientRouter.
 // The ConnectAsync method must then be called to dial the server and initialize the connection.
 func NewClient(handlerTable HandlerTable, ctx context.Context, opts ...Option) (*Client, error) {
-	for i := uint16(0); i < RESERVED9; i++ {
-		if _, ok := handlerTable[i]; ok {
-			return nil, InvalidHandlerTable
-		}
+	handlers, err := newHandlerTables(handlerTable, nil, nil)
+	if err != nil {
+		return nil, err
 	}
 
 	options := loadOptions(opts...)
 	var heartbeatChannel chan struct{}
 
-	baseContext, baseContextCancel := context.WithCancel(ctx)
+	c := &Client{
+		options:          options,
+		heartbeatChannel: heartbeatChannel,
+		executor:         NewInlineExecutor(),
+		notFound:         newNotFound(),
+
+		negotiationTimeout: DefaultOperationNegotiationTimeout,
+	}
+	c.connHandlers.interceptors = &c.interceptors
+	c.baseContext, c.baseContextCancel = context.WithCancel(withConnectionHandlers(withResponseWriter(ctx, c), &c.connHandlers))
+	c.handlerContext = newHandlerContext(c.baseContext)
+	c.handlers.Store(handlers)
+
+	return c, nil
+}
+
+// SetExecutor sets the Executor that will be used by the client to run the handlers for incoming packets.
+// By default, packets are handled one at a time and in order on the goroutine that reads them.
+//
+// This function should not be called once the client has connected.
+func (c *Client) SetExecutor(executor Executor) error {
+	if executor == nil {
+		return ExecutorNil
+	}
+	c.executor = executor
+	return nil
+}
+
+// SetOverloadedHandler sets the function that is called with an *OverloadedError whenever the server sheds
+// a packet sent by the client because it is overloaded (see Server.SetOverloadPolicy). The error contains
+// the Id of the packet that was shed and how long the server suggested waiting before retrying it, which
+// can be used to retry the packet later or on another server. If no handler is set, these are discarded.
+//
+// This function should not be called once the client has connected.
+func (c *Client) SetOverloadedHandler(f func(context.Context, *OverloadedError)) {
+	c.overloaded = f
+}
+
+// SetTimeoutHandler sets the function that is called with the Id of a packet sent by the client whenever the
+// server's handler for that packet times out (see Server.SetHandlerTimeout). If no handler is set, these are discarded.
+//
+// This function should not be called once the client has connected.
+func (c *Client) SetTimeoutHandler(f func(context.Context, uint16)) {
+	c.timedOut = f
+}
 
-	return &Client{
-		handlerTable:      handlerTable,
-		baseContext:       baseContext,
-		baseContextCancel: baseContextCancel,
-		options:           options,
-		heartbeatChannel:  heartbeatChannel,
-	}, nil
+// SetErrorHandler sets the function that is called with a *RemoteError whenever the server's handler for a packet
+// sent by the client fails and an ERROR packet is sent back in place of its response. If no handler is set,
+// these are discarded.
+//
+// This function should not be called once the client has connected.
+func (c *Client) SetErrorHandler(f func(context.Context, *RemoteError)) {
+	c.remoteError = f
+}
+
+// SetFinalHandler sets the function that is called with the Id of a packet sent by the client whenever the server's
+// Responder for that packet has sent its final response (see Respond). If no handler is set, these are discarded.
+//
+// This function should not be called once the client has connected.
+func (c *Client) SetFinalHandler(f func(context.Context, uint16)) {
+	c.final = f
+}
+
+// SetNotFoundHandler sets the Handler that is called for packets whose operation has no handler in the HandlerTable,
+// in the same way as Server.SetNotFoundHandler. By default, the packets are dropped.
+//
+// This function should not be called once the client has connected.
+func (c *Client) SetNotFoundHandler(handler Handler) {
+	c.notFound.handler = handler
+}
+
+// SetUnknownOperationErrors sets whether an ERROR packet with the ErrorUnknownOperation code is sent in place of a
+// response for packets whose operation has no handler, in the same way as Server.SetUnknownOperationErrors.
+//
+// This function should not be called once the client has connected.
+func (c *Client) SetUnknownOperationErrors(enabled bool) {
+	c.notFound.errors = enabled
+}
+
+// UnknownOperations returns the number of packets that have been received for each operation that had no
+// handler, including packets with a reserved operation that the client does not handle
+func (c *Client) UnknownOperations() map[uint16]uint64 {
+	return c.notFound.totals()
+}
+
+// SetActionHandler sets the ActionHandler for a custom Action, in the same way as Server.SetActionHandler.
+//
+// This function should not be called once the client has connected.
+func (c *Client) SetActionHandler(action Action, handler ActionHandler) error {
+	return c.actionHandlers.set(action, handler)
+}
+
+// SetPanicPolicy sets what happens to the connection when one of the client's handlers panics, in the same way as
+// Server.SetPanicPolicy. By default, the connection is closed.
+//
+// This function should not be called once the client has connected.
+func (c *Client) SetPanicPolicy(policy PanicPolicy) {
+	c.panicPolicy = policy
+}
+
+// Panics returns the total number of panics that have been recovered from handlers
+func (c *Client) Panics() uint64 {
+	return c.panics.Load()
+}
+
+// SetHandlerTimeout sets the default timeout for the handlers of incoming packets, in the same way as
+// Server.SetHandlerTimeout. Handlers that time out are sent back to the server as a TIMEOUT packet.
+//
+// This function should not be called once the client has connected.
+func (c *Client) SetHandlerTimeout(timeout time.Duration) {
+	if c.timeouts == nil {
+		c.timeouts = newTimeouts()
+	}
+	c.timeouts.setTimeout(timeout)
+}
+
+// SetOperationTimeout sets the timeout for the handler of the given operation, in the same way as Server.SetOperationTimeout.
+//
+// This function should not be called once the client has connected.
+func (c *Client) SetOperationTimeout(operation uint16, timeout time.Duration) {
+	if c.timeouts == nil {
+		c.timeouts = newTimeouts()
+	}
+	c.timeouts.setOperation(operation, timeout)
+}
+
+// SetWatchdog sets the hard limit for handlers that have timed out, in the same way as Server.SetWatchdog.
+//
+// This function should not be called once the client has connected.
+func (c *Client) SetWatchdog(hardLimit time.Duration) {
+	if c.timeouts == nil {
+		c.timeouts = newTimeouts()
+	}
+	c.timeouts.setHardLimit(hardLimit)
+}
+
+// TimedOut returns the total number of handlers that have timed out
+func (c *Client) TimedOut() uint64 {
+	return c.timeouts.timedOutTotal()
+}
+
+// Stuck returns the number of handlers that have exceeded the watchdog's hard limit (see SetWatchdog)
+// and have still not returned
+func (c *Client) Stuck() uint64 {
+	return c.timeouts.stuckTotal()
 }
 
 // Connect actually connects to the given frisbee server, and starts the reactor goroutines
 // to receive and handle incoming packets. If this function is called, FromConn should not be called.
+//
+// If the client has operation names (see SetOperationNames), Connect also negotiates them with the server before
+// returning. Servers that are older than named operations silently drop the negotiation request, so Connect can
+// only tell that the server does not support them once the negotiation timeout (see SetOperationNegotiationTimeout)
+// has passed, and then returns an error wrapping OperationsUnsupported.
 func (c *Client) Connect(addr string, streamHandler ...NewStreamHandler) error {
 	c.Logger().Debug().Msgf("Connecting to %s", addr)
 	var frisbeeConn *Async
 	var err error
-	frisbeeConn, err = ConnectAsync(addr, c.options.KeepAlive, c.Logger(), c.options.TLSConfig, streamHandler...)
+	frisbeeConn, err = ConnectAsyncWithOptions(addr, c.options, streamHandler...)
 	if err != nil {
 		return err
 	}
 	c.conn = frisbeeConn
 	c.Logger().Info().Msgf("Connected to %s", addr)
+	if err = c.negotiateOperations(); err != nil {
+		return err
+	}
 
 	c.wg.Add(1)
 	go c.handleConn()
@@ -82,14 +251,72 @@ func (c *Client) Connect(addr string, streamHandler ...NewStreamHandler) error {
 
 // FromConn takes a pre-existing connection to a Frisbee server and starts the reactor goroutines
 // to receive and handle incoming packets. If this function is called, Connect should not be called.
+//
+// Operation names are negotiated in the same way as by Connect, including waiting for the whole negotiation
+// timeout if the server is older than named operations.
 func (c *Client) FromConn(conn net.Conn, streamHandler ...NewStreamHandler) error {
-	c.conn = NewAsync(conn, c.Logger(), streamHandler...)
+	c.conn = NewAsyncWithOptions(conn, c.options, streamHandler...)
+	if err := c.negotiateOperations(); err != nil {
+		return err
+	}
 	c.wg.Add(1)
 	go c.handleConn()
 	c.Logger().Debug().Msgf("Connection handler started for %s", c.conn.RemoteAddr())
 	return nil
 }
 
+// negotiateOperations learns the operations for the client's operation names from the server (if it has any),
+// and closes the connection if that fails
+func (c *Client) negotiateOperations() error {
+	if len(c.operationNames) == 0 {
+		return nil
+	}
+	operations, pending, err := negotiateOperations(c.conn, c.operationNames, c.negotiationTimeout)
+	if err != nil {
+		c.Logger().Debug().Err(err).Msgf("error while negotiating named operations with %s", c.conn.RemoteAddr())
+		_ = c.conn.Close()
+		return err
+	}
+	c.operations = operations
+	c.pending = pending
+	return nil
+}
+
+// SetOperationNames sets the names of the named operations (see Server.SetNamedHandlerTable) that the client
+// will send packets for. While connecting, the client sends the names to the server and waits for their
+// operations, which can then be looked up with Operation. Connect and FromConn return an error wrapping
+// OperationNameMismatch (and close the connection) if the server does not handle all of the names, or an error
+// wrapping OperationsUnsupported (and OperationNegotiationTimeout) if the server does not respond before the
+// negotiation timeout. Since servers that are older than named operations never respond, connecting to one always
+// takes the whole negotiation timeout. Packets that the server sends while the names are being negotiated are
+// handled once the client has connected.
+//
+// This function should not be called once the client has connected.
+func (c *Client) SetOperationNames(names ...string) {
+	c.operationNames = names
+}
+
+// SetOperationNegotiationTimeout sets how long the client waits for the server to respond with the operations for
+// its operation names while connecting (see SetOperationNames), which is DefaultOperationNegotiationTimeout by
+// default. It is separate from the Deadline since it is also how long connecting to a server that is older than
+// named operations takes to fail.
+//
+// This function should not be called once the client has connected.
+func (c *Client) SetOperationNegotiationTimeout(timeout time.Duration) {
+	c.negotiationTimeout = timeout
+}
+
+// Operation returns the operation that the server gave to the named operation, which should be used as the
+// Operation of packets for it, or an error wrapping OperationNameUnknown if the name was not negotiated
+// with the server (see SetOperationNames)
+func (c *Client) Operation(name string) (uint16, error) {
+	operation, ok := c.operations[name]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", OperationNameUnknown, name)
+	}
+	return operation, nil
+}
+
 // Closed checks whether this client has been closed
 func (c *Client) Closed() bool {
 	return c.closed.Load()
@@ -164,65 +391,229 @@ func (c *Client) SetStreamHandler(f func(context.Context, *Stream)) {
 		if c.StreamContext != nil {
 			streamCtx = c.StreamContext(streamCtx, s)
 		}
-		f(streamCtx, s)
+		handleStream(streamCtx, s, f, c.streamOpenInterceptors, c.streamCloseInterceptors)
 	})
 }
 
+// SetInterceptors sets the chain of Interceptors that wraps the handler for every incoming packet,
+// in the same way as Server.SetInterceptors.
+//
+// This function should not be called once the client has connected.
+func (c *Client) SetInterceptors(interceptors ...Interceptor) {
+	c.interceptors = interceptors
+	c.handlers.Store(c.handlers.Load().withInterceptors(interceptors))
+}
+
+// SetHandlerTable sets the handler table for the client, and returns InvalidHandlerTable without changing it
+// if the handler table uses a reserved operation.
+//
+// This function can be called while the client is connected, in the same way as Server.SetHandlerTable.
+func (c *Client) SetHandlerTable(handlerTable HandlerTable) error {
+	handlers, err := newHandlerTables(handlerTable, nil, c.interceptors)
+	if err != nil {
+		return err
+	}
+	c.handlers.Store(handlers)
+	return nil
+}
+
+// GetHandlerTable gets the handler table for the client, which must not be modified (SetHandlerTable should be
+// used to change it instead).
+func (c *Client) GetHandlerTable() HandlerTable {
+	return c.handlers.Load().table
+}
+
+// SetStreamInterceptors sets the StreamOpenInterceptors and StreamCloseInterceptors for streams opened
+// by the server, in the same way as Server.SetStreamInterceptors.
+//
+// This function should not be called once the client has connected.
+func (c *Client) SetStreamInterceptors(open []StreamOpenInterceptor, close []StreamCloseInterceptor) {
+	c.streamOpenInterceptors = open
+	c.streamCloseInterceptors = close
+}
+
 // Logger returns the client's logger (useful for ClientRouter functions)
 func (c *Client) Logger() types.Logger {
 	return c.options.Logger
 }
 
 func (c *Client) handleConn() {
-	var p *packet.Packet
-	var outgoing *packet.Packet
-	var action Action
-	var err error
-	var handlerFunc Handler
+	for _, p := range c.pending {
+		c.tasks.Add(1)
+		c.executor.Execute(c.conn, func() {
+			c.handlePacket(p)
+		})
+	}
+	c.pending = nil
 	for {
-		if c.closed.Load() {
-			c.wg.Done()
-			return
-		}
-		p, err = c.conn.ReadPacket()
-		if err != nil {
-			c.Logger().Debug().Err(err).Msg("error while getting packet frisbee connection")
+		p, err := c.conn.ReadPacket()
+		if err != nil || c.closed.Load() {
+			if p != nil {
+				packet.Put(p)
+			}
+			if err != nil {
+				c.Logger().Debug().Err(err).Msg("error while getting packet frisbee connection")
+			}
+			c.tasks.Wait()
+			c.executor.Release(c.conn)
 			c.wg.Done()
 			_ = c.Close()
 			return
 		}
-		handlerFunc = c.handlerTable[p.Metadata.Operation]
-		if handlerFunc != nil {
-			packetCtx := c.baseContext
-			if c.PacketContext != nil {
-				packetCtx = c.PacketContext(packetCtx, p)
-			}
-			outgoing, action = handlerFunc(packetCtx, p)
-			if outgoing != nil && outgoing.Metadata.ContentLength == uint32(outgoing.Content.Len()) {
-				err = c.conn.WritePacket(outgoing)
-				if outgoing != p {
-					packet.Put(outgoing)
-				}
-				packet.Put(p)
+		c.tasks.Add(1)
+		c.executor.Execute(c.conn, func() {
+			c.handlePacket(p)
+		})
+	}
+}
+
+// handlePacket runs the handler for the given packet, closing the underlying connection if
+// writing the response fails or the handler returns the CLOSE action
+func (c *Client) handlePacket(p *packet.Packet) {
+	defer c.tasks.Done()
+	switch p.Metadata.Operation {
+	case OVERLOADED:
+		if c.overloaded != nil {
+			c.handlePanic(recoverPanic(OVERLOADED, func() {
+				c.overloaded(c.baseContext, OverloadedFromPacket(p))
+			}))
+		}
+		packet.Put(p)
+		return
+	case TIMEOUT:
+		if c.timedOut != nil {
+			c.handlePanic(recoverPanic(TIMEOUT, func() {
+				c.timedOut(c.baseContext, p.Metadata.Id)
+			}))
+		}
+		packet.Put(p)
+		return
+	case ERROR:
+		if c.remoteError != nil {
+			c.handlePanic(recoverPanic(ERROR, func() {
+				c.remoteError(c.baseContext, RemoteErrorFromPacket(p))
+			}))
+		}
+		packet.Put(p)
+		return
+	case FINAL:
+		if c.final != nil {
+			c.handlePanic(recoverPanic(FINAL, func() {
+				c.final(c.baseContext, p.Metadata.Id)
+			}))
+		}
+		packet.Put(p)
+		return
+	}
+	handlerFunc := c.connHandlers.lookup(c.handlers.Load(), p.Metadata.Operation)
+	if handlerFunc == nil {
+		var outgoing *packet.Packet
+		if handlerFunc, outgoing = c.notFound.lookup(c.Logger(), p); handlerFunc == nil {
+			packet.Put(p)
+			if outgoing != nil {
+				err := c.conn.writePacket(outgoing)
+				packet.Put(outgoing)
 				if err != nil {
 					c.Logger().Error().Err(err).Msg("error while writing to frisbee conn")
-					c.wg.Done()
-					_ = c.Close()
-					return
+					c.closeConn()
 				}
-			} else {
-				packet.Put(p)
 			}
-			switch action {
-			case NONE:
-			case CLOSE:
-				c.Logger().Debug().Msgf("Closing connection %s because of CLOSE action", c.conn.RemoteAddr())
-				c.wg.Done()
-				_ = c.Close()
-				return
-			}
-		} else {
+			return
+		}
+	}
+	id, operation := p.Metadata.Id, p.Metadata.Operation
+	write := c.conn.WritePacket
+	packetCtx, panicErr := callPacketContext(c.handlerContext.load(), c.PacketContext, p)
+	result, ok := handlerResult{panic: panicErr}, true
+	if panicErr == nil {
+		result, ok = c.timeouts.run(packetCtx, c.Logger(), handlerFunc, p, c.reportPanic)
+	}
+	switch {
+	case !ok:
+		// The handler still owns the incoming packet, so only the TIMEOUT packet is sent and released
+		p = nil
+		result.outgoing = timeoutPacket(id)
+		write = c.conn.writePacket
+	case result.panic != nil:
+		c.reportPanic(result.panic)
+		if c.panicPolicy != PanicRespond {
 			packet.Put(p)
+			c.closeConn()
+			return
 		}
+		result.outgoing = errorPacket(id, ErrorPanic, HandlerPanicked.Error())
+		write = c.conn.writePacket
+	}
+	outgoing := result.outgoing
+	if outgoing != nil && outgoing.Metadata.ContentLength == uint32(outgoing.Content.Len()) {
+		err := write(outgoing)
+		if outgoing != p {
+			packet.Put(outgoing)
+		}
+		packet.Put(p)
+		if err != nil {
+			c.Logger().Error().Err(err).Msg("error while writing to frisbee conn")
+			c.closeConn()
+			return
+		}
+	} else {
+		packet.Put(p)
+	}
+	action, panicErr := c.actionHandlers.resolve(packetCtx, c.conn, operation, result.action)
+	if panicErr == nil && action == UPDATE {
+		panicErr = c.handlerContext.update(c.UpdateContext, c.conn, operation)
+	}
+	if panicErr != nil {
+		c.handlePanic(panicErr)
+		return
+	}
+	switch action {
+	case CLOSE:
+		c.Logger().Debug().Msgf("Closing connection %s because of CLOSE action", c.conn.RemoteAddr())
+		c.closeConn()
+	case SHUTDOWN:
+		c.Logger().Debug().Msgf("Closing client for %s because of SHUTDOWN action", c.conn.RemoteAddr())
+		// Close waits for the connection handler, which may be running this handler, so it is called from a new goroutine
+		go func() {
+			_ = c.Close()
+		}()
+	}
+}
+
+// reportPanic logs and counts a panic that was recovered from a handler
+func (c *Client) reportPanic(err *PanicError) {
+	c.panics.Add(1)
+	c.Logger().Error().Err(err).Msgf("recovered from handler panic:\n%s", err.Stack)
+}
+
+// handlePanic reports a panic that was recovered from one of the functions called for an incoming packet once there
+// is no response left to send (if err is not nil), and closes the connection unless the PanicPolicy is PanicRespond
+func (c *Client) handlePanic(err *PanicError) {
+	if err == nil {
+		return
+	}
+	c.reportPanic(err)
+	if c.panicPolicy != PanicRespond {
+		c.closeConn()
+	}
+}
+
+func (c *Client) writeResponse(p *packet.Packet) error {
+	return c.conn.WritePacket(p)
+}
+
+func (c *Client) writeFinal(id uint16) error {
+	p := finalPacket(id)
+	err := c.conn.writePacket(p)
+	packet.Put(p)
+	return err
+}
+
+// closeConn marks the client as closed and closes the underlying connection without waiting for
+// the connection handler to exit, which allows it to be called by handlers
+func (c *Client) closeConn() {
+	if c.closed.CompareAndSwap(false, true) {
+		c.baseContextCancel()
 	}
+	_ = c.conn.Close()
 }
@@ -11,12 +11,16 @@ import (
 
 	"github.com/loopholelabs/logging/types"
 
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
 	"github.com/loopholelabs/frisbee-go/pkg/packet"
 )
 
 // DefaultBufferSize is the size of the default buffer
 const DefaultBufferSize = 1 << 16
 
+// MinBufferSize is the smallest buffer size that can be used, since the read buffer must be able to hold the metadata of a packet
+const MinBufferSize = metadata.Size
+
 var (
 	DefaultDeadline     = time.Second * 5
 	DefaultPingInterval = time.Millisecond * 500
@@ -0,0 +1,760 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+const (
+	// maxEvents is the maximum number of events returned by a single call to epoll_wait
+	maxEvents = 128
+
+	// writeEvents does not include EPOLLRDHUP since reading is paused while a connection has unwritten data, so a
+	// half-closed connection would otherwise be reported as ready over and over until the data has been written
+	readEvents  = syscall.EPOLLIN | syscall.EPOLLRDHUP
+	writeEvents = syscall.EPOLLOUT
+)
+
+// eventLoop serves plain TCP connections for a Server by multiplexing them onto a small number of
+// pollers, each of which is a single goroutine that waits for events on its own epoll instance and
+// then reads packets from, and runs handlers for, the connections that are ready.
+type eventLoop struct {
+	server  *Server
+	pollers []*poller
+	next    atomic.Uint64
+}
+
+// poller owns an epoll instance and all the connections that are registered with it. Apart from adding
+// new connections, all the state of a poller (and its connections) is only accessed from its own goroutine.
+type poller struct {
+	loop   *eventLoop
+	epfd   int
+	wakeFd [2]int
+
+	mu     sync.Mutex
+	closed bool
+	conns  map[int32]*eventConn
+
+	// buf and scratch are lazily allocated and shared by all of the poller's connections
+	buf     []byte
+	scratch []byte
+
+	// batches holds the packets for batched operations that were received by the current read
+	batches batches
+
+	// now is the time at which the current events were returned by epoll, and lastTick is
+	// the last time that the poller pinged its idle connections and closed the expired ones
+	now      time.Time
+	lastTick time.Time
+	expired  []*eventConn
+}
+
+// eventConn is a connection being served by a poller. Buffers are only allocated for a connection while
+// it has a partially read packet or unwritten data, so idle connections use very little memory.
+type eventConn struct {
+	fd        int
+	remote    net.Addr
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closed    bool
+	closing   bool
+	header    [metadata.Size]byte
+	headerLen int
+	p         *packet.Packet
+	out       []byte
+	responses eventResponseWriter
+	handlers  connectionHandlers
+
+	// active is the last time that data was read from the connection, and written
+	// is the last time that any of the connection's unwritten data was written
+	active  time.Time
+	written time.Time
+}
+
+// eventResponseWriter is the responseWriter for a connection served by the event loop. Since only the connection's
+// poller can write to it, responses sent by a Responder are buffered while the handler is running and are written
+// once it returns, and responses sent after the handler has returned fail with ResponderUnavailable.
+type eventResponseWriter struct {
+	mu      sync.Mutex
+	active  bool
+	pending []byte
+}
+
+func (w *eventResponseWriter) writeResponse(p *packet.Packet) error {
+	if p.Metadata.Operation <= RESERVED9 {
+		return InvalidOperation
+	}
+	return w.write(p.Metadata.Id, p.Metadata.Operation, p.Content.Bytes()[:p.Metadata.ContentLength])
+}
+
+func (w *eventResponseWriter) writeFinal(id uint16) error {
+	return w.write(id, FINAL, nil)
+}
+
+func (w *eventResponseWriter) write(id uint16, operation uint16, content []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.active {
+		return ResponderUnavailable
+	}
+	w.pending = appendMetadata(w.pending, id, operation, uint32(len(content)))
+	w.pending = append(w.pending, content...)
+	return nil
+}
+
+// begin starts buffering responses while a handler is running
+func (w *eventResponseWriter) begin() {
+	w.mu.Lock()
+	w.active = true
+	w.mu.Unlock()
+}
+
+// end stops buffering responses once a handler has returned, and returns the buffered responses
+func (w *eventResponseWriter) end() []byte {
+	w.mu.Lock()
+	pending := w.pending
+	w.active, w.pending = false, nil
+	w.mu.Unlock()
+	return pending
+}
+
+// expired returns whether nothing has been read from the connection, or none of
+// its unwritten data could be written, within the given deadline
+func (c *eventConn) expired(now time.Time, deadline time.Duration) bool {
+	return now.Sub(c.active) > deadline || (len(c.out) > 0 && now.Sub(c.written) > deadline)
+}
+
+// Close shuts down the connection's socket so that its poller closes the connection, which lets the
+// Server close connections that are served by the event loop from other goroutines during Shutdown
+func (c *eventConn) Close() error {
+	return syscall.Shutdown(c.fd, syscall.SHUT_RDWR)
+}
+
+const eventLoopSupported = true
+
+func newEventLoop(s *Server, workers int) (*eventLoop, error) {
+	l := &eventLoop{
+		server:  s,
+		pollers: make([]*poller, 0, workers),
+	}
+	for i := 0; i < workers; i++ {
+		p, err := newPoller(l)
+		if err != nil {
+			for _, p = range l.pollers {
+				p.shutdown()
+			}
+			return nil, err
+		}
+		l.pollers = append(l.pollers, p)
+	}
+	for _, p := range l.pollers {
+		s.wg.Add(1)
+		go p.run()
+	}
+	return l, nil
+}
+
+// serve registers the given connection with one of the event loop's pollers, and returns false
+// if the connection cannot be served by the event loop (because it is not a plain TCP connection).
+func (l *eventLoop) serve(conn net.Conn) bool {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return false
+	}
+
+	err := tcpConn.SetKeepAlive(true)
+	if err == nil {
+		err = tcpConn.SetKeepAlivePeriod(l.server.options.KeepAlive)
+	}
+	if err != nil {
+		l.server.Logger().Error().Err(err).Msg("Error while setting TCP Keepalive")
+		_ = tcpConn.Close()
+		return true
+	}
+
+	// The file descriptor is duplicated so that the connection can be closed without
+	// closing the file descriptor, which takes it out of the Go runtime's network poller
+	raw, err := tcpConn.SyscallConn()
+	if err != nil {
+		l.server.Logger().Error().Err(err).Msg("Error while getting raw TCP connection")
+		_ = tcpConn.Close()
+		return true
+	}
+	fd := -1
+	controlErr := raw.Control(func(s uintptr) {
+		syscall.ForkLock.RLock()
+		fd, err = syscall.Dup(int(s))
+		if err == nil {
+			syscall.CloseOnExec(fd)
+		}
+		syscall.ForkLock.RUnlock()
+	})
+	_ = tcpConn.Close()
+	if err == nil {
+		err = controlErr
+	}
+	if err == nil {
+		err = syscall.SetNonblock(fd, true)
+	}
+	if err != nil {
+		l.server.Logger().Error().Err(err).Msg("Error while duplicating TCP connection")
+		if fd >= 0 {
+			_ = syscall.Close(fd)
+		}
+		return true
+	}
+
+	c := &eventConn{
+		fd:     fd,
+		remote: conn.RemoteAddr(),
+	}
+	c.handlers.interceptors = &l.server.interceptors
+	c.ctx, c.cancel = context.WithCancel(withConnectionHandlers(withResponseWriter(l.server.baseContext, &c.responses), &c.handlers))
+
+	l.server.connectionsMu.Lock()
+	if l.server.shutdown.Load() {
+		l.server.connectionsMu.Unlock()
+		_ = syscall.Close(fd)
+		c.cancel()
+		return true
+	}
+	l.server.connections[c] = struct{}{}
+	l.server.connectionsMu.Unlock()
+
+	l.pollers[l.next.Add(1)%uint64(len(l.pollers))].add(c)
+	return true
+}
+
+// release removes the connection from the server's connections, and then closes its file descriptor. The file
+// descriptor is only closed once the connection has been removed so that Shutdown (which closes the server's
+// connections while holding connectionsMu) can never shut down a file descriptor that has been reused.
+func (l *eventLoop) release(c *eventConn) {
+	l.server.connectionsMu.Lock()
+	delete(l.server.connections, c)
+	l.server.connectionsMu.Unlock()
+	_ = syscall.Close(c.fd)
+	c.cancel()
+}
+
+// close stops all the event loop's pollers and closes all of their connections
+func (l *eventLoop) close() {
+	for _, p := range l.pollers {
+		p.mu.Lock()
+		if !p.closed {
+			p.closed = true
+			_, _ = syscall.Write(p.wakeFd[1], []byte{0})
+		}
+		p.mu.Unlock()
+	}
+}
+
+func newPoller(l *eventLoop) (*poller, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	p := &poller{
+		loop:  l,
+		epfd:  epfd,
+		conns: make(map[int32]*eventConn),
+	}
+	if err = syscall.Pipe2(p.wakeFd[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+		_ = syscall.Close(epfd)
+		return nil, err
+	}
+	if err = syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, p.wakeFd[0], &syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(p.wakeFd[0])}); err != nil {
+		p.shutdown()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *poller) add(c *eventConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		p.loop.release(c)
+		return
+	}
+	c.active = time.Now()
+	p.conns[int32(c.fd)] = c
+	if err := syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_ADD, c.fd, &syscall.EpollEvent{Events: readEvents, Fd: int32(c.fd)}); err != nil {
+		p.loop.server.Logger().Error().Err(err).Msg("Error while registering connection with event loop")
+		delete(p.conns, int32(c.fd))
+		p.loop.release(c)
+	}
+}
+
+func (p *poller) run() {
+	defer p.loop.server.wg.Done()
+	events := make([]syscall.EpollEvent, maxEvents)
+
+	// The poller wakes up at least once per interval to ping its idle connections and close the expired ones
+	interval := p.loop.server.options.Deadline
+	if pingInterval := p.loop.server.options.PingInterval; pingInterval > 0 && pingInterval < interval {
+		interval = pingInterval
+	}
+	timeout := max(int(interval.Milliseconds()), 1)
+	p.lastTick = time.Now()
+	for {
+		n, err := syscall.EpollWait(p.epfd, events, timeout)
+		if err != nil {
+			if errors.Is(err, syscall.EINTR) {
+				continue
+			}
+			p.loop.server.Logger().Error().Err(err).Msg("Error while waiting for events in event loop")
+			p.shutdown()
+			return
+		}
+		p.now = time.Now()
+		for i := 0; i < n; i++ {
+			if int(events[i].Fd) == p.wakeFd[0] {
+				p.shutdown()
+				return
+			}
+			p.mu.Lock()
+			c := p.conns[events[i].Fd]
+			p.mu.Unlock()
+			if c == nil {
+				continue
+			}
+			if events[i].Events&(syscall.EPOLLOUT|syscall.EPOLLERR|syscall.EPOLLHUP) != 0 && len(c.out) > 0 {
+				if err = p.flush(c); err != nil {
+					p.closeConn(c, err)
+					continue
+				}
+				if c.closing && len(c.out) == 0 {
+					p.closeConn(c, nil)
+					continue
+				}
+			}
+			if events[i].Events&(syscall.EPOLLIN|syscall.EPOLLRDHUP|syscall.EPOLLERR|syscall.EPOLLHUP) != 0 && len(c.out) == 0 {
+				if err = p.read(c); err != nil {
+					p.closeConn(c, err)
+				}
+			}
+		}
+		if p.now.Sub(p.lastTick) >= interval {
+			p.tick(interval)
+		}
+	}
+}
+
+// tick closes the connections that nothing has been read from, or whose unwritten data has not been written to,
+// within the Deadline, and sends a PING to the connections that nothing has been read from within the PingInterval
+// (so that, like Async connections, idle connections are kept alive by the PONGs that their peers reply with).
+func (p *poller) tick(interval time.Duration) {
+	options := p.loop.server.options
+
+	// If the poller was blocked (by a slow handler) for much longer than the interval, the connections
+	// could not have been read from in the meantime, so their deadlines are restarted instead
+	late := p.now.Sub(p.lastTick) > 2*interval
+	p.lastTick = p.now
+
+	p.mu.Lock()
+	for _, c := range p.conns {
+		if late {
+			c.active, c.written = p.now, p.now
+			continue
+		}
+		if c.expired(p.now, options.Deadline) || (options.PingInterval > 0 && p.now.Sub(c.active) >= options.PingInterval) {
+			p.expired = append(p.expired, c)
+		}
+	}
+	p.mu.Unlock()
+
+	for i, c := range p.expired {
+		p.expired[i] = nil
+		if c.expired(p.now, options.Deadline) {
+			p.closeConn(c, os.ErrDeadlineExceeded)
+		} else if !c.closing {
+			if err := p.writePacket(c, PINGPacket); err != nil {
+				p.closeConn(c, err)
+			}
+		}
+	}
+	p.expired = p.expired[:0]
+}
+
+// shutdown closes all the poller's connections as well as the epoll instance
+func (p *poller) shutdown() {
+	p.mu.Lock()
+	p.closed = true
+	conns := make([]*eventConn, 0, len(p.conns))
+	for _, c := range p.conns {
+		conns = append(conns, c)
+	}
+	p.mu.Unlock()
+	for _, c := range conns {
+		p.closeConn(c, ConnectionClosed)
+	}
+	_ = syscall.Close(p.wakeFd[0])
+	_ = syscall.Close(p.wakeFd[1])
+	_ = syscall.Close(p.epfd)
+}
+
+func (p *poller) closeConn(c *eventConn, err error) {
+	if c.closed {
+		return
+	}
+	c.closed = true
+	p.loop.server.Logger().Debug().Err(err).Msg("closing event loop connection")
+	p.mu.Lock()
+	delete(p.conns, int32(c.fd))
+	p.mu.Unlock()
+	_ = syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_DEL, c.fd, nil)
+	p.loop.release(c)
+	if c.p != nil {
+		packet.Put(c.p)
+		c.p = nil
+	}
+	c.out = nil
+}
+
+// read does a single read from the connection and handles any packets that were completed by it. Only a single
+// read is done so that busy connections cannot starve the others, since epoll will report them as ready again.
+func (p *poller) read(c *eventConn) error {
+	if p.buf == nil {
+		p.buf = make([]byte, p.loop.server.options.BufferSize)
+	}
+
+	// The content of large packets is read directly into the packet
+	if c.p != nil {
+		if remaining := int(c.p.Metadata.ContentLength) - c.p.Content.Len(); remaining > len(p.buf) {
+			c.p.Content.Grow(remaining)
+			content := c.p.Content.Bytes()
+			n, err := readFd(c.fd, content[len(content):len(content)+remaining])
+			if n > 0 {
+				c.p.Content.MoveOffset(n)
+				c.active = p.now
+			}
+			if err != nil {
+				return err
+			}
+			return p.completeBatches(c, p.complete(c))
+		}
+	}
+
+	n, err := readFd(c.fd, p.buf)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		c.active = p.now
+	}
+	return p.completeBatches(c, p.process(c, p.buf[:n]))
+}
+
+// readFd reads from the given non-blocking file descriptor, and returns io.EOF if the connection was closed
+func readFd(fd int, b []byte) (int, error) {
+	for {
+		n, err := syscall.Read(fd, b)
+		if err != nil {
+			if errors.Is(err, syscall.EINTR) {
+				continue
+			}
+			if errors.Is(err, syscall.EAGAIN) {
+				return 0, nil
+			}
+			return 0, err
+		}
+		if n == 0 {
+			return 0, io.EOF
+		}
+		return n, nil
+	}
+}
+
+// process decodes packets from the given data, buffering any partially received packet in the connection
+func (p *poller) process(c *eventConn, data []byte) error {
+	for len(data) > 0 && !c.closed && !c.closing {
+		if c.p == nil {
+			var header []byte
+			if c.headerLen > 0 || len(data) < metadata.Size {
+				n := copy(c.header[c.headerLen:], data)
+				c.headerLen += n
+				data = data[n:]
+				if c.headerLen < metadata.Size {
+					return nil
+				}
+				c.headerLen = 0
+				header = c.header[:]
+			} else {
+				header = data[:metadata.Size]
+				data = data[metadata.Size:]
+			}
+			c.p = packet.Get()
+			c.p.Metadata.Id = binary.BigEndian.Uint16(header[metadata.IdOffset : metadata.IdOffset+metadata.IdSize])
+			c.p.Metadata.Operation = binary.BigEndian.Uint16(header[metadata.OperationOffset : metadata.OperationOffset+metadata.OperationSize])
+			c.p.Metadata.ContentLength = binary.BigEndian.Uint32(header[metadata.ContentLengthOffset : metadata.ContentLengthOffset+metadata.ContentLengthSize])
+			if maxContentLength := p.loop.server.options.MaxContentLength; maxContentLength > 0 && int64(c.p.Metadata.ContentLength) > int64(maxContentLength) {
+				return ContentLengthExceeded
+			}
+		} else {
+			n := min(int(c.p.Metadata.ContentLength)-c.p.Content.Len(), len(data))
+			c.p.Content.Write(data[:n])
+			data = data[n:]
+		}
+		if err := p.complete(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// complete handles the connection's current packet if all of its content has been received
+func (p *poller) complete(c *eventConn) error {
+	if c.p == nil || c.p.Content.Len() < int(c.p.Metadata.ContentLength) {
+		return nil
+	}
+	incoming := c.p
+	c.p = nil
+
+	s := p.loop.server
+	switch incoming.Metadata.Operation {
+	case PING:
+		packet.Put(incoming)
+		return p.writePacket(c, PONGPacket)
+	case PONG:
+		packet.Put(incoming)
+		return nil
+	case STREAM:
+		// Like the server's default stream handler, streams opened by the peer are closed straight away
+		// (by replying with an empty STREAM packet) instead of having their packets silently discarded
+		if incoming.Metadata.ContentLength == 0 {
+			packet.Put(incoming)
+			return nil
+		}
+		incoming.Metadata.ContentLength = 0
+		err := p.writePacket(c, incoming)
+		packet.Put(incoming)
+		return err
+	case OPERATIONS:
+		return s.respondOperations(func(outgoing *packet.Packet) error {
+			return p.writePacket(c, outgoing)
+		}, incoming)
+	}
+
+	if batch, ok := s.batchHandlers[incoming.Metadata.Operation]; ok {
+		if p.batches == nil {
+			p.batches = make(batches)
+		}
+		if full := p.batches.add(incoming, batch.limit); full != nil {
+			return p.completeBatch(c, incoming.Metadata.Operation, full)
+		}
+		return nil
+	}
+
+	handlerFunc := c.handlers.lookup(s.handlers.Load(), incoming.Metadata.Operation)
+	if handlerFunc == nil {
+		var outgoing *packet.Packet
+		if handlerFunc, outgoing = s.notFound.lookup(s.Logger(), incoming); handlerFunc == nil {
+			packet.Put(incoming)
+			if outgoing == nil {
+				return nil
+			}
+			s.preWrite()
+			err := p.writePacket(c, outgoing)
+			packet.Put(outgoing)
+			return err
+		}
+	}
+	operation := incoming.Metadata.Operation
+	packetCtx, panicErr := callPacketContext(c.ctx, s.PacketContext, incoming)
+	result := handlerResult{panic: panicErr}
+	var err error
+	c.responses.begin()
+	if panicErr == nil {
+		result = callHandler(packetCtx, handlerFunc, incoming)
+	}
+	if pending := c.responses.end(); len(pending) > 0 {
+		s.preWrite()
+		if err = p.write(c, pending); err != nil {
+			packet.Put(incoming)
+			return err
+		}
+	}
+	if result.panic != nil {
+		s.reportPanic(c.remote, result.panic)
+		if s.panicPolicy != PanicRespond {
+			packet.Put(incoming)
+			return result.panic
+		}
+		result.outgoing = errorPacket(incoming.Metadata.Id, ErrorPanic, HandlerPanicked.Error())
+	}
+	outgoing, action := result.outgoing, result.action
+	if outgoing != nil && outgoing.Metadata.ContentLength == uint32(outgoing.Content.Len()) {
+		s.preWrite()
+		err = p.writePacket(c, outgoing)
+		if outgoing != incoming {
+			packet.Put(outgoing)
+		}
+	}
+	packet.Put(incoming)
+	if err != nil {
+		return err
+	}
+	p.applyAction(c, packetCtx, operation, action)
+	return nil
+}
+
+// completeBatches handles the batches of packets that were received by the last read from the connection,
+// and returns the error from the read (if there was one) or from handling them
+func (p *poller) completeBatches(c *eventConn, err error) error {
+	if len(p.batches) == 0 {
+		return err
+	}
+	p.batches.flush(func(operation uint16, batch []*packet.Packet) {
+		if err != nil || c.closed || c.closing {
+			releaseBatch(batch, nil)
+			return
+		}
+		err = p.completeBatch(c, operation, batch)
+	})
+	return err
+}
+
+// completeBatch runs the BatchHandler for a batch of packets received from the connection
+func (p *poller) completeBatch(c *eventConn, operation uint16, incoming []*packet.Packet) error {
+	s := p.loop.server
+	result := callBatchHandler(c.ctx, s.batchHandlers[operation].handler, operation, incoming)
+	if result.panic != nil {
+		s.reportPanic(c.remote, result.panic)
+		if s.panicPolicy != PanicRespond {
+			releaseBatch(incoming, nil)
+			return result.panic
+		}
+		result.outgoing = panicPackets(incoming)
+	}
+	var err error
+	for _, outgoing := range result.outgoing {
+		if outgoing != nil && outgoing.Metadata.ContentLength == uint32(outgoing.Content.Len()) {
+			s.preWrite()
+			if err = p.writePacket(c, outgoing); err != nil {
+				break
+			}
+		}
+	}
+	releaseBatch(incoming, result.outgoing)
+	if err != nil {
+		return err
+	}
+	p.applyAction(c, c.ctx, operation, result.action)
+	return nil
+}
+
+// applyAction applies the Action returned by a handler that was called with ctx for a packet with the given
+// operation received on the connection. The UPDATE action does nothing, since the event loop cannot be used
+// with an UpdateContext function.
+func (p *poller) applyAction(c *eventConn, ctx context.Context, operation uint16, action Action) {
+	s := p.loop.server
+	action, err := s.actionHandlers.resolve(ctx, nil, operation, action)
+	if err != nil {
+		s.reportPanic(c.remote, err)
+		if s.panicPolicy != PanicRespond {
+			p.closeWhenWritten(c)
+		}
+		return
+	}
+	switch action {
+	case CLOSE:
+		p.closeWhenWritten(c)
+	case SHUTDOWN:
+		s.shutdownFromHandler()
+	}
+}
+
+// closeWhenWritten closes the connection once any buffered data has been written
+func (p *poller) closeWhenWritten(c *eventConn) {
+	if len(c.out) > 0 {
+		c.closing = true
+	} else {
+		p.closeConn(c, nil)
+	}
+}
+
+// writePacket writes the given packet to the connection, buffering anything that cannot be written immediately
+func (p *poller) writePacket(c *eventConn, pk *packet.Packet) error {
+	if len(c.out) > 0 {
+		c.out = appendMetadata(c.out, pk.Metadata.Id, pk.Metadata.Operation, pk.Metadata.ContentLength)
+		c.out = append(c.out, pk.Content.Bytes()[:pk.Metadata.ContentLength]...)
+		return nil
+	}
+
+	p.scratch = appendMetadata(p.scratch[:0], pk.Metadata.Id, pk.Metadata.Operation, pk.Metadata.ContentLength)
+	p.scratch = append(p.scratch, pk.Content.Bytes()[:pk.Metadata.ContentLength]...)
+	b := p.scratch
+	if cap(p.scratch) > p.loop.server.options.BufferSize*4 {
+		p.scratch = nil
+	}
+	return p.write(c, b)
+}
+
+// write writes the given data to the connection, buffering anything that cannot be written immediately
+func (p *poller) write(c *eventConn, b []byte) error {
+	if len(c.out) > 0 {
+		c.out = append(c.out, b...)
+		return nil
+	}
+
+	n, err := writeFd(c.fd, b)
+	if err != nil {
+		return err
+	}
+	if n < len(b) {
+		// Reading from the connection stops until the buffered data has been written
+		c.out = append(c.out, b[n:]...)
+		c.written = p.now
+		return syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_MOD, c.fd, &syscall.EpollEvent{Events: writeEvents, Fd: int32(c.fd)})
+	}
+	return nil
+}
+
+// flush writes as much of the connection's buffered data as possible
+func (p *poller) flush(c *eventConn) error {
+	n, err := writeFd(c.fd, c.out)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		c.written = p.now
+	}
+	if n < len(c.out) {
+		c.out = c.out[:copy(c.out, c.out[n:])]
+		return nil
+	}
+	c.out = nil
+	return syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_MOD, c.fd, &syscall.EpollEvent{Events: readEvents, Fd: int32(c.fd)})
+}
+
+// writeFd writes to the given non-blocking file descriptor until it would block
+func writeFd(fd int, b []byte) (int, error) {
+	var written int
+	for written < len(b) {
+		n, err := syscall.Write(fd, b[written:])
+		if err != nil {
+			if errors.Is(err, syscall.EINTR) {
+				continue
+			}
+			if errors.Is(err, syscall.EAGAIN) {
+				break
+			}
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
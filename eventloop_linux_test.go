@@ -0,0 +1,568 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+	"github.com/loopholelabs/testing/conn"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+func TestServerEventLoop(t *testing.T) {
+	t.Parallel()
+
+	const testSize = 100
+	const packetSize = 512
+	const largePacketSize = DefaultBufferSize*2 + 3
+
+	runner := func(t *testing.T, num int) {
+		serverHandlerTable := make(HandlerTable)
+		serverHandlerTable[metadata.PacketPing] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+			outgoing = incoming
+			return
+		}
+		serverHandlerTable[metadata.PacketProbe] = func(_ context.Context, _ *packet.Packet) (outgoing *packet.Packet, action Action) {
+			action = CLOSE
+			return
+		}
+
+		emptyLogger := logging.Test(t, logging.Noop, t.Name())
+		s, err := NewServer(serverHandlerTable, context.Background(), WithLogger(emptyLogger))
+		require.NoError(t, err)
+
+		err = s.SetEventLoop(2)
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			err := s.Start(conn.Listen)
+			require.NoError(t, err)
+			wg.Done()
+		}()
+
+		<-s.started()
+		listenAddr := s.listener.Addr().String()
+
+		data := make([]byte, largePacketSize)
+		_, err = rand.Read(data)
+		require.NoError(t, err)
+
+		var clientWg sync.WaitGroup
+		for i := 0; i < num; i++ {
+			clientWg.Add(1)
+			go func() {
+				defer clientWg.Done()
+				c, err := ConnectAsync(listenAddr, DefaultDeadline, emptyLogger, nil)
+				require.NoError(t, err)
+
+				p := packet.Get()
+				p.Metadata.Operation = metadata.PacketPing
+				for q := 0; q <= testSize; q++ {
+					size := packetSize
+					if q%10 == 0 {
+						size = largePacketSize
+					}
+					p.Content.Reset()
+					p.Content.Write(data[:size])
+					p.Metadata.Id = uint16(q)
+					p.Metadata.ContentLength = uint32(size)
+					err = c.WritePacket(p)
+					require.NoError(t, err)
+				}
+				packet.Put(p)
+
+				for q := 0; q <= testSize; q++ {
+					p, err = c.ReadPacket()
+					require.NoError(t, err)
+					assert.Equal(t, uint16(q), p.Metadata.Id)
+					assert.Equal(t, data[:p.Metadata.ContentLength], p.Content.Bytes())
+					packet.Put(p)
+				}
+
+				p = packet.Get()
+				p.Metadata.Operation = metadata.PacketProbe
+				err = c.WritePacket(p)
+				require.NoError(t, err)
+				packet.Put(p)
+
+				_, err = c.ReadPacket()
+				require.ErrorIs(t, err, ConnectionClosed)
+				_ = c.Close()
+			}()
+		}
+
+		clientWg.Wait()
+
+		err = s.Shutdown()
+		assert.NoError(t, err)
+		wg.Wait()
+	}
+
+	t.Run("1", func(t *testing.T) { runner(t, 1) })
+	t.Run("10", func(t *testing.T) { runner(t, 10) })
+	t.Run("100", func(t *testing.T) { runner(t, 100) })
+}
+
+func TestServerEventLoopShutdown(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	s, err := NewServer(make(HandlerTable), context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	err = s.SetEventLoop(0)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		err := s.Start(conn.Listen)
+		require.NoError(t, err)
+		wg.Done()
+	}()
+
+	<-s.started()
+
+	c, err := ConnectAsync(s.listener.Addr().String(), DefaultDeadline, emptyLogger, nil)
+	require.NoError(t, err)
+
+	err = c.Flush()
+	require.NoError(t, err)
+
+	// Connections served by the event loop are tracked with the server's other connections
+	require.Eventually(t, func() bool {
+		s.connectionsMu.Lock()
+		defer s.connectionsMu.Unlock()
+		return len(s.connections) == 1
+	}, DefaultDeadline, time.Millisecond*10)
+
+	err = s.Shutdown()
+	assert.NoError(t, err)
+	wg.Wait()
+	assert.Empty(t, s.connections)
+
+	_, err = c.ReadPacket()
+	require.ErrorIs(t, err, ConnectionClosed)
+	_ = c.Close()
+}
+
+func TestServerEventLoopIncompatible(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	s, err := NewServer(make(HandlerTable), context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	// Settings that are supported by the event loop
+	s.SetConcurrency(1)
+	s.SetOrderingKey(nil)
+	s.SetPanicPolicy(PanicRespond)
+	err = s.SetEventLoop(1)
+	require.NoError(t, err)
+
+	s.SetHandlerTimeout(time.Second)
+	s.ConnContext = func(ctx context.Context, _ *Async) context.Context {
+		return ctx
+	}
+	err = s.SetEventLoop(1)
+	assert.ErrorIs(t, err, EventLoopIncompatible)
+	assert.ErrorContains(t, err, "handler timeouts, ConnContext")
+
+	// Settings that are changed after the event loop was configured are rejected by Start
+	s, err = NewServer(make(HandlerTable), context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	err = s.SetEventLoop(1)
+	require.NoError(t, err)
+	err = s.SetOnClosed(func(*Async, error) {})
+	require.NoError(t, err)
+
+	err = s.Start(conn.Listen)
+	assert.ErrorIs(t, err, EventLoopIncompatible)
+	assert.ErrorContains(t, err, "onClosed")
+}
+
+func TestServerEventLoopMaxContentLength(t *testing.T) {
+	t.Parallel()
+
+	serverHandlerTable := make(HandlerTable)
+	serverHandlerTable[metadata.PacketPing] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		outgoing = incoming
+		return
+	}
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	s, err := NewServer(serverHandlerTable, context.Background(), WithLogger(emptyLogger), WithMaxContentLength(16))
+	require.NoError(t, err)
+
+	err = s.SetEventLoop(1)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		err := s.Start(conn.Listen)
+		require.NoError(t, err)
+		wg.Done()
+	}()
+
+	<-s.started()
+
+	c, err := ConnectAsync(s.listener.Addr().String(), DefaultDeadline, emptyLogger, nil)
+	require.NoError(t, err)
+
+	p := packet.Get()
+	p.Metadata.Operation = metadata.PacketPing
+	p.Content.Write(make([]byte, 16))
+	p.Metadata.ContentLength = uint32(p.Content.Len())
+	err = c.WritePacket(p)
+	require.NoError(t, err)
+
+	p, err = c.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(16), p.Metadata.ContentLength)
+
+	// Packets with a larger content length close the connection before any of their content is read
+	p.Content.Write(make([]byte, 1))
+	p.Metadata.ContentLength = uint32(p.Content.Len())
+	err = c.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	_, err = c.ReadPacket()
+	require.ErrorIs(t, err, ConnectionClosed)
+
+	_ = c.Close()
+	err = s.Shutdown()
+	assert.NoError(t, err)
+	wg.Wait()
+}
+
+func TestServerEventLoopPanic(t *testing.T) {
+	t.Parallel()
+
+	serverHandlerTable := make(HandlerTable)
+	serverHandlerTable[metadata.PacketPing] = func(_ context.Context, _ *packet.Packet) (outgoing *packet.Packet, action Action) {
+		panic("test panic")
+	}
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	s, err := NewServer(serverHandlerTable, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	err = s.SetEventLoop(1)
+	require.NoError(t, err)
+	s.SetPanicPolicy(PanicRespond)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		err := s.Start(conn.Listen)
+		require.NoError(t, err)
+		wg.Done()
+	}()
+
+	<-s.started()
+
+	c, err := ConnectAsync(s.listener.Addr().String(), DefaultDeadline, emptyLogger, nil)
+	require.NoError(t, err)
+
+	p := packet.Get()
+	p.Metadata.Id = 8
+	p.Metadata.Operation = metadata.PacketPing
+	err = c.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	p, err = c.ReadPacket()
+	require.NoError(t, err)
+	remoteErr := RemoteErrorFromPacket(p)
+	require.NotNil(t, remoteErr)
+	assert.Equal(t, uint16(8), remoteErr.Id)
+	assert.Equal(t, ErrorPanic, remoteErr.Code)
+	packet.Put(p)
+	assert.Equal(t, uint64(1), s.Panics())
+
+	_ = c.Close()
+	err = s.Shutdown()
+	assert.NoError(t, err)
+	wg.Wait()
+}
+
+func TestServerEventLoopResponder(t *testing.T) {
+	t.Parallel()
+
+	late := make(chan *Responder, 1)
+	serverHandlerTable := make(HandlerTable)
+	serverHandlerTable[metadata.PacketPing] = Respond(func(_ context.Context, incoming *packet.Packet, responder *Responder) (action Action) {
+		assert.NoError(t, responder.Send(incoming))
+		late <- responder
+		return
+	})
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	s, err := NewServer(serverHandlerTable, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	err = s.SetEventLoop(1)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		err := s.Start(conn.Listen)
+		require.NoError(t, err)
+		wg.Done()
+	}()
+
+	<-s.started()
+
+	c, err := ConnectAsync(s.listener.Addr().String(), DefaultDeadline, emptyLogger, nil)
+	require.NoError(t, err)
+
+	p := packet.Get()
+	p.Metadata.Id = 8
+	p.Metadata.Operation = metadata.PacketPing
+	p.Content.Write([]byte("response"))
+	p.Metadata.ContentLength = uint32(p.Content.Len())
+	err = c.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	p, err = c.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(8), p.Metadata.Id)
+	assert.Equal(t, metadata.PacketPing, p.Metadata.Operation)
+	assert.Equal(t, "response", string(p.Content.Bytes()))
+	packet.Put(p)
+
+	// Responders cannot be used by the event loop once their handler has returned
+	responder := <-late
+	assert.ErrorIs(t, responder.Finish(nil), ResponderUnavailable)
+
+	_ = c.Close()
+	err = s.Shutdown()
+	assert.NoError(t, err)
+	wg.Wait()
+}
+
+func TestServerEventLoopBatchHandler(t *testing.T) {
+	t.Parallel()
+
+	const packets = 16
+
+	var mu sync.Mutex
+	var handled int
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	s, err := NewServer(make(HandlerTable), context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	err = s.SetBatchHandler(metadata.PacketPing, 0, func(_ context.Context, incoming []*packet.Packet) (outgoing []*packet.Packet, action Action) {
+		mu.Lock()
+		handled += len(incoming)
+		mu.Unlock()
+		return incoming, NONE
+	})
+	require.NoError(t, err)
+
+	err = s.SetEventLoop(1)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		err := s.Start(conn.Listen)
+		require.NoError(t, err)
+		wg.Done()
+	}()
+
+	<-s.started()
+
+	c, err := ConnectAsync(s.listener.Addr().String(), DefaultDeadline, emptyLogger, nil)
+	require.NoError(t, err)
+
+	p := packet.Get()
+	p.Metadata.Operation = metadata.PacketPing
+	for i := 0; i < packets; i++ {
+		p.Metadata.Id = uint16(i)
+		err = c.WritePacket(p)
+		require.NoError(t, err)
+	}
+	packet.Put(p)
+
+	for i := 0; i < packets; i++ {
+		p, err = c.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, uint16(i), p.Metadata.Id)
+		packet.Put(p)
+	}
+	mu.Lock()
+	assert.Equal(t, packets, handled)
+	mu.Unlock()
+
+	_ = c.Close()
+	err = s.Shutdown()
+	assert.NoError(t, err)
+	wg.Wait()
+}
+
+func TestServerEventLoopNamedOperations(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	s, err := NewServer(make(HandlerTable), context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	err = s.SetNamedHandlerTable(NamedHandlerTable{"users.Get": versionHandler("get", nil, nil)})
+	require.NoError(t, err)
+
+	err = s.SetEventLoop(1)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		err := s.Start(conn.Listen)
+		require.NoError(t, err)
+		wg.Done()
+	}()
+
+	<-s.started()
+
+	c, err := ConnectAsync(s.listener.Addr().String(), DefaultDeadline, emptyLogger, nil)
+	require.NoError(t, err)
+
+	_, _, err = negotiateOperations(c, []string{"users.Get", "users.List"}, DefaultDeadline)
+	assert.ErrorIs(t, err, OperationNameMismatch)
+
+	operations, _, err := negotiateOperations(c, []string{"users.Get"}, DefaultDeadline)
+	require.NoError(t, err)
+
+	p := packet.Get()
+	p.Metadata.Operation = operations["users.Get"]
+	err = c.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	p, err = c.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, NamedOperationStart, p.Metadata.Operation)
+	assert.Equal(t, "get", string(p.Content.Bytes()))
+	packet.Put(p)
+
+	_ = c.Close()
+	err = s.Shutdown()
+	assert.NoError(t, err)
+	wg.Wait()
+}
+
+func TestServerEventLoopIdle(t *testing.T) {
+	t.Parallel()
+
+	const deadline = time.Millisecond * 200
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	s, err := NewServer(make(HandlerTable), context.Background(), WithLogger(emptyLogger), WithDeadline(deadline), WithPingInterval(deadline/4))
+	require.NoError(t, err)
+
+	err = s.SetEventLoop(1)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		err := s.Start(conn.Listen)
+		require.NoError(t, err)
+		wg.Done()
+	}()
+
+	<-s.started()
+
+	c, err := net.Dial("tcp", s.listener.Addr().String())
+	require.NoError(t, err)
+
+	// Idle connections are kept alive for as long as they reply to the server's PINGs
+	header := make([]byte, metadata.Size)
+	pong := appendMetadata(nil, 0, PONG, 0)
+	started := time.Now()
+	for time.Since(started) < deadline*3 {
+		err = c.SetReadDeadline(time.Now().Add(deadline))
+		require.NoError(t, err)
+		_, err = io.ReadFull(c, header)
+		require.NoError(t, err)
+		assert.Equal(t, PING, binary.BigEndian.Uint16(header[metadata.OperationOffset:metadata.OperationOffset+metadata.OperationSize]))
+		_, err = c.Write(pong)
+		require.NoError(t, err)
+	}
+
+	// Once they stop replying they are closed after the Deadline
+	err = c.SetReadDeadline(time.Now().Add(DefaultDeadline))
+	require.NoError(t, err)
+	_, err = io.Copy(io.Discard, c)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(started), deadline*3)
+
+	_ = c.Close()
+	err = s.Shutdown()
+	assert.NoError(t, err)
+	wg.Wait()
+}
+
+func TestServerEventLoopStream(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	s, err := NewServer(make(HandlerTable), context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	err = s.SetEventLoop(1)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		err := s.Start(conn.Listen)
+		require.NoError(t, err)
+		wg.Done()
+	}()
+
+	<-s.started()
+
+	c, err := ConnectAsync(s.listener.Addr().String(), DefaultDeadline, emptyLogger, nil)
+	require.NoError(t, err)
+
+	// Streams opened by the client are closed by the server, as they are by the default stream handler
+	stream := c.NewStream(0)
+	p := packet.Get()
+	p.Content.Write([]byte{1})
+	p.Metadata.ContentLength = 1
+	err = stream.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	select {
+	case <-stream.CloseChannel():
+	case <-time.After(DefaultDeadline):
+		t.Fatal("timed out waiting for stream to be closed")
+	}
+
+	_ = c.Close()
+	err = s.Shutdown()
+	assert.NoError(t, err)
+	wg.Wait()
+}
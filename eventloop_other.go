@@ -0,0 +1,24 @@
+//go:build !linux
+
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"net"
+)
+
+// eventLoop is not supported on this platform
+type eventLoop struct{}
+
+const eventLoopSupported = false
+
+func newEventLoop(_ *Server, _ int) (*eventLoop, error) {
+	return nil, EventLoopUnsupported
+}
+
+func (l *eventLoop) serve(_ net.Conn) bool {
+	return false
+}
+
+func (l *eventLoop) close() {}
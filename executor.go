@@ -0,0 +1,327 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"slices"
+	"sync"
+)
+
+// Executor is used by the Server and Client to run the handlers for incoming packets.
+//
+// Execute is called for every incoming packet, in the order that the packets were received on the connection,
+// from the goroutine that reads packets from that connection. This means that no further packets are read from the
+// connection while Execute blocks, which executors can use to apply backpressure. The exception to this is packets
+// that were queued because of an operation or connection concurrency limit, which are submitted from other goroutines.
+//
+// Once a connection has been closed and all of its tasks have completed, Release is called with the connection
+// so that the executor can free any resources it holds for it. Execute is never called for a connection
+// after Release has been called for it.
+type Executor interface {
+	// Execute runs (or schedules) the task, which handles a single packet received on conn
+	Execute(conn *Async, task func())
+
+	// Release is called once conn has been closed and all of its tasks have completed
+	Release(conn *Async)
+}
+
+// inlineExecutor runs every task on the goroutine that reads packets from the connection
+type inlineExecutor struct{}
+
+// NewInlineExecutor returns an Executor that runs every task directly on the goroutine that reads
+// packets from the connection, so packets from each connection are handled one at a time and in order
+// without any scheduling overhead. This is equivalent to a concurrency of 1.
+func NewInlineExecutor() Executor {
+	return inlineExecutor{}
+}
+
+func (inlineExecutor) Execute(_ *Async, task func()) {
+	task()
+}
+
+func (inlineExecutor) Release(_ *Async) {}
+
+// goroutineExecutor runs every task in its own goroutine, optionally limiting the number of concurrent goroutines
+type goroutineExecutor struct {
+	limiter chan struct{}
+}
+
+// NewGoroutineExecutor returns an Executor that runs every task in a new goroutine. If limit is greater than 0,
+// at most limit tasks will run at the same time (across all connections), and Execute will block until a
+// running task completes. This is how packets are handled if SetConcurrency is used.
+func NewGoroutineExecutor(limit uint64) Executor {
+	e := new(goroutineExecutor)
+	if limit > 0 {
+		e.limiter = make(chan struct{}, limit)
+	}
+	return e
+}
+
+func (e *goroutineExecutor) Execute(_ *Async, task func()) {
+	if e.limiter == nil {
+		go task()
+		return
+	}
+	e.limiter <- struct{}{}
+	go func() {
+		task()
+		<-e.limiter
+	}()
+}
+
+func (e *goroutineExecutor) Release(_ *Async) {}
+
+// PoolExecutor runs tasks on a fixed pool of worker goroutines that is shared by all connections
+type PoolExecutor struct {
+	tasks     chan func()
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// NewPoolExecutor returns a PoolExecutor that runs tasks on the given number of worker goroutines, which are started
+// immediately. Up to queueSize tasks can be waiting for a worker before Execute blocks. Tasks from the same connection
+// may run concurrently and complete out of order.
+//
+// The PoolExecutor is not closed by the Server or Client it is used by, and Close must be called
+// once it is no longer being used to stop its worker goroutines.
+func NewPoolExecutor(workers int, queueSize int) *PoolExecutor {
+	e := &PoolExecutor{
+		tasks: make(chan func(), queueSize),
+	}
+	e.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go e.work()
+	}
+	return e
+}
+
+func (e *PoolExecutor) work() {
+	defer e.wg.Done()
+	for task := range e.tasks {
+		task()
+	}
+}
+
+func (e *PoolExecutor) Execute(_ *Async, task func()) {
+	e.tasks <- task
+}
+
+func (e *PoolExecutor) Release(_ *Async) {}
+
+// Close stops the PoolExecutor's worker goroutines once all the queued tasks have completed.
+// Execute must not be called once Close has been called.
+func (e *PoolExecutor) Close() {
+	e.closeOnce.Do(func() {
+		close(e.tasks)
+	})
+	e.wg.Wait()
+}
+
+// connectionExecutor runs the tasks for each connection on a worker goroutine dedicated to that connection
+type connectionExecutor struct {
+	queueSize int
+	mu        sync.Mutex
+	workers   map[*Async]chan func()
+}
+
+// NewConnectionExecutor returns an Executor that runs the tasks for each connection on a worker goroutine that is
+// dedicated to that connection, so packets from each connection are handled in order without blocking the
+// connection's read loop, while packets from different connections are handled concurrently. Up to queueSize
+// tasks can be waiting for a connection's worker before Execute blocks. Workers are started when the first
+// packet from a connection is received, and are stopped once the connection has been closed.
+func NewConnectionExecutor(queueSize int) Executor {
+	return &connectionExecutor{
+		queueSize: queueSize,
+		workers:   make(map[*Async]chan func()),
+	}
+}
+
+func (e *connectionExecutor) Execute(conn *Async, task func()) {
+	e.mu.Lock()
+	tasks, ok := e.workers[conn]
+	if !ok {
+		tasks = make(chan func(), e.queueSize)
+		e.workers[conn] = tasks
+		go func() {
+			for task := range tasks {
+				task()
+			}
+		}()
+	}
+	e.mu.Unlock()
+	tasks <- task
+}
+
+func (e *connectionExecutor) Release(conn *Async) {
+	e.mu.Lock()
+	if tasks, ok := e.workers[conn]; ok {
+		close(tasks)
+		delete(e.workers, conn)
+	}
+	e.mu.Unlock()
+}
+
+// FairExecutor runs tasks on a fixed pool of worker goroutines, and uses weighted deficit round-robin scheduling to
+// decide which connection's next task runs whenever a worker becomes available. Every connection with queued tasks
+// gets to run up to its weight in tasks per round, so a connection that sends large numbers of packets cannot starve
+// the others. Tasks from each connection are run one at a time and in order, so a connection never has more than
+// one task running, while tasks from different connections run concurrently on the worker goroutines.
+//
+// Connections have a weight of 1 unless SetWeight is used to change it, which can be done from the
+// Server's ConnContext function.
+type FairExecutor struct {
+	mu        sync.Mutex
+	work      *sync.Cond
+	space     *sync.Cond
+	queueSize int
+	queues    map[*Async]*fairQueue
+	ring      []*fairQueue
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// fairQueue holds the queued tasks for a single connection. A queue is in the ring while it is active (has queued
+// tasks), and is skipped by the workers while one of its tasks is running.
+type fairQueue struct {
+	weight  uint64
+	deficit uint64
+	tasks   []func()
+	active  bool
+	running bool
+}
+
+// NewFairExecutor returns a FairExecutor that runs tasks on the given number of worker goroutines, which are started
+// immediately. Up to queueSize tasks can be queued for each connection before Execute blocks for that connection.
+//
+// The FairExecutor is not closed by the Server or Client it is used by, and Close must be called
+// once it is no longer being used to stop its worker goroutines.
+func NewFairExecutor(workers int, queueSize int) *FairExecutor {
+	if queueSize < 1 {
+		queueSize = 1
+	}
+	e := &FairExecutor{
+		queueSize: queueSize,
+		queues:    make(map[*Async]*fairQueue),
+	}
+	e.work = sync.NewCond(&e.mu)
+	e.space = sync.NewCond(&e.mu)
+	e.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go e.worker()
+	}
+	return e
+}
+
+// SetWeight sets the number of tasks that can run for the given connection in each scheduling round,
+// relative to the other connections. A weight of 0 is treated as 1.
+func (e *FairExecutor) SetWeight(conn *Async, weight uint64) {
+	if weight == 0 {
+		weight = 1
+	}
+	e.mu.Lock()
+	e.queue(conn).weight = weight
+	e.mu.Unlock()
+}
+
+// queue returns the queue for the given connection, creating it if required. It must be called with the mutex held.
+func (e *FairExecutor) queue(conn *Async) *fairQueue {
+	q, ok := e.queues[conn]
+	if !ok {
+		q = &fairQueue{weight: 1}
+		e.queues[conn] = q
+	}
+	return q
+}
+
+func (e *FairExecutor) Execute(conn *Async, task func()) {
+	e.mu.Lock()
+	q := e.queue(conn)
+	for len(q.tasks) >= e.queueSize && !e.closed {
+		e.space.Wait()
+	}
+	q.tasks = append(q.tasks, task)
+	if !q.active {
+		q.active = true
+		e.ring = append(e.ring, q)
+	}
+	e.mu.Unlock()
+	e.work.Signal()
+}
+
+func (e *FairExecutor) Release(conn *Async) {
+	e.mu.Lock()
+	delete(e.queues, conn)
+	e.mu.Unlock()
+}
+
+// next returns the next task to run from the first queue in the ring that does not already have a running task
+// (along with that queue), or nil if the executor has been closed and there are no queued tasks.
+// It must be called with the mutex held.
+func (e *FairExecutor) next() (*fairQueue, func()) {
+	for {
+		for i, q := range e.ring {
+			if q.running {
+				continue
+			}
+			if q.deficit == 0 {
+				q.deficit = q.weight
+			}
+			task := q.tasks[0]
+			q.tasks[0] = nil
+			if len(q.tasks) == e.queueSize {
+				e.space.Broadcast()
+			}
+			q.tasks = q.tasks[1:]
+			q.deficit--
+			q.running = true
+			if len(q.tasks) == 0 {
+				q.tasks = nil
+				q.deficit = 0
+				q.active = false
+				e.ring = slices.Delete(e.ring, i, i+1)
+			} else if q.deficit == 0 {
+				e.ring = append(slices.Delete(e.ring, i, i+1), q)
+			}
+			return q, task
+		}
+		if e.closed && len(e.ring) == 0 {
+			return nil, nil
+		}
+		e.work.Wait()
+	}
+}
+
+func (e *FairExecutor) worker() {
+	defer e.wg.Done()
+	for {
+		e.mu.Lock()
+		q, task := e.next()
+		e.mu.Unlock()
+		if task == nil {
+			return
+		}
+		task()
+
+		// The connection's next task can only run once this one has completed
+		e.mu.Lock()
+		q.running = false
+		queued, done := q.active, e.closed && len(e.ring) == 0
+		e.mu.Unlock()
+		if queued {
+			e.work.Signal()
+		} else if done {
+			e.work.Broadcast()
+		}
+	}
+}
+
+// Close stops the FairExecutor's worker goroutines once all the queued tasks have completed.
+// Execute must not be called once Close has been called.
+func (e *FairExecutor) Close() {
+	e.mu.Lock()
+	e.closed = true
+	e.mu.Unlock()
+	e.work.Broadcast()
+	e.space.Broadcast()
+	e.wg.Wait()
+}
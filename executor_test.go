@@ -0,0 +1,253 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+	"github.com/loopholelabs/testing/conn/pair"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+func TestExecutors(t *testing.T) {
+	t.Parallel()
+
+	const testSize = 1000
+
+	t.Run("inline", func(t *testing.T) {
+		t.Parallel()
+		e := NewInlineExecutor()
+		var count int
+		for i := 0; i < testSize; i++ {
+			e.Execute(nil, func() {
+				count++
+			})
+		}
+		assert.Equal(t, testSize, count)
+	})
+
+	t.Run("goroutine", func(t *testing.T) {
+		t.Parallel()
+		const limit = 4
+		e := NewGoroutineExecutor(limit)
+		var wg sync.WaitGroup
+		var running, maxRunning atomic.Int64
+		wg.Add(testSize)
+		for i := 0; i < testSize; i++ {
+			e.Execute(nil, func() {
+				n := running.Add(1)
+				for m := maxRunning.Load(); n > m && !maxRunning.CompareAndSwap(m, n); m = maxRunning.Load() {
+				}
+				running.Add(-1)
+				wg.Done()
+			})
+		}
+		wg.Wait()
+		assert.LessOrEqual(t, maxRunning.Load(), int64(limit))
+	})
+
+	t.Run("pool", func(t *testing.T) {
+		t.Parallel()
+		e := NewPoolExecutor(4, 16)
+		var count atomic.Int64
+		for i := 0; i < testSize; i++ {
+			e.Execute(nil, func() {
+				count.Add(1)
+			})
+		}
+		e.Close()
+		assert.Equal(t, int64(testSize), count.Load())
+	})
+
+	t.Run("connection", func(t *testing.T) {
+		t.Parallel()
+		e := NewConnectionExecutor(16)
+		conns := []*Async{new(Async), new(Async)}
+		results := make([][]int, len(conns))
+		var wg sync.WaitGroup
+		wg.Add(testSize * len(conns))
+		for i := 0; i < testSize; i++ {
+			for j, conn := range conns {
+				i, j := i, j
+				e.Execute(conn, func() {
+					results[j] = append(results[j], i)
+					wg.Done()
+				})
+			}
+		}
+		wg.Wait()
+		for _, conn := range conns {
+			e.Release(conn)
+		}
+		for _, result := range results {
+			require.Len(t, result, testSize)
+			for i, v := range result {
+				assert.Equal(t, i, v)
+			}
+		}
+	})
+}
+
+func TestServerExecutor(t *testing.T) {
+	t.Parallel()
+
+	const testSize = 100
+
+	pool := NewPoolExecutor(2, 0)
+	t.Cleanup(pool.Close)
+	fair := NewFairExecutor(2, 8)
+	t.Cleanup(fair.Close)
+
+	for name, executor := range map[string]Executor{"pool": pool, "connection": NewConnectionExecutor(8), "fair": fair} {
+		executor := executor
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var handled atomic.Int64
+			var last atomic.Int64
+			last.Store(-1)
+			serverHandlerTable := make(HandlerTable)
+			serverHandlerTable[metadata.PacketPing] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+				if name != "pool" {
+					assert.Equal(t, last.Load()+1, int64(incoming.Metadata.Id))
+					last.Store(int64(incoming.Metadata.Id))
+				}
+				if handled.Add(1) == testSize {
+					outgoing = incoming
+				}
+				return
+			}
+
+			emptyLogger := logging.Test(t, logging.Noop, t.Name())
+			s, err := NewServer(serverHandlerTable, context.Background(), WithLogger(emptyLogger))
+			require.NoError(t, err)
+
+			err = s.SetExecutor(nil)
+			require.ErrorIs(t, err, ExecutorNil)
+			err = s.SetExecutor(executor)
+			require.NoError(t, err)
+
+			serverConn, clientConn, err := pair.New()
+			require.NoError(t, err)
+
+			go s.ServeConn(serverConn)
+
+			c := NewAsync(clientConn, emptyLogger)
+
+			p := packet.Get()
+			p.Metadata.Operation = metadata.PacketPing
+			for q := 0; q < testSize; q++ {
+				p.Metadata.Id = uint16(q)
+				err = c.WritePacket(p)
+				require.NoError(t, err)
+			}
+			packet.Put(p)
+
+			p, err = c.ReadPacket()
+			require.NoError(t, err)
+			assert.Equal(t, metadata.PacketPing, p.Metadata.Operation)
+			packet.Put(p)
+			assert.Equal(t, int64(testSize), handled.Load())
+
+			err = c.Close()
+			assert.NoError(t, err)
+			err = s.Shutdown()
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestFairExecutor(t *testing.T) {
+	t.Parallel()
+
+	const testSize = 12
+
+	run := func(t *testing.T, weights []uint64) []int {
+		e := NewFairExecutor(1, testSize)
+		defer e.Close()
+
+		conns := make([]*Async, len(weights))
+		for i := range conns {
+			conns[i] = new(Async)
+			e.SetWeight(conns[i], weights[i])
+		}
+
+		// The worker is blocked until all the tasks have been queued
+		block := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		e.Execute(new(Async), func() {
+			<-block
+			wg.Done()
+		})
+
+		var order []int
+		for i, conn := range conns {
+			for q := 0; q < testSize; q++ {
+				i := i
+				wg.Add(1)
+				e.Execute(conn, func() {
+					order = append(order, i)
+					wg.Done()
+				})
+			}
+		}
+		close(block)
+		wg.Wait()
+		return order
+	}
+
+	t.Run("equal", func(t *testing.T) {
+		t.Parallel()
+		order := run(t, []uint64{1, 1})
+		require.Len(t, order, testSize*2)
+		for i, conn := range order {
+			assert.Equal(t, i%2, conn)
+		}
+	})
+
+	t.Run("serialised", func(t *testing.T) {
+		t.Parallel()
+		e := NewFairExecutor(4, testSize)
+		defer e.Close()
+
+		// Tasks from the same connection never run concurrently, even with several workers available
+		conn := new(Async)
+		var running atomic.Int64
+		var order []int
+		var wg sync.WaitGroup
+		for q := 0; q < testSize; q++ {
+			q := q
+			wg.Add(1)
+			e.Execute(conn, func() {
+				assert.Equal(t, int64(1), running.Add(1))
+				time.Sleep(time.Millisecond)
+				order = append(order, q)
+				running.Add(-1)
+				wg.Done()
+			})
+		}
+		wg.Wait()
+		require.Len(t, order, testSize)
+		for i, q := range order {
+			assert.Equal(t, i, q)
+		}
+	})
+
+	t.Run("weighted", func(t *testing.T) {
+		t.Parallel()
+		order := run(t, []uint64{3, 1})
+		require.Len(t, order, testSize*2)
+		assert.Equal(t, []int{0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 1}, order[:12])
+	})
+}
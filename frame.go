@@ -0,0 +1,81 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/loopholelabs/common/pkg/pool"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+var (
+	framePool = pool.NewPool(newFrame)
+)
+
+// frame is an encoded packet, or a flush request, that is waiting
+// to be written to the underlying net.Conn by the write loop.
+//
+// If done is set, the write loop writes the frame immediately and then sends the result of the write on done.
+// If reader is set, the content of the frame is copied from the reader once the rest of the frame has been written.
+type frame struct {
+	buf     []byte
+	content []byte
+	reader  *io.LimitedReader
+	done    chan error
+}
+
+func newFrame() *frame {
+	return &frame{
+		buf: make([]byte, 0, metadata.Size+512),
+	}
+}
+
+func (f *frame) Reset() {
+	f.buf = f.buf[:0]
+	f.content = nil
+	f.reader = nil
+	f.done = nil
+}
+
+// size returns the number of bytes that will be written for the frame
+func (f *frame) size() int {
+	if f.reader != nil {
+		return len(f.buf) + int(f.reader.N)
+	}
+	return len(f.buf) + len(f.content)
+}
+
+// appendMetadata encodes the given packet metadata and appends it to b
+func appendMetadata(b []byte, id uint16, operation uint16, contentLength uint32) []byte {
+	b = binary.BigEndian.AppendUint16(b, id)
+	b = binary.BigEndian.AppendUint16(b, operation)
+	return binary.BigEndian.AppendUint32(b, contentLength)
+}
+
+// encodeFrame gets a frame from the frame pool and encodes the given packet's metadata into it.
+//
+// If direct is false the packet's content is copied into the frame, otherwise the frame references the
+// packet's content directly and the packet must not be modified until the frame has been written.
+func encodeFrame(p *packet.Packet, direct bool) *frame {
+	f := framePool.Get()
+	f.buf = appendMetadata(f.buf, p.Metadata.Id, p.Metadata.Operation, p.Metadata.ContentLength)
+	if direct {
+		f.content = p.Content.Bytes()[:p.Metadata.ContentLength]
+	} else {
+		f.buf = append(f.buf, p.Content.Bytes()[:p.Metadata.ContentLength]...)
+	}
+	return f
+}
+
+// putFrame returns a frame to the frame pool if its buffer is no larger than maxSize, which is the BufferSize of the
+// connection that used it, so that connections with a larger BufferSize can reuse frames as large as their buffers
+// while a single large packet does not permanently pin a large buffer in memory
+func putFrame(f *frame, maxSize int) {
+	if cap(f.buf) <= maxSize {
+		framePool.Put(f)
+	}
+}
@@ -23,13 +23,18 @@ var (
 	InvalidBufferLength      = errors.New("invalid buffer length")
 	InvalidHandlerTable      = errors.New("invalid handler table configuration, a reserved value may have been used")
 	InvalidOperation         = errors.New("invalid operation in packet, a reserved value may have been used")
+	QueueFull                = errors.New("packet queue full")
+	ContentLengthExceeded    = errors.New("content length exceeds the maximum content length")
 )
 
 // Action is an ENUM used to modify the state of the client or server from a Handler function
 //
 //	NONE: used to do nothing (default)
 //	CLOSE: close the frisbee connection
+//	UPDATE: update the context used by the connection's handlers with the UpdateContext function
 //	SHUTDOWN: shutdown the frisbee client or server
+//
+// Any other value is a custom action, which is handled by the ActionHandler set for it with SetActionHandler.
 type Action int
 
 // These are various frisbee actions, used to modify the state of the client or server from a Handler function:
@@ -39,6 +44,13 @@ const (
 
 	// CLOSE is used to close the frisbee connection
 	CLOSE
+
+	// UPDATE is used to replace the context for the connection's later packets with
+	// the one returned by the UpdateContext function of the client or server
+	UPDATE
+
+	// SHUTDOWN is used to gracefully shut down the frisbee client or server
+	SHUTDOWN
 )
 
 // Handler is the handler function called by frisbee for incoming packets of data, depending on the packet's Metadata.Operation field
@@ -48,7 +60,16 @@ type Handler func(ctx context.Context, incoming *packet.Packet) (outgoing *packe
 // Frisbee will look up the correct handler for that packet.
 type HandlerTable map[uint16]Handler
 
-// These are internal reserved packet types, and are the reason you cannot use 0-9 in Handler functions:
+// These are internal reserved packet types, and are the reason you cannot use 0-9 in Handler functions.
+//
+// OVERLOADED, TIMEOUT, ERROR, FINAL and OPERATIONS use the operations 3 to 7, which were previously unused and named
+// RESERVED3 to RESERVED7. Those names are kept as deprecated aliases, so existing code still compiles, although code
+// that compares operations against them now matches these packet types. Peers that are older than these packet types
+// drop them if they use a Server or Client, and receive them from ReadPacket if they use an Async connection
+// directly. To avoid surprising such peers, they are only ever sent if the feature that sends them has been enabled:
+// OVERLOADED with Server.SetOverloadPolicy, TIMEOUT with SetHandlerTimeout, ERROR with SetPanicPolicy(PanicRespond)
+// or SetUnknownOperationErrors, FINAL when a handler uses a Responder, and OPERATIONS with Client.SetOperationNames
+// (which the Server only responds to).
 const (
 	// PING is used to check if a client is still alive
 	PING = uint16(iota)
@@ -60,15 +81,50 @@ const (
 	// receive packets with the same packet ID until a packet with a ContentLength of 0 is received
 	STREAM
 
-	RESERVED3
-	RESERVED4
-	RESERVED5
-	RESERVED6
-	RESERVED7
+	// OVERLOADED is sent by a Server in place of a response when it sheds a packet because it is overloaded, and
+	// has the same Id as the packet that was shed. Its content is the suggested retry delay in milliseconds.
+	OVERLOADED
+
+	// TIMEOUT is sent in place of a response when the handler for a packet does not return before its
+	// timeout expires, and has the same Id as the packet whose handler timed out
+	TIMEOUT
+
+	// ERROR is sent in place of a response when the handler for a packet fails, and has the same Id as the
+	// packet whose handler failed. Its content is an ErrorCode followed by a message describing the failure.
+	ERROR
+
+	// FINAL is sent by a Responder after the final response for a packet, and has the same Id as the packet
+	// that was responded to. It has no content, and is sent even if there were no responses.
+	FINAL
+
+	// OPERATIONS is sent by a Client while it is connecting with the names of the named operations that it will use,
+	// and by the Server in response (with the same Id) with the operation for each of the names that it handles
+	// (see Server.SetNamedHandlerTable and Client.SetOperationNames)
+	OPERATIONS
+
 	RESERVED8
 	RESERVED9
 )
 
+// These are the names that the reserved packet types had before they were given a meaning, which are kept
+// so that code using them still compiles.
+const (
+	// Deprecated: use OVERLOADED
+	RESERVED3 = OVERLOADED
+
+	// Deprecated: use TIMEOUT
+	RESERVED4 = TIMEOUT
+
+	// Deprecated: use ERROR
+	RESERVED5 = ERROR
+
+	// Deprecated: use FINAL
+	RESERVED6 = FINAL
+
+	// Deprecated: use OPERATIONS
+	RESERVED7 = OPERATIONS
+)
+
 var (
 	// PINGPacket is a pre-allocated Frisbee Packet for PING Packets
 	PINGPacket = &packet.Packet{
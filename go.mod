@@ -11,6 +11,7 @@ require (
 	github.com/loopholelabs/testing v0.2.3
 	github.com/stretchr/testify v1.9.0
 	go.uber.org/goleak v1.3.0
+	golang.org/x/sys v0.24.0
 )
 
 require (
@@ -21,6 +22,5 @@ require (
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/rogpeppe/go-internal v1.13.1 // indirect
 	github.com/rs/zerolog v1.33.0 // indirect
-	golang.org/x/sys v0.24.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,147 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"sync/atomic"
+)
+
+var (
+	ConnectionHandlerTableUnavailable = errors.New("connection handler table cannot be set from this context")
+)
+
+// handlerTables is a validated copy of a HandlerTable (and the NamedHandlerTable of a Server, if it has one) along
+// with the same tables with every handler wrapped by the interceptors. It is never modified once it has been created,
+// so that a Server or Client can swap it atomically while packets are being handled, and packets whose handlers have
+// already been looked up finish on the old table.
+type handlerTables struct {
+	table       HandlerTable
+	intercepted HandlerTable
+
+	named            *namedHandlers
+	namedIntercepted HandlerTable
+}
+
+// newHandlerTables validates and copies the HandlerTable, and wraps its handlers (and the named handlers,
+// if named is not nil) with the interceptors
+func newHandlerTables(handlerTable HandlerTable, named *namedHandlers, interceptors []Interceptor) (*handlerTables, error) {
+	if err := validateHandlerTable(handlerTable, named); err != nil {
+		return nil, err
+	}
+	h := &handlerTables{
+		table: maps.Clone(handlerTable),
+		named: named,
+	}
+	return h.withInterceptors(interceptors), nil
+}
+
+// validateHandlerTable returns InvalidHandlerTable if the HandlerTable has a handler for a reserved operation,
+// or for an operation that is numbered by the Server for named handlers (if named is not nil). As it always has
+// been, a handler for RESERVED9 is allowed for compatibility with existing HandlerTables.
+func validateHandlerTable(handlerTable HandlerTable, named *namedHandlers) error {
+	for i := uint16(0); i < RESERVED9; i++ {
+		if _, ok := handlerTable[i]; ok {
+			return InvalidHandlerTable
+		}
+	}
+	if named != nil {
+		for operation := range handlerTable {
+			if operation >= NamedOperationStart {
+				return InvalidHandlerTable
+			}
+		}
+	}
+	return nil
+}
+
+// withInterceptors returns a copy of the handlerTables with its handlers wrapped by the given interceptors
+func (h *handlerTables) withInterceptors(interceptors []Interceptor) *handlerTables {
+	intercepted := &handlerTables{
+		table:       h.table,
+		intercepted: interceptHandlerTable(h.table, interceptors),
+		named:       h.named,
+	}
+	if h.named != nil {
+		intercepted.namedIntercepted = interceptHandlerTable(h.named.handlers, interceptors)
+	}
+	return intercepted
+}
+
+// lookup returns the handler for the given operation, which may be a named handler
+func (h *handlerTables) lookup(operation uint16) Handler {
+	if handler, ok := h.intercepted[operation]; ok {
+		return handler
+	}
+	return h.namedIntercepted[operation]
+}
+
+// operations returns the operations of the named handlers, or nil if there are none
+func (h *handlerTables) operations() map[string]uint16 {
+	if h.named == nil {
+		return nil
+	}
+	return h.named.operations
+}
+
+// SetConnectionHandlerTable sets the HandlerTable for the connection that the packet being handled was received on,
+// which is used instead of the handler table of the Server or Client for the connection's later packets, and can be
+// used to give each connection its own state machine (for example, switching from a handler table that only
+// handles logging in to the full handler table once the connection has been authenticated). If handlerTable is nil,
+// the connection goes back to using the handler table of the Server or Client.
+//
+// The ctx must be the context that the handler was called with (or one derived from it), and otherwise
+// ConnectionHandlerTableUnavailable is returned. InvalidHandlerTable is returned if the handler table uses a
+// reserved operation. The handler table is copied and wrapped with the Interceptors in the same way as with
+// SetHandlerTable. BatchHandlers (see Server.SetBatchHandler) are not affected by the connection's handler table,
+// and named operations (see Server.SetNamedHandlerTable) are still handled by the NamedHandlerTable of the Server
+// unless the connection's handler table has a handler for the same operation.
+//
+// Packets that are handled concurrently with the handler that sets the connection's handler table may be
+// handled by either handler table, so SetConcurrency(1) or SetOrderingKey should be used if every packet
+// after the one being handled must be handled by the new handler table.
+func SetConnectionHandlerTable(ctx context.Context, handlerTable HandlerTable) error {
+	c, ok := ctx.Value(connectionHandlersKey{}).(*connectionHandlers)
+	if !ok {
+		return ConnectionHandlerTableUnavailable
+	}
+	if handlerTable == nil {
+		c.handlers.Store(nil)
+		return nil
+	}
+	handlers, err := newHandlerTables(handlerTable, nil, *c.interceptors)
+	if err != nil {
+		return err
+	}
+	c.handlers.Store(handlers)
+	return nil
+}
+
+// connectionHandlersKey is the context key for the connectionHandlers of the connection that a packet was received on
+type connectionHandlersKey struct{}
+
+// connectionHandlers holds the handler table of a single connection, which is set with SetConnectionHandlerTable
+// and overrides the handler table of the Server or Client
+type connectionHandlers struct {
+	interceptors *[]Interceptor
+	handlers     atomic.Pointer[handlerTables]
+}
+
+// withConnectionHandlers returns a copy of ctx that carries the given connectionHandlers for SetConnectionHandlerTable
+func withConnectionHandlers(ctx context.Context, c *connectionHandlers) context.Context {
+	return context.WithValue(ctx, connectionHandlersKey{}, c)
+}
+
+// lookup returns the handler for the given operation from the connection's handler table if it has one,
+// and otherwise from the given handler tables of the Server or Client
+func (c *connectionHandlers) lookup(handlers *handlerTables, operation uint16) Handler {
+	if connection := c.handlers.Load(); connection != nil {
+		if handler, ok := connection.intercepted[operation]; ok {
+			return handler
+		}
+		return handlers.namedIntercepted[operation]
+	}
+	return handlers.lookup(operation)
+}
@@ -0,0 +1,237 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+	"github.com/loopholelabs/testing/conn/pair"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// versionHandler returns a handler that responds with the given version, once release is closed
+func versionHandler(version string, started chan<- struct{}, release <-chan struct{}) Handler {
+	return func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		if started != nil {
+			started <- struct{}{}
+			<-release
+		}
+		outgoing = incoming
+		outgoing.Content.Write([]byte(version))
+		outgoing.Metadata.ContentLength = uint32(outgoing.Content.Len())
+		return
+	}
+}
+
+func TestServerSetHandlerTable(t *testing.T) {
+	t.Parallel()
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+
+	serverHandlerTable := make(HandlerTable)
+	serverHandlerTable[metadata.PacketPing] = versionHandler("v1", started, release)
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	s, err := NewServer(serverHandlerTable, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+	s.SetUnknownOperationErrors(true)
+
+	// Changes to the handler table after it has been set have no effect
+	serverHandlerTable[metadata.PacketProbe] = versionHandler("v1", nil, nil)
+	assert.NotContains(t, s.GetHandlerTable(), metadata.PacketProbe)
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+
+	go s.ServeConn(serverConn)
+
+	c := NewAsync(clientConn, emptyLogger)
+
+	p := packet.Get()
+	p.Metadata.Id = 1
+	p.Metadata.Operation = metadata.PacketPing
+	err = c.WritePacket(p)
+	require.NoError(t, err)
+	<-started
+
+	// Handler tables with reserved operations are rejected without replacing the current handler table
+	err = s.SetHandlerTable(HandlerTable{PING: versionHandler("v2", nil, nil)})
+	assert.ErrorIs(t, err, InvalidHandlerTable)
+
+	// The handler table is swapped while the first packet is still being handled
+	err = s.SetHandlerTable(HandlerTable{metadata.PacketPing: versionHandler("v2", nil, nil)})
+	require.NoError(t, err)
+
+	p.Metadata.Id = 2
+	err = c.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	p, err = c.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(2), p.Metadata.Id)
+	assert.Equal(t, "v2", string(p.Content.Bytes()))
+	packet.Put(p)
+
+	close(release)
+
+	p, err = c.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(1), p.Metadata.Id)
+	assert.Equal(t, "v1", string(p.Content.Bytes()))
+	packet.Put(p)
+
+	err = c.Close()
+	assert.NoError(t, err)
+	err = s.Shutdown()
+	assert.NoError(t, err)
+}
+
+func TestValidateHandlerTable(t *testing.T) {
+	t.Parallel()
+
+	handler := versionHandler("", nil, nil)
+	for operation := PING; operation < RESERVED9; operation++ {
+		assert.ErrorIs(t, validateHandlerTable(HandlerTable{operation: handler}, nil), InvalidHandlerTable)
+	}
+
+	// Handlers for RESERVED9 have always been allowed
+	assert.NoError(t, validateHandlerTable(HandlerTable{RESERVED9: handler}, nil))
+	assert.NoError(t, validateHandlerTable(HandlerTable{RESERVED9 + 1: handler}, nil))
+}
+
+func TestClientSetHandlerTable(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	c, err := NewClient(make(HandlerTable), context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+	c.SetUnknownOperationErrors(true)
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+
+	err = c.FromConn(clientConn)
+	require.NoError(t, err)
+
+	server := NewAsync(serverConn, emptyLogger)
+
+	p := packet.Get()
+	p.Metadata.Operation = metadata.PacketPing
+	err = server.WritePacket(p)
+	require.NoError(t, err)
+
+	p2, err := server.ReadPacket()
+	require.NoError(t, err)
+	assert.ErrorIs(t, RemoteErrorFromPacket(p2), UnknownOperation)
+	packet.Put(p2)
+
+	err = c.SetHandlerTable(HandlerTable{metadata.PacketPing: versionHandler("v2", nil, nil)})
+	require.NoError(t, err)
+	assert.Contains(t, c.GetHandlerTable(), metadata.PacketPing)
+
+	err = server.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	p, err = server.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, "v2", string(p.Content.Bytes()))
+	packet.Put(p)
+
+	_ = c.Close()
+	_ = server.Close()
+}
+
+func TestSetConnectionHandlerTable(t *testing.T) {
+	t.Parallel()
+
+	const logout = uint16(12)
+
+	err := SetConnectionHandlerTable(context.Background(), make(HandlerTable))
+	assert.ErrorIs(t, err, ConnectionHandlerTableUnavailable)
+
+	authenticated := make(HandlerTable)
+	authenticated[metadata.PacketPing] = versionHandler("authenticated", nil, nil)
+	authenticated[logout] = func(ctx context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		assert.NoError(t, SetConnectionHandlerTable(ctx, nil))
+		return incoming, NONE
+	}
+
+	serverHandlerTable := make(HandlerTable)
+	serverHandlerTable[metadata.PacketProbe] = func(ctx context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		assert.ErrorIs(t, SetConnectionHandlerTable(ctx, HandlerTable{PING: nil}), InvalidHandlerTable)
+		assert.NoError(t, SetConnectionHandlerTable(ctx, authenticated))
+		return incoming, NONE
+	}
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	s, err := NewServer(serverHandlerTable, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	// Packets are handled in order, so the connection's handler table is switched before the next packet is handled
+	s.SetConcurrency(1)
+	s.SetUnknownOperationErrors(true)
+
+	request := func(t *testing.T, c *Async, operation uint16) *packet.Packet {
+		p := packet.Get()
+		p.Metadata.Operation = operation
+		err := c.WritePacket(p)
+		require.NoError(t, err)
+		packet.Put(p)
+
+		p, err = c.ReadPacket()
+		require.NoError(t, err)
+		return p
+	}
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+	go s.ServeConn(serverConn)
+	c := NewAsync(clientConn, emptyLogger)
+
+	otherServerConn, otherClientConn, err := pair.New()
+	require.NoError(t, err)
+	go s.ServeConn(otherServerConn)
+	other := NewAsync(otherClientConn, emptyLogger)
+
+	p := request(t, c, metadata.PacketPing)
+	assert.ErrorIs(t, RemoteErrorFromPacket(p), UnknownOperation)
+	packet.Put(p)
+
+	p = request(t, c, metadata.PacketProbe)
+	assert.Equal(t, metadata.PacketProbe, p.Metadata.Operation)
+	packet.Put(p)
+
+	p = request(t, c, metadata.PacketPing)
+	assert.Equal(t, "authenticated", string(p.Content.Bytes()))
+	packet.Put(p)
+
+	// Only the connection that switched its handler table uses the new handler table
+	p = request(t, other, metadata.PacketPing)
+	assert.ErrorIs(t, RemoteErrorFromPacket(p), UnknownOperation)
+	packet.Put(p)
+
+	p = request(t, c, logout)
+	assert.Equal(t, logout, p.Metadata.Operation)
+	packet.Put(p)
+
+	p = request(t, c, metadata.PacketPing)
+	assert.ErrorIs(t, RemoteErrorFromPacket(p), UnknownOperation)
+	packet.Put(p)
+
+	err = c.Close()
+	assert.NoError(t, err)
+	err = other.Close()
+	assert.NoError(t, err)
+	err = s.Shutdown()
+	assert.NoError(t, err)
+}
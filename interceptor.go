@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// Interceptor wraps the Handler for every incoming packet, and can be used for cross-cutting concerns like logging,
+// authentication, metrics, tracing and validation. It is called with the context and the incoming packet, and must
+// call next to run the rest of the chain (and eventually the handler) unless it wants to handle the packet itself,
+// in which case it returns its own outgoing packet and action. The outgoing packet and action returned by next
+// can be inspected or replaced before they are returned.
+type Interceptor func(ctx context.Context, incoming *packet.Packet, next Handler) (outgoing *packet.Packet, action Action)
+
+// StreamOpenInterceptor is called whenever a new stream is opened by the peer, before the stream handler is called,
+// and returns the context that is passed to the stream handler. If it returns an error, the stream is closed
+// without calling the stream handler or any of the remaining StreamOpenInterceptors.
+type StreamOpenInterceptor func(ctx context.Context, stream *Stream) (context.Context, error)
+
+// StreamCloseInterceptor is called once a stream that was opened by the peer has been closed, either locally,
+// by the peer, or because the connection was closed. It is called with the context returned by the
+// StreamOpenInterceptors.
+type StreamCloseInterceptor func(ctx context.Context, stream *Stream)
+
+// interceptHandlerTable returns a copy of the HandlerTable with every handler wrapped by the interceptors,
+// or the HandlerTable itself if there are no interceptors
+func interceptHandlerTable(handlerTable HandlerTable, interceptors []Interceptor) HandlerTable {
+	if len(interceptors) == 0 {
+		return handlerTable
+	}
+	intercepted := make(HandlerTable, len(handlerTable))
+	for operation, handler := range handlerTable {
+		intercepted[operation] = intercept(handler, interceptors)
+	}
+	return intercepted
+}
+
+// intercept wraps the handler with the interceptors, with the first interceptor being the outermost one
+func intercept(handler Handler, interceptors []Interceptor) Handler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(ctx context.Context, incoming *packet.Packet) (*packet.Packet, Action) {
+			return interceptor(ctx, incoming, next)
+		}
+	}
+	return handler
+}
+
+// handleStream runs the stream handler for a new stream, calling the open interceptors before
+// it and the close interceptors once the stream has been closed
+func handleStream(ctx context.Context, stream *Stream, handler func(context.Context, *Stream), open []StreamOpenInterceptor, close []StreamCloseInterceptor) {
+	rejected := false
+	for _, interceptor := range open {
+		next, err := interceptor(ctx, stream)
+		if err != nil {
+			stream.Conn().Logger().Debug().Err(err).Msgf("stream %d rejected by interceptor", stream.ID())
+			_ = stream.Close()
+			rejected = true
+			break
+		}
+		ctx = next
+	}
+	if len(close) > 0 {
+		go func() {
+			<-stream.CloseChannel()
+			for _, interceptor := range close {
+				interceptor(ctx, stream)
+			}
+		}()
+	}
+	if !rejected {
+		handler(ctx, stream)
+	}
+}
@@ -0,0 +1,246 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+	"github.com/loopholelabs/testing/conn/pair"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+type interceptorContextKey struct{}
+
+func TestServerInterceptors(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var calls []string
+	record := func(call string) {
+		mu.Lock()
+		calls = append(calls, call)
+		mu.Unlock()
+	}
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	s, err := NewServer(make(HandlerTable), context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	s.SetInterceptors(
+		func(ctx context.Context, incoming *packet.Packet, next Handler) (outgoing *packet.Packet, action Action) {
+			record("outer")
+			outgoing, action = next(context.WithValue(ctx, interceptorContextKey{}, "outer"), incoming)
+			if outgoing != nil {
+				outgoing.Content.Write([]byte("-outer"))
+				outgoing.Metadata.ContentLength = uint32(outgoing.Content.Len())
+			}
+			return
+		},
+		func(ctx context.Context, incoming *packet.Packet, next Handler) (outgoing *packet.Packet, action Action) {
+			record("inner")
+			if incoming.Metadata.Operation == metadata.PacketProbe {
+				// Packets can be handled by the interceptor without calling the handler
+				outgoing = incoming
+				outgoing.Content.Write([]byte("rejected"))
+				outgoing.Metadata.ContentLength = uint32(outgoing.Content.Len())
+				return
+			}
+			return next(ctx, incoming)
+		},
+	)
+
+	// The interceptors are kept when the handler table is replaced
+	serverHandlerTable := make(HandlerTable)
+	serverHandlerTable[metadata.PacketPing] = func(ctx context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		record("handler")
+		assert.Equal(t, "outer", ctx.Value(interceptorContextKey{}))
+		outgoing = incoming
+		outgoing.Content.Write([]byte("handled"))
+		outgoing.Metadata.ContentLength = uint32(outgoing.Content.Len())
+		return
+	}
+	serverHandlerTable[metadata.PacketProbe] = func(_ context.Context, _ *packet.Packet) (outgoing *packet.Packet, action Action) {
+		record("probe")
+		return
+	}
+	err = s.SetHandlerTable(serverHandlerTable)
+	require.NoError(t, err)
+	// The handler table returned by GetHandlerTable does not include the interceptors
+	require.Len(t, s.GetHandlerTable(), len(serverHandlerTable))
+	for operation, handler := range serverHandlerTable {
+		assert.Equal(t, reflect.ValueOf(handler).Pointer(), reflect.ValueOf(s.GetHandlerTable()[operation]).Pointer())
+	}
+
+	s.SetConcurrency(1)
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+
+	go s.ServeConn(serverConn)
+
+	c := NewAsync(clientConn, emptyLogger)
+
+	p := packet.Get()
+	p.Metadata.Operation = metadata.PacketPing
+	err = c.WritePacket(p)
+	require.NoError(t, err)
+	p.Metadata.Operation = metadata.PacketProbe
+	err = c.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	p, err = c.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, metadata.PacketPing, p.Metadata.Operation)
+	assert.Equal(t, "handled-outer", string(p.Content.Bytes()))
+	packet.Put(p)
+
+	p, err = c.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, metadata.PacketProbe, p.Metadata.Operation)
+	assert.Equal(t, "rejected-outer", string(p.Content.Bytes()))
+	packet.Put(p)
+
+	mu.Lock()
+	assert.Equal(t, []string{"outer", "inner", "handler", "outer", "inner"}, calls)
+	mu.Unlock()
+
+	err = c.Close()
+	assert.NoError(t, err)
+	err = s.Shutdown()
+	assert.NoError(t, err)
+}
+
+func TestServerStreamInterceptors(t *testing.T) {
+	t.Parallel()
+
+	const rejectedID = 2
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	s, err := NewServer(make(HandlerTable), context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	closed := make(chan uint16, 2)
+	s.SetStreamInterceptors([]StreamOpenInterceptor{
+		func(ctx context.Context, stream *Stream) (context.Context, error) {
+			if stream.ID() == rejectedID {
+				return nil, errors.New("stream rejected")
+			}
+			return context.WithValue(ctx, interceptorContextKey{}, stream.ID()), nil
+		},
+	}, []StreamCloseInterceptor{
+		func(ctx context.Context, stream *Stream) {
+			if stream.ID() != rejectedID {
+				assert.Equal(t, stream.ID(), ctx.Value(interceptorContextKey{}))
+			}
+			closed <- stream.ID()
+		},
+	})
+
+	handled := make(chan uint16, 2)
+	err = s.SetStreamHandler(func(ctx context.Context, stream *Stream) {
+		assert.Equal(t, stream.ID(), ctx.Value(interceptorContextKey{}))
+		handled <- stream.ID()
+	})
+	require.NoError(t, err)
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+
+	go s.ServeConn(serverConn)
+
+	c := NewAsync(clientConn, emptyLogger)
+
+	p := packet.Get()
+	p.Content.Write([]byte("stream"))
+	p.Metadata.ContentLength = uint32(p.Content.Len())
+	for _, id := range []uint16{1, rejectedID} {
+		err = c.NewStream(id).WritePacket(p)
+		require.NoError(t, err)
+	}
+	packet.Put(p)
+
+	select {
+	case id := <-handled:
+		assert.Equal(t, uint16(1), id)
+	case <-time.After(DefaultDeadline):
+		t.Fatal("timed out waiting for stream handler")
+	}
+
+	// The rejected stream is closed by the server without calling the stream handler
+	select {
+	case id := <-closed:
+		assert.Equal(t, uint16(rejectedID), id)
+	case <-time.After(DefaultDeadline):
+		t.Fatal("timed out waiting for rejected stream to close")
+	}
+	assert.Empty(t, handled)
+
+	err = c.NewStream(1).Close()
+	require.NoError(t, err)
+
+	select {
+	case id := <-closed:
+		assert.Equal(t, uint16(1), id)
+	case <-time.After(DefaultDeadline):
+		t.Fatal("timed out waiting for stream to close")
+	}
+
+	err = c.Close()
+	assert.NoError(t, err)
+	err = s.Shutdown()
+	assert.NoError(t, err)
+}
+
+func TestClientInterceptors(t *testing.T) {
+	t.Parallel()
+
+	clientHandlerTable := make(HandlerTable)
+	clientHandlerTable[metadata.PacketPing] = func(ctx context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		assert.Equal(t, "intercepted", ctx.Value(interceptorContextKey{}))
+		outgoing = incoming
+		return
+	}
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	c, err := NewClient(clientHandlerTable, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	c.SetInterceptors(func(ctx context.Context, incoming *packet.Packet, next Handler) (outgoing *packet.Packet, action Action) {
+		return next(context.WithValue(ctx, interceptorContextKey{}, "intercepted"), incoming)
+	})
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+
+	err = c.FromConn(clientConn)
+	require.NoError(t, err)
+
+	server := NewAsync(serverConn, emptyLogger)
+
+	p := packet.Get()
+	p.Metadata.Id = 4
+	p.Metadata.Operation = metadata.PacketPing
+	err = server.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	p, err = server.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(4), p.Metadata.Id)
+	packet.Put(p)
+
+	_ = c.Close()
+	_ = server.Close()
+}
@@ -0,0 +1,120 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type item struct {
+	value int
+}
+
+func TestCircular(t *testing.T) {
+	t.Parallel()
+
+	t.Run("push and pop", func(t *testing.T) {
+		q := NewCircular[item, *item](7)
+		for i := 0; i < 7; i++ {
+			require.NoError(t, q.Push(&item{value: i}))
+		}
+		assert.True(t, q.IsFull())
+		assert.Equal(t, 7, q.Length())
+		for i := 0; i < 7; i++ {
+			p, err := q.Pop()
+			require.NoError(t, err)
+			assert.Equal(t, i, p.value)
+		}
+		assert.True(t, q.IsEmpty())
+	})
+
+	t.Run("try push", func(t *testing.T) {
+		q := NewCircular[item, *item](1)
+		require.NoError(t, q.TryPush(&item{value: 1}))
+		assert.ErrorIs(t, q.TryPush(&item{value: 2}), FullError)
+		p, err := q.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, 1, p.value)
+	})
+
+	t.Run("push evict", func(t *testing.T) {
+		q := NewCircular[item, *item](3)
+		for i := 0; i < 3; i++ {
+			evicted, err := q.PushEvict(&item{value: i})
+			require.NoError(t, err)
+			assert.Nil(t, evicted)
+		}
+		evicted, err := q.PushEvict(&item{value: 3})
+		require.NoError(t, err)
+		require.NotNil(t, evicted)
+		assert.Equal(t, 0, evicted.value)
+		assert.Equal(t, 3, q.Length())
+		p, err := q.Pop()
+		require.NoError(t, err)
+		assert.Equal(t, 1, p.value)
+	})
+
+	t.Run("blocking push", func(t *testing.T) {
+		q := NewCircular[item, *item](1)
+		require.NoError(t, q.Push(&item{value: 1}))
+		done := make(chan struct{})
+		go func() {
+			assert.NoError(t, q.Push(&item{value: 2}))
+			close(done)
+		}()
+		select {
+		case <-done:
+			t.Fatal("push should block while the queue is full")
+		case <-time.After(time.Millisecond * 50):
+		}
+		_, err := q.Pop()
+		require.NoError(t, err)
+		<-done
+	})
+
+	t.Run("close and drain", func(t *testing.T) {
+		q := NewCircular[item, *item](4)
+		require.NoError(t, q.Push(&item{value: 1}))
+		require.NoError(t, q.Push(&item{value: 2}))
+		q.Close()
+		assert.True(t, q.IsClosed())
+		assert.ErrorIs(t, q.Push(&item{value: 3}), Closed)
+		assert.ErrorIs(t, q.TryPush(&item{value: 3}), Closed)
+		_, err := q.PushEvict(&item{value: 3})
+		assert.ErrorIs(t, err, Closed)
+		_, err = q.Pop()
+		assert.ErrorIs(t, err, Closed)
+		values := q.Drain()
+		require.Len(t, values, 2)
+		assert.Equal(t, 1, values[0].value)
+		assert.Equal(t, 2, values[1].value)
+	})
+}
+
+func TestCircularDrainWrapped(t *testing.T) {
+	t.Parallel()
+
+	q := NewCircular[item, *item](7)
+	for i := 0; i < 7; i++ {
+		require.NoError(t, q.Push(&item{value: i}))
+	}
+	for i := 0; i < 7; i++ {
+		_, err := q.Pop()
+		require.NoError(t, err)
+	}
+
+	// The tail wraps around to before the head, and only these two elements are still in the queue
+	require.NoError(t, q.Push(&item{value: 7}))
+	require.NoError(t, q.Push(&item{value: 8}))
+	q.Close()
+
+	values := q.Drain()
+	require.Len(t, values, 2)
+	assert.Equal(t, 7, values[0].value)
+	assert.Equal(t, 8, values[1].value)
+	assert.True(t, q.IsEmpty())
+}
@@ -0,0 +1,64 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// node is a single element in an MPSC queue
+type node[T any] struct {
+	next  atomic.Pointer[node[T]]
+	value T
+}
+
+// MPSC is an unbounded, lock-free, multi-producer single-consumer FIFO queue.
+//
+// Push is safe to call from any number of goroutines concurrently, however
+// Pop must only ever be called from a single goroutine at a time.
+type MPSC[T any] struct {
+	head  atomic.Pointer[node[T]]
+	tail  *node[T]
+	nodes sync.Pool
+}
+
+// NewMPSC creates a new, empty MPSC queue.
+func NewMPSC[T any]() *MPSC[T] {
+	q := new(MPSC[T])
+	stub := new(node[T])
+	q.head.Store(stub)
+	q.tail = stub
+	return q
+}
+
+// Push adds an element to the queue.
+func (q *MPSC[T]) Push(value T) {
+	n, ok := q.nodes.Get().(*node[T])
+	if !ok {
+		n = new(node[T])
+	}
+	n.value = value
+	q.head.Swap(n).next.Store(n)
+}
+
+// Pop removes an element from the queue, returning false if the queue is empty.
+//
+// An element whose Push has not yet completed may not be visible to Pop, so callers
+// should rely on a separate signal from the producer to know when to call Pop again.
+func (q *MPSC[T]) Pop() (value T, ok bool) {
+	next := q.tail.next.Load()
+	if next == nil {
+		return
+	}
+	prev := q.tail
+	q.tail = next
+	value = next.value
+	var empty T
+	next.value = empty
+
+	// prev can safely be reused since its producer has finished linking next to it
+	prev.next.Store(nil)
+	q.nodes.Put(prev)
+	return value, true
+}
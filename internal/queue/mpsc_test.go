@@ -0,0 +1,64 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMPSC(t *testing.T) {
+	t.Parallel()
+
+	t.Run("push and pop", func(t *testing.T) {
+		q := NewMPSC[int]()
+		_, ok := q.Pop()
+		assert.False(t, ok)
+		for i := 0; i < 8; i++ {
+			q.Push(i)
+		}
+		for i := 0; i < 8; i++ {
+			v, ok := q.Pop()
+			require.True(t, ok)
+			assert.Equal(t, i, v)
+		}
+		_, ok = q.Pop()
+		assert.False(t, ok)
+	})
+
+	t.Run("concurrent producers", func(t *testing.T) {
+		const producers = 8
+		const testSize = 10000
+
+		q := NewMPSC[[2]int]()
+		var wg sync.WaitGroup
+		wg.Add(producers)
+		for i := 0; i < producers; i++ {
+			go func(producer int) {
+				defer wg.Done()
+				for j := 0; j < testSize; j++ {
+					q.Push([2]int{producer, j})
+				}
+			}(i)
+		}
+
+		next := make([]int, producers)
+		received := 0
+		for received < producers*testSize {
+			v, ok := q.Pop()
+			if !ok {
+				continue
+			}
+			require.Equal(t, next[v[0]], v[1])
+			next[v[0]]++
+			received++
+		}
+		wg.Wait()
+
+		_, ok := q.Pop()
+		assert.False(t, ok)
+	})
+}
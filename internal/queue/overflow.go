@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package queue
+
+// The rest of this package's Circular queue is vendored from github.com/loopholelabs/common/pkg/queue. The functions
+// in this file are the only additions to it, and the only local change is that Drain sizes its result with length,
+// since it used to return elements that had already been popped once the queue had wrapped around.
+
+type Pointer[T any] interface {
+	*T
+}
+
+// TryPush adds an element to the queue, returning FullError
+// instead of blocking if there is no room for it.
+func (q *Circular[T, P]) TryPush(p P) error {
+	q.lock.Lock()
+	if q.isClosed() {
+		q.lock.Unlock()
+		return Closed
+	}
+	if q.isFull() {
+		q.lock.Unlock()
+		return FullError
+	}
+
+	q.nodes[q.tail] = p
+	q.tail = (q.tail + 1) % q.maxSize
+	q.notEmpty.Signal()
+	q.lock.Unlock()
+	return nil
+}
+
+// PushEvict adds an element to the queue, removing and returning
+// the oldest element in the queue if there is no room for it.
+func (q *Circular[T, P]) PushEvict(p P) (evicted P, err error) {
+	q.lock.Lock()
+	if q.isClosed() {
+		q.lock.Unlock()
+		return nil, Closed
+	}
+	if q.isFull() {
+		evicted = q.nodes[q.head]
+		q.head = (q.head + 1) % q.maxSize
+	}
+
+	q.nodes[q.tail] = p
+	q.tail = (q.tail + 1) % q.maxSize
+	q.notEmpty.Signal()
+	q.lock.Unlock()
+	return
+}
@@ -0,0 +1,180 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"sync"
+)
+
+// limiter enforces per-operation and per-connection concurrency limits on the tasks that handle incoming packets.
+//
+// Tasks that cannot run because a limit has been reached are queued (in order) against the limit that is blocking
+// them instead of blocking the connection's read loop, so packets for other operations are not held up behind them.
+// When a task completes, the tasks queued against the limits it held are admitted and submitted to the Executor.
+type limiter struct {
+	mu                    sync.Mutex
+	operations            map[uint16]*limit
+	connectionConcurrency uint64
+	connections           map[*Async]*limit
+	queued                uint64
+}
+
+// limit tracks the number of running tasks for a single operation or connection, and the tasks waiting for it
+type limit struct {
+	max     uint64
+	running uint64
+	waiting []*limitedTask
+}
+
+type limitedTask struct {
+	conn      *Async
+	operation uint16
+	task      func()
+}
+
+func newLimiter() *limiter {
+	return &limiter{
+		operations:  make(map[uint16]*limit),
+		connections: make(map[*Async]*limit),
+	}
+}
+
+// setOperation sets the concurrency limit for the given operation, removing the limit if concurrency is 0
+func (l *limiter) setOperation(operation uint16, concurrency uint64) {
+	l.mu.Lock()
+	if concurrency == 0 {
+		delete(l.operations, operation)
+	} else if lim, ok := l.operations[operation]; ok {
+		lim.max = concurrency
+	} else {
+		l.operations[operation] = &limit{max: concurrency}
+	}
+	l.mu.Unlock()
+}
+
+// setConnection sets the concurrency limit for every connection, removing the limit if concurrency is 0
+func (l *limiter) setConnection(concurrency uint64) {
+	l.mu.Lock()
+	l.connectionConcurrency = concurrency
+	for _, lim := range l.connections {
+		lim.max = concurrency
+	}
+	l.mu.Unlock()
+}
+
+// execute submits the task to the executor once it is allowed to run by the operation and connection limits
+func (l *limiter) execute(executor Executor, conn *Async, operation uint16, task func()) {
+	t := &limitedTask{
+		conn:      conn,
+		operation: operation,
+		task:      task,
+	}
+	l.mu.Lock()
+	admitted := l.admit(t)
+	l.mu.Unlock()
+	if admitted {
+		executor.Execute(conn, l.wrap(executor, t))
+	}
+}
+
+// admit reserves the limits for the task if it is allowed to run, and otherwise queues it against
+// the limit that is blocking it. It must be called with the limiter's mutex held.
+func (l *limiter) admit(t *limitedTask) bool {
+	operationLimit := l.operations[t.operation]
+	if operationLimit != nil && operationLimit.running >= operationLimit.max {
+		operationLimit.waiting = append(operationLimit.waiting, t)
+		l.queued++
+		return false
+	}
+	var connectionLimit *limit
+	if l.connectionConcurrency > 0 {
+		connectionLimit = l.connections[t.conn]
+		if connectionLimit == nil {
+			connectionLimit = &limit{max: l.connectionConcurrency}
+			l.connections[t.conn] = connectionLimit
+		}
+		if connectionLimit.running >= connectionLimit.max {
+			connectionLimit.waiting = append(connectionLimit.waiting, t)
+			l.queued++
+			return false
+		}
+	}
+	if operationLimit != nil {
+		operationLimit.running++
+	}
+	if connectionLimit != nil {
+		connectionLimit.running++
+	}
+	return true
+}
+
+// wrap returns a function that runs the task and then releases its limits
+func (l *limiter) wrap(executor Executor, t *limitedTask) func() {
+	return func() {
+		t.task()
+		l.release(executor, t)
+	}
+}
+
+// release frees the limits held by the completed task, and submits any queued tasks that are now allowed to run
+func (l *limiter) release(executor Executor, t *limitedTask) {
+	var ready []*limitedTask
+	l.mu.Lock()
+	for _, lim := range [2]*limit{l.operations[t.operation], l.connections[t.conn]} {
+		if lim == nil {
+			continue
+		}
+		if lim.running > 0 {
+			lim.running--
+		}
+		for len(lim.waiting) > 0 && lim.running < lim.max {
+			next := lim.waiting[0]
+			lim.waiting[0] = nil
+			lim.waiting = lim.waiting[1:]
+			l.queued--
+			if l.admit(next) {
+				ready = append(ready, next)
+			}
+		}
+	}
+	l.mu.Unlock()
+	for _, next := range ready {
+		// Queued tasks are submitted from a new goroutine, since the executor may block and the
+		// completed task may be running on one of the executor's own goroutines
+		go executor.Execute(next.conn, l.wrap(executor, next))
+	}
+}
+
+// removeConnection removes the connection's limit once all of its tasks have completed
+func (l *limiter) removeConnection(conn *Async) {
+	l.mu.Lock()
+	delete(l.connections, conn)
+	l.mu.Unlock()
+}
+
+// queuedTotal returns the total number of tasks waiting for a limit
+func (l *limiter) queuedTotal() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.queued
+}
+
+// queuedOperation returns the number of tasks waiting for the given operation's limit
+func (l *limiter) queuedOperation(operation uint16) uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if lim := l.operations[operation]; lim != nil {
+		return uint64(len(lim.waiting))
+	}
+	return 0
+}
+
+// queuedConnection returns the number of tasks waiting for the given connection's limit
+func (l *limiter) queuedConnection(conn *Async) uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if lim := l.connections[conn]; lim != nil {
+		return uint64(len(lim.waiting))
+	}
+	return 0
+}
@@ -0,0 +1,199 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+	"github.com/loopholelabs/testing/conn/pair"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+func TestLimiter(t *testing.T) {
+	t.Parallel()
+
+	const testSize = 100
+
+	l := newLimiter()
+	l.setOperation(metadata.PacketPing, 2)
+	l.setConnection(3)
+
+	conns := []*Async{new(Async), new(Async)}
+	var running, maxRunning atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(testSize * len(conns))
+	executor := NewGoroutineExecutor(0)
+	for i := 0; i < testSize; i++ {
+		for _, conn := range conns {
+			operation := metadata.PacketPing
+			if i%2 == 0 {
+				operation = metadata.PacketPong
+			}
+			l.execute(executor, conn, operation, func() {
+				if operation == metadata.PacketPing {
+					n := running.Add(1)
+					for m := maxRunning.Load(); n > m && !maxRunning.CompareAndSwap(m, n); m = maxRunning.Load() {
+					}
+					time.Sleep(time.Millisecond)
+					running.Add(-1)
+				}
+				wg.Done()
+			})
+		}
+	}
+	wg.Wait()
+
+	assert.LessOrEqual(t, maxRunning.Load(), int64(2))
+	require.Eventually(t, func() bool {
+		return l.queuedTotal() == 0
+	}, DefaultDeadline, time.Millisecond*10)
+	for _, conn := range conns {
+		l.removeConnection(conn)
+	}
+	assert.Empty(t, l.connections)
+}
+
+func TestServerOperationConcurrency(t *testing.T) {
+	t.Parallel()
+
+	const slowSize = 5
+
+	release := make(chan struct{})
+	var slowRunning atomic.Int64
+	serverHandlerTable := make(HandlerTable)
+	serverHandlerTable[metadata.PacketProbe] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		slowRunning.Add(1)
+		<-release
+		outgoing = incoming
+		return
+	}
+	serverHandlerTable[metadata.PacketPing] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		outgoing = incoming
+		return
+	}
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	s, err := NewServer(serverHandlerTable, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	s.SetConcurrency(4)
+	s.SetOperationConcurrency(metadata.PacketProbe, 1)
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+
+	go s.ServeConn(serverConn)
+
+	c := NewAsync(clientConn, emptyLogger)
+
+	p := packet.Get()
+	p.Metadata.Operation = metadata.PacketProbe
+	for i := 0; i < slowSize; i++ {
+		p.Metadata.Id = uint16(i)
+		err = c.WritePacket(p)
+		require.NoError(t, err)
+	}
+
+	require.Eventually(t, func() bool {
+		return s.QueuedOperation(metadata.PacketProbe) == slowSize-1
+	}, DefaultDeadline, time.Millisecond*10)
+	assert.Equal(t, uint64(slowSize-1), s.Queued())
+	assert.Equal(t, int64(1), slowRunning.Load())
+
+	// The fast operation is not held up behind the queued slow operations
+	p.Metadata.Operation = metadata.PacketPing
+	p.Metadata.Id = slowSize
+	err = c.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	p, err = c.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, metadata.PacketPing, p.Metadata.Operation)
+	packet.Put(p)
+
+	close(release)
+	for i := 0; i < slowSize; i++ {
+		p, err = c.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, metadata.PacketProbe, p.Metadata.Operation)
+		packet.Put(p)
+	}
+	assert.Equal(t, uint64(0), s.Queued())
+
+	err = c.Close()
+	assert.NoError(t, err)
+	err = s.Shutdown()
+	assert.NoError(t, err)
+}
+
+func TestServerConnectionConcurrency(t *testing.T) {
+	t.Parallel()
+
+	const testSize = 10
+	const concurrency = 2
+
+	release := make(chan struct{})
+	serverHandlerTable := make(HandlerTable)
+	serverHandlerTable[metadata.PacketPing] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		<-release
+		outgoing = incoming
+		return
+	}
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	s, err := NewServer(serverHandlerTable, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	s.SetConnectionConcurrency(concurrency)
+
+	var serverAsync atomic.Pointer[Async]
+	s.ConnContext = func(ctx context.Context, c *Async) context.Context {
+		serverAsync.Store(c)
+		return ctx
+	}
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+
+	go s.ServeConn(serverConn)
+
+	c := NewAsync(clientConn, emptyLogger)
+
+	p := packet.Get()
+	p.Metadata.Operation = metadata.PacketPing
+	for i := 0; i < testSize; i++ {
+		p.Metadata.Id = uint16(i)
+		err = c.WritePacket(p)
+		require.NoError(t, err)
+	}
+	packet.Put(p)
+
+	require.Eventually(t, func() bool {
+		conn := serverAsync.Load()
+		return conn != nil && s.QueuedConnection(conn) == testSize-concurrency
+	}, DefaultDeadline, time.Millisecond*10)
+
+	close(release)
+	for i := 0; i < testSize; i++ {
+		p, err = c.ReadPacket()
+		require.NoError(t, err)
+		packet.Put(p)
+	}
+	assert.Equal(t, uint64(0), s.Queued())
+
+	err = c.Close()
+	assert.NoError(t, err)
+	err = s.Shutdown()
+	assert.NoError(t, err)
+}
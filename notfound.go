@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/loopholelabs/logging/types"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// notFound handles the packets received by a Server or Client whose operation has no handler, and counts them
+// by operation. Packets with a reserved operation are dropped, since responding to them could cause a loop
+// of ERROR packets between peers. For other operations the NotFound handler is called, or if there is no
+// NotFound handler, the packet is dropped unless errors is true, in which case an ERROR packet with the
+// ErrorUnknownOperation code is sent in place of a response.
+type notFound struct {
+	handler Handler
+	errors  bool
+	mu      sync.Mutex
+	counts  map[uint16]uint64
+}
+
+func newNotFound() *notFound {
+	return &notFound{
+		counts: make(map[uint16]uint64),
+	}
+}
+
+// count counts a packet with the given operation
+func (n *notFound) count(operation uint16) {
+	n.mu.Lock()
+	n.counts[operation]++
+	n.mu.Unlock()
+}
+
+// totals returns a copy of the number of packets that have been received for each unknown operation
+func (n *notFound) totals() map[uint16]uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	totals := make(map[uint16]uint64, len(n.counts))
+	for operation, count := range n.counts {
+		totals[operation] = count
+	}
+	return totals
+}
+
+// lookup counts a packet whose operation has no handler, and returns the NotFound handler that should be called for
+// it. If there is no NotFound handler, the ERROR packet that should be sent in place of a response is returned
+// instead (if errors is true), and if both are nil the packet should be dropped.
+func (n *notFound) lookup(logger types.Logger, p *packet.Packet) (Handler, *packet.Packet) {
+	n.count(p.Metadata.Operation)
+	if p.Metadata.Operation <= RESERVED9 {
+		logger.Debug().Uint16("id", p.Metadata.Id).Uint16("operation", p.Metadata.Operation).Msg("dropping packet with unexpected reserved operation")
+		return nil, nil
+	}
+	logger.Debug().Uint16("id", p.Metadata.Id).Uint16("operation", p.Metadata.Operation).Msg("no handler for operation")
+	if n.handler != nil {
+		return n.handler, nil
+	}
+	if n.errors {
+		return nil, unknownOperationPacket(p)
+	}
+	return nil, nil
+}
+
+// unknownOperationPacket returns an ERROR packet with the ErrorUnknownOperation code for the given packet
+func unknownOperationPacket(p *packet.Packet) *packet.Packet {
+	return errorPacket(p.Metadata.Id, ErrorUnknownOperation, fmt.Sprintf("%s %d", UnknownOperation, p.Metadata.Operation))
+}
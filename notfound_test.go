@@ -0,0 +1,213 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+	"github.com/loopholelabs/testing/conn/pair"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+const unknownOperation = uint16(64)
+
+func TestServerNotFound(t *testing.T) {
+	t.Parallel()
+
+	newServer := func(t *testing.T) *Server {
+		serverHandlerTable := make(HandlerTable)
+		serverHandlerTable[metadata.PacketPing] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+			outgoing = incoming
+			return
+		}
+
+		emptyLogger := logging.Test(t, logging.Noop, t.Name())
+		s, err := NewServer(serverHandlerTable, context.Background(), WithLogger(emptyLogger))
+		require.NoError(t, err)
+
+		// Packets are handled in order so that the reserved packet is dropped before the ping is handled
+		s.SetConcurrency(1)
+		return s
+	}
+
+	t.Run("default", func(t *testing.T) {
+		t.Parallel()
+
+		s := newServer(t)
+
+		serverConn, clientConn, err := pair.New()
+		require.NoError(t, err)
+
+		go s.ServeConn(serverConn)
+
+		emptyLogger := logging.Test(t, logging.Noop, t.Name())
+		c := NewAsync(clientConn, emptyLogger)
+
+		// Packets with an unknown operation are dropped without a response
+		p := packet.Get()
+		p.Metadata.Id = 8
+		p.Metadata.Operation = unknownOperation
+		err = c.WritePacket(p)
+		require.NoError(t, err)
+
+		p.Metadata.Id = 9
+		p.Metadata.Operation = metadata.PacketPing
+		err = c.WritePacket(p)
+		require.NoError(t, err)
+		packet.Put(p)
+
+		p, err = c.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, uint16(9), p.Metadata.Id)
+		assert.Equal(t, metadata.PacketPing, p.Metadata.Operation)
+		packet.Put(p)
+
+		assert.Equal(t, map[uint16]uint64{unknownOperation: 1}, s.UnknownOperations())
+
+		err = c.Close()
+		assert.NoError(t, err)
+		err = s.Shutdown()
+		assert.NoError(t, err)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		t.Parallel()
+
+		s := newServer(t)
+		s.SetUnknownOperationErrors(true)
+
+		serverConn, clientConn, err := pair.New()
+		require.NoError(t, err)
+
+		go s.ServeConn(serverConn)
+
+		emptyLogger := logging.Test(t, logging.Noop, t.Name())
+		c := NewAsync(clientConn, emptyLogger)
+
+		p := packet.Get()
+		p.Metadata.Id = 8
+		p.Metadata.Operation = unknownOperation
+		err = c.WritePacket(p)
+		require.NoError(t, err)
+
+		// Packets with a reserved operation are dropped without a response
+		p.Metadata.Operation = RESERVED9
+		err = c.writePacket(p)
+		require.NoError(t, err)
+
+		p.Metadata.Operation = metadata.PacketPing
+		err = c.WritePacket(p)
+		require.NoError(t, err)
+		packet.Put(p)
+
+		p, err = c.ReadPacket()
+		require.NoError(t, err)
+		remoteErr := RemoteErrorFromPacket(p)
+		require.NotNil(t, remoteErr)
+		assert.Equal(t, uint16(8), remoteErr.Id)
+		assert.Equal(t, ErrorUnknownOperation, remoteErr.Code)
+		assert.ErrorIs(t, remoteErr, UnknownOperation)
+		assert.Equal(t, "unknown operation 64", remoteErr.Message)
+		packet.Put(p)
+
+		p, err = c.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, metadata.PacketPing, p.Metadata.Operation)
+		packet.Put(p)
+
+		assert.Equal(t, map[uint16]uint64{unknownOperation: 1, RESERVED9: 1}, s.UnknownOperations())
+
+		err = c.Close()
+		assert.NoError(t, err)
+		err = s.Shutdown()
+		assert.NoError(t, err)
+	})
+
+	t.Run("handler", func(t *testing.T) {
+		t.Parallel()
+
+		s := newServer(t)
+		s.SetNotFoundHandler(func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+			outgoing = incoming
+			outgoing.Content.Write([]byte("not found"))
+			outgoing.Metadata.ContentLength = uint32(outgoing.Content.Len())
+			return
+		})
+
+		serverConn, clientConn, err := pair.New()
+		require.NoError(t, err)
+
+		go s.ServeConn(serverConn)
+
+		emptyLogger := logging.Test(t, logging.Noop, t.Name())
+		c := NewAsync(clientConn, emptyLogger)
+
+		p := packet.Get()
+		p.Metadata.Id = 8
+		p.Metadata.Operation = unknownOperation
+		err = c.WritePacket(p)
+		require.NoError(t, err)
+		packet.Put(p)
+
+		p, err = c.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, uint16(8), p.Metadata.Id)
+		assert.Equal(t, unknownOperation, p.Metadata.Operation)
+		assert.Equal(t, "not found", string(p.Content.Bytes()))
+		packet.Put(p)
+
+		assert.Equal(t, map[uint16]uint64{unknownOperation: 1}, s.UnknownOperations())
+
+		err = c.Close()
+		assert.NoError(t, err)
+		err = s.Shutdown()
+		assert.NoError(t, err)
+	})
+}
+
+func TestClientNotFound(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	c, err := NewClient(make(HandlerTable), context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+	c.SetUnknownOperationErrors(true)
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+
+	err = c.FromConn(clientConn)
+	require.NoError(t, err)
+
+	server := NewAsync(serverConn, emptyLogger)
+
+	p := packet.Get()
+	p.Metadata.Id = 16
+	p.Metadata.Operation = unknownOperation
+	err = server.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	p, err = server.ReadPacket()
+	require.NoError(t, err)
+	remoteErr := RemoteErrorFromPacket(p)
+	require.NotNil(t, remoteErr)
+	assert.Equal(t, uint16(16), remoteErr.Id)
+	assert.ErrorIs(t, remoteErr, UnknownOperation)
+	packet.Put(p)
+
+	require.Eventually(t, func() bool {
+		return c.UnknownOperations()[unknownOperation] == 1
+	}, DefaultDeadline, time.Millisecond*10)
+
+	_ = c.Close()
+	_ = server.Close()
+}
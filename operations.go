@@ -0,0 +1,243 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/loopholelabs/polyglot/v2"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+var (
+	InvalidOperationName        = errors.New("operation name cannot be empty")
+	OperationNamesExhausted     = errors.New("no operations are left for named handlers")
+	OperationNameMismatch       = errors.New("named operation is not handled by the peer")
+	OperationNameUnknown        = errors.New("named operation was not negotiated with the peer")
+	OperationNegotiationTimeout = errors.New("timed out while negotiating named operations with the peer")
+	OperationsUnsupported       = errors.New("peer does not support named operations")
+)
+
+// DefaultOperationNegotiationTimeout is the default time that a Client waits for the server to respond with the
+// operations for its operation names (see Client.SetOperationNegotiationTimeout). Servers that support named
+// operations respond as soon as they have read the request, so it is much shorter than the DefaultDeadline.
+var DefaultOperationNegotiationTimeout = time.Second
+
+// NamedOperationStart is the first operation that is used for the handlers in a NamedHandlerTable. Operations
+// from NamedOperationStart onwards are numbered by the Server, so a HandlerTable cannot use them once the
+// Server has a NamedHandlerTable.
+const NamedOperationStart = uint16(0x8000)
+
+// NamedHandlerTable maps operation names (for example "users.Get") to their handlers. The Server numbers the
+// named operations itself, and Clients learn the numbers while connecting (see Client.SetOperationNames), so
+// both sides only have to agree on the names while packets are still sent with compact numeric operations.
+type NamedHandlerTable map[string]Handler
+
+// namedHandlers is a validated copy of a NamedHandlerTable along with the operations that were assigned to its names
+type namedHandlers struct {
+	table      NamedHandlerTable
+	operations map[string]uint16
+	handlers   HandlerTable
+}
+
+// operationNumbers assigns operations to the names in the NamedHandlerTables of a Server. Names keep their operation
+// for the lifetime of the Server, even once they are removed from its NamedHandlerTable, so that a client that
+// negotiated an operation before the NamedHandlerTable was replaced can never reach a different handler with it.
+type operationNumbers struct {
+	mu      sync.Mutex
+	numbers map[string]uint16
+	next    uint32
+}
+
+func newOperationNumbers() *operationNumbers {
+	return &operationNumbers{
+		numbers: make(map[string]uint16),
+		next:    uint32(NamedOperationStart),
+	}
+}
+
+// assign returns the namedHandlers for the NamedHandlerTable, assigning the next unused operations
+// (in the order of their names) to names that have never been assigned an operation before
+func (o *operationNumbers) assign(namedHandlerTable NamedHandlerTable) (*namedHandlers, error) {
+	names := make([]string, 0, len(namedHandlerTable))
+	for name := range namedHandlerTable {
+		if name == "" {
+			return nil, InvalidOperationName
+		}
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var unassigned uint32
+	for _, name := range names {
+		if _, ok := o.numbers[name]; !ok {
+			unassigned++
+		}
+	}
+	if o.next+unassigned > math.MaxUint16+1 {
+		return nil, OperationNamesExhausted
+	}
+
+	named := &namedHandlers{
+		table:      maps.Clone(namedHandlerTable),
+		operations: make(map[string]uint16, len(names)),
+		handlers:   make(HandlerTable, len(names)),
+	}
+	for _, name := range names {
+		operation, ok := o.numbers[name]
+		if !ok {
+			operation = uint16(o.next)
+			o.numbers[name] = operation
+			o.next++
+		}
+		named.operations[name] = operation
+		named.handlers[operation] = namedHandlerTable[name]
+	}
+	return named, nil
+}
+
+// operationsRequest returns the OPERATIONS packet that asks the peer for the operations of the given names
+func operationsRequest(names []string) *packet.Packet {
+	p := packet.Get()
+	p.Metadata.Operation = OPERATIONS
+	e := polyglot.Encoder(p.Content).Slice(uint32(len(names)), polyglot.StringKind)
+	for _, name := range names {
+		e.String(name)
+	}
+	p.Metadata.ContentLength = uint32(p.Content.Len())
+	return p
+}
+
+// operationsResponse returns the OPERATIONS packet that responds to the given OPERATIONS packet with the operation
+// for each of the requested names that is in operations, or an error if the request cannot be decoded
+func operationsResponse(request *packet.Packet, operations map[string]uint16) (*packet.Packet, error) {
+	d := polyglot.Decoder(request.Content.Bytes())
+	size, err := d.Slice(polyglot.StringKind)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]uint16)
+	for i := uint32(0); i < size; i++ {
+		name, err := d.String()
+		if err != nil {
+			return nil, err
+		}
+		if operation, ok := operations[name]; ok {
+			found[name] = operation
+		}
+	}
+
+	p := packet.Get()
+	p.Metadata.Id = request.Metadata.Id
+	p.Metadata.Operation = OPERATIONS
+	e := polyglot.Encoder(p.Content).Map(uint32(len(found)), polyglot.StringKind, polyglot.Uint16Kind)
+	for name, operation := range found {
+		e.String(name).Uint16(operation)
+	}
+	p.Metadata.ContentLength = uint32(p.Content.Len())
+	return p, nil
+}
+
+// decodeOperations decodes the operations from an OPERATIONS packet that was sent by operationsResponse
+func decodeOperations(p *packet.Packet) (map[string]uint16, error) {
+	d := polyglot.Decoder(p.Content.Bytes())
+	size, err := d.Map(polyglot.StringKind, polyglot.Uint16Kind)
+	if err != nil {
+		return nil, err
+	}
+	// The size is sent by the peer, so the map is never preallocated for more entries than the content could hold
+	operations := make(map[string]uint16, min(size, uint32(p.Content.Len())))
+	for i := uint32(0); i < size; i++ {
+		name, err := d.String()
+		if err != nil {
+			return nil, err
+		}
+		operations[name], err = d.Uint16()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return operations, nil
+}
+
+// negotiateOperations sends the given names to the peer and waits (for up to the timeout) for their operations,
+// which must be called before anything else reads from the connection. Any other packets that are received in the
+// meantime are returned along with the operations, so that they can be handled once the negotiation has finished
+// (up to the BufferSize of the connection, after which QueueFull is returned).
+//
+// OperationNameMismatch is returned if the peer does not handle all of the names, and an error wrapping both
+// OperationsUnsupported and OperationNegotiationTimeout is returned (and the connection is closed) if the peer
+// does not respond in time, since peers that are older than named operations never respond.
+func negotiateOperations(conn *Async, names []string, timeout time.Duration) (operations map[string]uint16, pending []*packet.Packet, err error) {
+	defer func() {
+		if err != nil {
+			for _, p := range pending {
+				packet.Put(p)
+			}
+			pending = nil
+		}
+	}()
+
+	request := operationsRequest(names)
+	err = conn.writePacket(request)
+	packet.Put(request)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var timedOut atomic.Bool
+	timer := time.AfterFunc(timeout, func() {
+		timedOut.Store(true)
+		_ = conn.Close()
+	})
+	for {
+		p, err := conn.ReadPacket()
+		if err != nil {
+			timer.Stop()
+			if timedOut.Load() {
+				return nil, pending, fmt.Errorf("%w: %w", OperationsUnsupported, OperationNegotiationTimeout)
+			}
+			return nil, pending, err
+		}
+		if p.Metadata.Operation != OPERATIONS {
+			if len(pending) >= conn.options.BufferSize {
+				timer.Stop()
+				packet.Put(p)
+				return nil, pending, QueueFull
+			}
+			pending = append(pending, p)
+			continue
+		}
+		if !timer.Stop() {
+			packet.Put(p)
+			return nil, pending, fmt.Errorf("%w: %w", OperationsUnsupported, OperationNegotiationTimeout)
+		}
+		operations, err = decodeOperations(p)
+		packet.Put(p)
+		if err != nil {
+			return nil, pending, err
+		}
+
+		var missing []string
+		for _, name := range names {
+			if _, ok := operations[name]; !ok {
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) > 0 {
+			return nil, pending, fmt.Errorf("%w: %s", OperationNameMismatch, strings.Join(missing, ", "))
+		}
+		return operations, pending, nil
+	}
+}
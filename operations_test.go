@@ -0,0 +1,240 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+	"github.com/loopholelabs/polyglot/v2"
+	"github.com/loopholelabs/testing/conn/pair"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+func TestNamedOperations(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	s, err := NewServer(make(HandlerTable), context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	err = s.SetNamedHandlerTable(NamedHandlerTable{
+		"users.Get":  versionHandler("get", nil, nil),
+		"users.List": versionHandler("list", nil, nil),
+	})
+	require.NoError(t, err)
+
+	newClient := func(t *testing.T, names ...string) (*Client, error) {
+		c, err := NewClient(make(HandlerTable), context.Background(), WithLogger(emptyLogger))
+		require.NoError(t, err)
+		c.SetOperationNames(names...)
+
+		serverConn, clientConn, err := pair.New()
+		require.NoError(t, err)
+
+		go s.ServeConn(serverConn)
+		return c, c.FromConn(clientConn)
+	}
+
+	t.Run("negotiated", func(t *testing.T) {
+		c, err := newClient(t, "users.Get", "users.List")
+		require.NoError(t, err)
+
+		responses := make(chan string, 1)
+		clientHandlerTable := make(HandlerTable)
+		for _, name := range []string{"users.Get", "users.List"} {
+			operation, err := c.Operation(name)
+			require.NoError(t, err)
+			assert.Equal(t, s.Operations()[name], operation)
+			clientHandlerTable[operation] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+				responses <- string(incoming.Content.Bytes())
+				return
+			}
+		}
+		err = c.SetHandlerTable(clientHandlerTable)
+		require.NoError(t, err)
+
+		_, err = c.Operation("users.Delete")
+		assert.ErrorIs(t, err, OperationNameUnknown)
+
+		for name, response := range map[string]string{"users.Get": "get", "users.List": "list"} {
+			operation, err := c.Operation(name)
+			require.NoError(t, err)
+
+			p := packet.Get()
+			p.Metadata.Operation = operation
+			err = c.WritePacket(p)
+			require.NoError(t, err)
+			packet.Put(p)
+
+			assert.Equal(t, response, <-responses)
+		}
+
+		err = c.Close()
+		assert.NoError(t, err)
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		c, err := newClient(t, "users.Get", "users.Delete")
+		assert.ErrorIs(t, err, OperationNameMismatch)
+		assert.ErrorContains(t, err, "users.Delete")
+		assert.NotContains(t, err.Error(), "users.Get")
+		assert.True(t, c.conn.Closed())
+	})
+
+	err = s.Shutdown()
+	assert.NoError(t, err)
+}
+
+func TestServerSetNamedHandlerTable(t *testing.T) {
+	t.Parallel()
+
+	handler := versionHandler("", nil, nil)
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	s, err := NewServer(HandlerTable{NamedOperationStart: handler}, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	// Named operations cannot be used while the handler table uses their operations
+	err = s.SetNamedHandlerTable(NamedHandlerTable{"users.Get": handler})
+	assert.ErrorIs(t, err, InvalidHandlerTable)
+	assert.Empty(t, s.Operations())
+
+	err = s.SetHandlerTable(HandlerTable{metadata.PacketPing: handler})
+	require.NoError(t, err)
+
+	err = s.SetNamedHandlerTable(NamedHandlerTable{"": handler})
+	assert.ErrorIs(t, err, InvalidOperationName)
+
+	err = s.SetNamedHandlerTable(NamedHandlerTable{"users.Get": handler, "users.List": handler})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]uint16{"users.Get": NamedOperationStart, "users.List": NamedOperationStart + 1}, s.Operations())
+
+	err = s.SetHandlerTable(HandlerTable{NamedOperationStart + 2: handler})
+	assert.ErrorIs(t, err, InvalidHandlerTable)
+
+	// The handler table can be replaced without removing the named handlers
+	err = s.SetHandlerTable(HandlerTable{metadata.PacketProbe: handler})
+	require.NoError(t, err)
+	assert.Len(t, s.Operations(), 2)
+
+	// Names keep their operations, and the operations of removed names are not reused
+	err = s.SetNamedHandlerTable(NamedHandlerTable{"users.Create": handler, "users.List": handler})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]uint16{"users.Create": NamedOperationStart + 2, "users.List": NamedOperationStart + 1}, s.Operations())
+
+	err = s.SetNamedHandlerTable(NamedHandlerTable{"users.Get": handler})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]uint16{"users.Get": NamedOperationStart}, s.Operations())
+
+	err = s.SetNamedHandlerTable(nil)
+	require.NoError(t, err)
+	assert.Empty(t, s.Operations())
+	assert.Contains(t, s.GetHandlerTable(), metadata.PacketProbe)
+
+	err = s.Shutdown()
+	assert.NoError(t, err)
+}
+
+func TestNamedOperationsTimeout(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	c, err := NewClient(make(HandlerTable), context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+	c.SetOperationNames("users.Get")
+	c.SetOperationNegotiationTimeout(time.Millisecond * 100)
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+
+	// The peer does not handle OPERATIONS packets, so it never responds
+	server := NewAsync(serverConn, emptyLogger)
+
+	started := time.Now()
+	err = c.FromConn(clientConn)
+	assert.ErrorIs(t, err, OperationsUnsupported)
+	assert.ErrorIs(t, err, OperationNegotiationTimeout)
+	assert.Less(t, time.Since(started), DefaultDeadline)
+
+	_ = server.Close()
+}
+
+func TestNamedOperationsPending(t *testing.T) {
+	t.Parallel()
+
+	pings := make(chan uint16, 1)
+	clientHandlerTable := make(HandlerTable)
+	clientHandlerTable[metadata.PacketPing] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		pings <- incoming.Metadata.Id
+		return
+	}
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	c, err := NewClient(clientHandlerTable, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+	c.SetOperationNames("users.Get")
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+
+	// The peer sends a packet before responding to the OPERATIONS packet, which is handled once the client has connected
+	server := NewAsync(serverConn, emptyLogger)
+	go func() {
+		request, err := server.ReadPacket()
+		if !assert.NoError(t, err) {
+			return
+		}
+		p := packet.Get()
+		p.Metadata.Id = 1
+		p.Metadata.Operation = metadata.PacketPing
+		assert.NoError(t, server.WritePacket(p))
+		packet.Put(p)
+
+		response, err := operationsResponse(request, map[string]uint16{"users.Get": NamedOperationStart})
+		packet.Put(request)
+		if assert.NoError(t, err) {
+			assert.NoError(t, server.writePacket(response))
+			packet.Put(response)
+		}
+	}()
+
+	err = c.FromConn(clientConn)
+	require.NoError(t, err)
+
+	operation, err := c.Operation("users.Get")
+	require.NoError(t, err)
+	assert.Equal(t, NamedOperationStart, operation)
+
+	select {
+	case id := <-pings:
+		assert.Equal(t, uint16(1), id)
+	case <-time.After(DefaultDeadline):
+		t.Fatal("timed out waiting for ping")
+	}
+
+	_ = c.Close()
+	_ = server.Close()
+}
+
+func TestDecodeOperationsSize(t *testing.T) {
+	t.Parallel()
+
+	// The number of operations is sent by the peer, and must not decide how much memory is allocated
+	p := packet.Get()
+	p.Metadata.Operation = OPERATIONS
+	polyglot.Encoder(p.Content).Map(math.MaxUint32, polyglot.StringKind, polyglot.Uint16Kind)
+	p.Metadata.ContentLength = uint32(p.Content.Len())
+
+	_, err := decodeOperations(p)
+	assert.Error(t, err)
+	packet.Put(p)
+}
@@ -13,6 +13,29 @@ import (
 // Option is used to generate frisbee client and server options internally
 type Option func(opts *Options)
 
+// OverflowPolicy is an ENUM used to decide what happens to incoming packets when a packet queue is full
+//
+//	OverflowBlock: block the read loop until there is room in the queue (default)
+//	OverflowDropNewest: drop the packet that was just received
+//	OverflowDropOldest: drop the oldest packet in the queue to make room for the packet that was just received
+//	OverflowClose: close the frisbee connection with the QueueFull error
+type OverflowPolicy int
+
+// These are the various overflow policies that can be used for the incoming and stream packet queues:
+const (
+	// OverflowBlock blocks the read loop until there is room in the queue, which applies TCP backpressure to the peer (default)
+	OverflowBlock = OverflowPolicy(iota)
+
+	// OverflowDropNewest drops the packet that was just received
+	OverflowDropNewest
+
+	// OverflowDropOldest drops the oldest packet in the queue to make room for the packet that was just received
+	OverflowDropOldest
+
+	// OverflowClose closes the frisbee connection with the QueueFull error
+	OverflowClose
+)
+
 // Options is used to provide the frisbee client and server with configuration options.
 //
 // Default Values:
@@ -20,11 +43,29 @@ type Option func(opts *Options)
 //	options := Options {
 //		KeepAlive: time.Minute * 3,
 //		Logger: &DefaultLogger,
+//		Deadline: DefaultDeadline,
+//		PingInterval: DefaultPingInterval,
+//		BufferSize: DefaultBufferSize,
+//		StreamBufferSize: DefaultStreamBufferSize,
+//		DirectReadThreshold: BufferSize,
+//		DirectWriteThreshold: BufferSize,
+//		MaxContentLength: 0,
+//		IncomingOverflow: OverflowBlock,
+//		StreamOverflow: OverflowBlock,
 //	}
 type Options struct {
-	KeepAlive time.Duration
-	Logger    types.Logger
-	TLSConfig *tls.Config
+	KeepAlive            time.Duration
+	Logger               types.Logger
+	TLSConfig            *tls.Config
+	Deadline             time.Duration
+	PingInterval         time.Duration
+	BufferSize           int
+	StreamBufferSize     int
+	DirectReadThreshold  int
+	DirectWriteThreshold int
+	MaxContentLength     int
+	IncomingOverflow     OverflowPolicy
+	StreamOverflow       OverflowPolicy
 }
 
 func loadOptions(options ...Option) *Options {
@@ -41,6 +82,39 @@ func loadOptions(options ...Option) *Options {
 		opts.KeepAlive = time.Minute * 3
 	}
 
+	if opts.Deadline == 0 {
+		opts.Deadline = DefaultDeadline
+	}
+
+	if opts.PingInterval == 0 {
+		opts.PingInterval = DefaultPingInterval
+	}
+
+	if opts.BufferSize < 0 {
+		opts.Logger.Warn().Int("bufferSize", opts.BufferSize).Msg("negative buffer size, using the default buffer size")
+		opts.BufferSize = DefaultBufferSize
+	} else if opts.BufferSize == 0 {
+		opts.BufferSize = DefaultBufferSize
+	} else if opts.BufferSize < MinBufferSize {
+		opts.Logger.Warn().Int("bufferSize", opts.BufferSize).Msgf("buffer size is too small, using the minimum buffer size of %d", MinBufferSize)
+		opts.BufferSize = MinBufferSize
+	}
+
+	if opts.StreamBufferSize < 0 {
+		opts.Logger.Warn().Int("streamBufferSize", opts.StreamBufferSize).Msg("negative stream buffer size, using the default stream buffer size")
+		opts.StreamBufferSize = DefaultStreamBufferSize
+	} else if opts.StreamBufferSize == 0 {
+		opts.StreamBufferSize = DefaultStreamBufferSize
+	}
+
+	if opts.DirectReadThreshold == 0 {
+		opts.DirectReadThreshold = opts.BufferSize
+	}
+
+	if opts.DirectWriteThreshold == 0 {
+		opts.DirectWriteThreshold = opts.BufferSize
+	}
+
 	return opts
 }
 
@@ -73,3 +147,87 @@ func WithTLS(tlsConfig *tls.Config) Option {
 		opts.TLSConfig = tlsConfig
 	}
 }
+
+// WithDeadline sets the read and write deadline used by each frisbee connection
+func WithDeadline(deadline time.Duration) Option {
+	return func(opts *Options) {
+		opts.Deadline = deadline
+	}
+}
+
+// WithPingInterval sets how often each frisbee connection sends a PING packet to its peer (use -1 to disable)
+//
+// When pinging is disabled, the Deadline must be long enough to cover the expected idle time of the
+// connection, otherwise the read loop will time out and close the connection.
+func WithPingInterval(pingInterval time.Duration) Option {
+	return func(opts *Options) {
+		opts.PingInterval = pingInterval
+	}
+}
+
+// WithBufferSize sets the BufferSize for each frisbee connection, which is used for three things:
+//
+//   - the size of the read buffer in bytes
+//   - the number of packets that can be queued for reading (see WithIncomingOverflow)
+//   - the number of bytes that the write loop batches into a single write
+//
+// Negative values are replaced with DefaultBufferSize, and values smaller than MinBufferSize are raised to MinBufferSize.
+func WithBufferSize(bufferSize int) Option {
+	return func(opts *Options) {
+		opts.BufferSize = bufferSize
+	}
+}
+
+// WithStreamBufferSize sets the number of packets that can be queued for reading on each frisbee Stream
+// (negative values are replaced with DefaultStreamBufferSize)
+func WithStreamBufferSize(streamBufferSize int) Option {
+	return func(opts *Options) {
+		opts.StreamBufferSize = streamBufferSize
+	}
+}
+
+// WithDirectReadThreshold sets the content length above which incoming packets are read straight
+// into the packet's content buffer instead of being read into the connection's read buffer and then copied (use -1 to disable)
+//
+// Packets that are read directly should be returned to the pool with packet.Release instead of packet.Put once they
+// are no longer needed, so that their large content buffers are not kept by the pool. The size of the content buffer
+// can be limited with WithMaxContentLength.
+func WithDirectReadThreshold(threshold int) Option {
+	return func(opts *Options) {
+		opts.DirectReadThreshold = threshold
+	}
+}
+
+// WithMaxContentLength sets the largest content length that is accepted for incoming packets, which is unlimited
+// by default (or if maxContentLength is 0). The connection is closed with the ContentLengthExceeded error when a
+// packet with a larger content length is received, before any memory is allocated for its content, so that a peer
+// cannot make the connection allocate up to 4 GiB with a single packet header.
+func WithMaxContentLength(maxContentLength int) Option {
+	return func(opts *Options) {
+		opts.MaxContentLength = maxContentLength
+	}
+}
+
+// WithDirectWriteThreshold sets the content length above which outgoing packets are written straight from the
+// packet's content buffer, using a vectored write where possible, instead of being copied into the connection's write queue (use -1 to disable)
+//
+// Writing a packet directly blocks the caller until the packet has been written, since the packet cannot be modified before then.
+func WithDirectWriteThreshold(threshold int) Option {
+	return func(opts *Options) {
+		opts.DirectWriteThreshold = threshold
+	}
+}
+
+// WithIncomingOverflow sets the OverflowPolicy used when the incoming packet queue of a frisbee connection is full
+func WithIncomingOverflow(policy OverflowPolicy) Option {
+	return func(opts *Options) {
+		opts.IncomingOverflow = policy
+	}
+}
+
+// WithStreamOverflow sets the OverflowPolicy used when the packet queue of a frisbee Stream is full
+func WithStreamOverflow(policy OverflowPolicy) Option {
+	return func(opts *Options) {
+		opts.StreamOverflow = policy
+	}
+}
@@ -20,6 +20,15 @@ func TestWithoutOptions(t *testing.T) {
 	assert.Equal(t, time.Minute*3, options.KeepAlive)
 	assert.NotNil(t, options.Logger)
 	assert.Nil(t, options.TLSConfig)
+	assert.Equal(t, DefaultDeadline, options.Deadline)
+	assert.Equal(t, DefaultPingInterval, options.PingInterval)
+	assert.Equal(t, DefaultBufferSize, options.BufferSize)
+	assert.Equal(t, DefaultStreamBufferSize, options.StreamBufferSize)
+	assert.Equal(t, DefaultBufferSize, options.DirectReadThreshold)
+	assert.Equal(t, DefaultBufferSize, options.DirectWriteThreshold)
+	assert.Equal(t, 0, options.MaxContentLength)
+	assert.Equal(t, OverflowBlock, options.IncomingOverflow)
+	assert.Equal(t, OverflowBlock, options.StreamOverflow)
 }
 
 func TestWithOptions(t *testing.T) {
@@ -42,12 +51,18 @@ func TestDisableOptions(t *testing.T) {
 	t.Parallel()
 
 	option := WithOptions(Options{
-		KeepAlive: -1,
+		KeepAlive:            -1,
+		PingInterval:         -1,
+		DirectReadThreshold:  -1,
+		DirectWriteThreshold: -1,
 	})
 
 	options := loadOptions(option)
 
 	assert.Equal(t, time.Duration(-1), options.KeepAlive)
+	assert.Equal(t, time.Duration(-1), options.PingInterval)
+	assert.Equal(t, -1, options.DirectReadThreshold)
+	assert.Equal(t, -1, options.DirectWriteThreshold)
 	assert.NotNil(t, options.Logger)
 	assert.Nil(t, options.TLSConfig)
 }
@@ -63,10 +78,35 @@ func TestIndividualOptions(t *testing.T) {
 	keepAliveOption := WithKeepAlive(time.Minute * 6)
 	loggerOption := WithLogger(logger)
 	TLSOption := WithTLS(tlsConfig)
+	deadlineOption := WithDeadline(time.Second * 30)
+	pingIntervalOption := WithPingInterval(time.Second * 10)
+	bufferSizeOption := WithBufferSize(1 << 20)
+	streamBufferSizeOption := WithStreamBufferSize(1 << 8)
+	incomingOverflowOption := WithIncomingOverflow(OverflowDropOldest)
+	streamOverflowOption := WithStreamOverflow(OverflowClose)
 
-	options := loadOptions(keepAliveOption, loggerOption, TLSOption)
+	options := loadOptions(keepAliveOption, loggerOption, TLSOption, deadlineOption, pingIntervalOption, bufferSizeOption, streamBufferSizeOption, incomingOverflowOption, streamOverflowOption)
 
 	assert.Equal(t, time.Minute*6, options.KeepAlive)
 	assert.Equal(t, logger, options.Logger)
 	assert.Equal(t, tlsConfig, options.TLSConfig)
+	assert.Equal(t, time.Second*30, options.Deadline)
+	assert.Equal(t, time.Second*10, options.PingInterval)
+	assert.Equal(t, 1<<20, options.BufferSize)
+	assert.Equal(t, 1<<8, options.StreamBufferSize)
+	assert.Equal(t, OverflowDropOldest, options.IncomingOverflow)
+	assert.Equal(t, OverflowClose, options.StreamOverflow)
+}
+
+func TestInvalidOptions(t *testing.T) {
+	t.Parallel()
+
+	options := loadOptions(WithBufferSize(-1), WithStreamBufferSize(-1))
+	assert.Equal(t, DefaultBufferSize, options.BufferSize)
+	assert.Equal(t, DefaultStreamBufferSize, options.StreamBufferSize)
+
+	options = loadOptions(WithBufferSize(1))
+	assert.Equal(t, MinBufferSize, options.BufferSize)
+	assert.Equal(t, MinBufferSize, options.DirectReadThreshold)
+	assert.Equal(t, MinBufferSize, options.DirectWriteThreshold)
 }
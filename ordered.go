@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"sync"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// orderer makes sure that the tasks for packets with the same key (on the same connection) run one at a time and
+// in the order that the packets were received, while tasks for different keys are submitted without waiting.
+//
+// Only the first task for a key is submitted straight away, and the tasks that arrive while it is running are
+// queued (in order) until it completes, at which point the next one is submitted.
+type orderer struct {
+	key     func(*packet.Packet) uint64
+	mu      sync.Mutex
+	pending map[orderKey][]func()
+	queued  uint64
+}
+
+type orderKey struct {
+	conn *Async
+	key  uint64
+}
+
+// idKey is the default key function, which orders packets by their Metadata.Id
+func idKey(p *packet.Packet) uint64 {
+	return uint64(p.Metadata.Id)
+}
+
+func newOrderer(key func(*packet.Packet) uint64) *orderer {
+	if key == nil {
+		key = idKey
+	}
+	return &orderer{
+		key:     key,
+		pending: make(map[orderKey][]func()),
+	}
+}
+
+// execute submits the task once every earlier task with the same key on the connection has completed
+func (o *orderer) execute(conn *Async, key uint64, task func(), submit func(func())) {
+	k := orderKey{conn: conn, key: key}
+	o.mu.Lock()
+	if pending, ok := o.pending[k]; ok {
+		o.pending[k] = append(pending, task)
+		o.queued++
+		o.mu.Unlock()
+		return
+	}
+	o.pending[k] = nil
+	o.mu.Unlock()
+	submit(o.wrap(k, task, submit))
+}
+
+// wrap returns a function that runs the task and then submits the next task queued for its key
+func (o *orderer) wrap(k orderKey, task func(), submit func(func())) func() {
+	return func() {
+		task()
+		o.mu.Lock()
+		pending := o.pending[k]
+		if len(pending) == 0 {
+			delete(o.pending, k)
+			o.mu.Unlock()
+			return
+		}
+		next := pending[0]
+		pending[0] = nil
+		o.pending[k] = pending[1:]
+		o.queued--
+		o.mu.Unlock()
+		// The next task is submitted from a new goroutine for the same reasons as in limiter.release
+		go submit(o.wrap(k, next, submit))
+	}
+}
+
+// queuedTotal returns the number of tasks waiting for an earlier task with the same key to complete
+func (o *orderer) queuedTotal() uint64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.queued
+}
@@ -0,0 +1,112 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+	"github.com/loopholelabs/testing/conn/pair"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+func TestServerOrderingKey(t *testing.T) {
+	t.Parallel()
+
+	const testSize = 100
+	const keys = 4
+
+	// Every packet's content is its key followed by its sequence number within that key
+	for name, key := range map[string]func(*packet.Packet) uint64{
+		"id":     nil,
+		"custom": func(p *packet.Packet) uint64 { return uint64(p.Content.Bytes()[0]) },
+	} {
+		key := key
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var mu sync.Mutex
+			last := make(map[byte]int)
+			var running, maxRunning atomic.Int64
+			var handled atomic.Int64
+			serverHandlerTable := make(HandlerTable)
+			serverHandlerTable[metadata.PacketPing] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+				n := running.Add(1)
+				for m := maxRunning.Load(); n > m && !maxRunning.CompareAndSwap(m, n); m = maxRunning.Load() {
+				}
+				time.Sleep(time.Millisecond)
+				content := incoming.Content.Bytes()
+				mu.Lock()
+				assert.Equal(t, last[content[0]], int(content[1]))
+				last[content[0]] = int(content[1]) + 1
+				mu.Unlock()
+				running.Add(-1)
+				if handled.Add(1) == testSize {
+					outgoing = incoming
+				}
+				return
+			}
+
+			emptyLogger := logging.Test(t, logging.Noop, t.Name())
+			s, err := NewServer(serverHandlerTable, context.Background(), WithLogger(emptyLogger))
+			require.NoError(t, err)
+
+			s.SetOrderingKey(key)
+
+			serverConn, clientConn, err := pair.New()
+			require.NoError(t, err)
+
+			go s.ServeConn(serverConn)
+
+			c := NewAsync(clientConn, emptyLogger)
+
+			p := packet.Get()
+			p.Metadata.Operation = metadata.PacketPing
+			for i := 0; i < testSize; i++ {
+				k := byte(i % keys)
+				p.Metadata.Id = uint16(k)
+				if key != nil {
+					p.Metadata.Id = uint16(i)
+				}
+				p.Content.Reset()
+				p.Content.Write([]byte{k, byte(i / keys)})
+				p.Metadata.ContentLength = uint32(p.Content.Len())
+				err = c.WritePacket(p)
+				require.NoError(t, err)
+			}
+			packet.Put(p)
+
+			p, err = c.ReadPacket()
+			require.NoError(t, err)
+			packet.Put(p)
+
+			assert.Greater(t, maxRunning.Load(), int64(1))
+			assert.LessOrEqual(t, maxRunning.Load(), int64(keys))
+			mu.Lock()
+			for k := byte(0); k < keys; k++ {
+				assert.Equal(t, testSize/keys, last[k])
+			}
+			mu.Unlock()
+			assert.Equal(t, uint64(0), s.QueuedOrdered())
+			require.Eventually(t, func() bool {
+				s.orderer.mu.Lock()
+				defer s.orderer.mu.Unlock()
+				return len(s.orderer.pending) == 0
+			}, DefaultDeadline, time.Millisecond*10)
+
+			err = c.Close()
+			assert.NoError(t, err)
+			err = s.Shutdown()
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,145 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/loopholelabs/polyglot/v2"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+var (
+	// Overloaded is wrapped by every OverloadedError, and can be checked for using errors.Is
+	Overloaded = errors.New("server overloaded")
+)
+
+// Priority decides which packets are shed first by a Server's OverloadPolicy
+//
+//	PriorityLow: shed once half of the MaxInFlight or MaxQueued threshold has been reached
+//	PriorityNormal: shed once the MaxInFlight or MaxQueued threshold has been reached (default)
+//	PriorityHigh: shed once twice the MaxInFlight or MaxQueued threshold has been reached
+//	PriorityCritical: never shed
+type Priority uint8
+
+const (
+	PriorityLow = Priority(iota)
+	PriorityNormal
+	PriorityHigh
+	PriorityCritical
+)
+
+// OverloadPolicy is used to configure when a Server sheds incoming packets instead of handling them
+//
+// MaxInFlight is the number of packets that can be in flight (read from a connection but not yet handled) across
+// all connections, and MaxQueued is the number of packets that can be waiting in a single connection's incoming
+// queue. Once either threshold (scaled by the operation's Priority) has been reached, new packets are not handled
+// and an OVERLOADED packet with the same Id is sent back instead, which tells the client to retry after RetryAfter.
+//
+// A threshold of 0 disables that check, and a RetryAfter of 0 means that no retry delay is suggested.
+type OverloadPolicy struct {
+	MaxInFlight uint64
+	MaxQueued   uint64
+	RetryAfter  time.Duration
+}
+
+// OverloadedError is the error for a packet that was shed by the server because it was overloaded
+type OverloadedError struct {
+	// Id is the Id of the packet that was shed
+	Id uint16
+
+	// RetryAfter is how long the server suggested waiting before retrying the packet
+	RetryAfter time.Duration
+}
+
+func (e *OverloadedError) Error() string {
+	return fmt.Sprintf("%s: packet %d was shed, retry after %s", Overloaded, e.Id, e.RetryAfter)
+}
+
+func (e *OverloadedError) Unwrap() error {
+	return Overloaded
+}
+
+// OverloadedFromPacket returns the OverloadedError for an OVERLOADED packet, or nil if the packet is not an OVERLOADED packet.
+// This is useful for Async connections, which receive OVERLOADED packets from ReadPacket.
+func OverloadedFromPacket(p *packet.Packet) *OverloadedError {
+	if p.Metadata.Operation != OVERLOADED {
+		return nil
+	}
+	e := &OverloadedError{
+		Id: p.Metadata.Id,
+	}
+	if retryAfter, err := polyglot.Decoder(p.Content.Bytes()).Uint32(); err == nil {
+		e.RetryAfter = time.Duration(retryAfter) * time.Millisecond
+	}
+	return e
+}
+
+// overload decides whether incoming packets should be shed based on an OverloadPolicy and per-operation priorities
+type overload struct {
+	mu         sync.RWMutex
+	policy     OverloadPolicy
+	priorities map[uint16]Priority
+}
+
+func newOverload() *overload {
+	return &overload{
+		priorities: make(map[uint16]Priority),
+	}
+}
+
+func (o *overload) setPolicy(policy OverloadPolicy) {
+	o.mu.Lock()
+	o.policy = policy
+	o.mu.Unlock()
+}
+
+func (o *overload) setPriority(operation uint16, priority Priority) {
+	o.mu.Lock()
+	o.priorities[operation] = priority
+	o.mu.Unlock()
+}
+
+// shed returns whether a packet with the given operation should be shed given the
+// number of packets in flight on the server and queued on the packet's connection
+func (o *overload) shed(operation uint16, inFlight uint64, queued uint64) bool {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	priority, ok := o.priorities[operation]
+	if !ok {
+		priority = PriorityNormal
+	}
+	if priority >= PriorityCritical {
+		return false
+	}
+	return exceeds(inFlight, o.policy.MaxInFlight, priority) || exceeds(queued, o.policy.MaxQueued, priority)
+}
+
+// exceeds returns whether value has reached the threshold scaled by the priority
+func exceeds(value uint64, threshold uint64, priority Priority) bool {
+	if threshold == 0 {
+		return false
+	}
+	switch priority {
+	case PriorityLow:
+		threshold = max(threshold/2, 1)
+	case PriorityHigh:
+		threshold *= 2
+	}
+	return value >= threshold
+}
+
+// encode turns the incoming packet into the OVERLOADED packet that is sent back in its place
+func (o *overload) encode(p *packet.Packet) {
+	o.mu.RLock()
+	retryAfter := o.policy.RetryAfter
+	o.mu.RUnlock()
+	p.Metadata.Operation = OVERLOADED
+	p.Content.Reset()
+	polyglot.Encoder(p.Content).Uint32(uint32(retryAfter.Milliseconds()))
+	p.Metadata.ContentLength = uint32(p.Content.Len())
+}
@@ -0,0 +1,154 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+	"github.com/loopholelabs/testing/conn/pair"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+func TestOverload(t *testing.T) {
+	t.Parallel()
+
+	o := newOverload()
+	o.setPolicy(OverloadPolicy{MaxInFlight: 4, MaxQueued: 8})
+	o.setPriority(metadata.PacketPing, PriorityLow)
+	o.setPriority(metadata.PacketPong, PriorityHigh)
+	o.setPriority(metadata.PacketProbe, PriorityCritical)
+
+	const normal = uint16(100)
+
+	assert.False(t, o.shed(normal, 3, 7))
+	assert.True(t, o.shed(normal, 4, 0))
+	assert.True(t, o.shed(normal, 0, 8))
+
+	assert.False(t, o.shed(metadata.PacketPing, 1, 3))
+	assert.True(t, o.shed(metadata.PacketPing, 2, 0))
+	assert.True(t, o.shed(metadata.PacketPing, 0, 4))
+
+	assert.False(t, o.shed(metadata.PacketPong, 7, 15))
+	assert.True(t, o.shed(metadata.PacketPong, 8, 0))
+
+	assert.False(t, o.shed(metadata.PacketProbe, 1000, 1000))
+
+	o.setPolicy(OverloadPolicy{RetryAfter: time.Second})
+	assert.False(t, o.shed(normal, 1000, 1000))
+
+	p := packet.Get()
+	p.Metadata.Id = 32
+	p.Metadata.Operation = normal
+	p.Content.Write([]byte("content"))
+	p.Metadata.ContentLength = uint32(p.Content.Len())
+	o.encode(p)
+
+	err := OverloadedFromPacket(p)
+	require.NotNil(t, err)
+	assert.Equal(t, uint16(32), err.Id)
+	assert.Equal(t, time.Second, err.RetryAfter)
+	assert.ErrorIs(t, err, Overloaded)
+
+	p.Metadata.Operation = normal
+	assert.Nil(t, OverloadedFromPacket(p))
+	packet.Put(p)
+}
+
+func TestServerOverloadPolicy(t *testing.T) {
+	t.Parallel()
+
+	const retryAfter = time.Millisecond * 250
+
+	release := make(chan struct{})
+	serverHandlerTable := make(HandlerTable)
+	serverHandlerTable[metadata.PacketProbe] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		<-release
+		return
+	}
+	serverHandlerTable[metadata.PacketPing] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		outgoing = incoming
+		return
+	}
+
+	pings := make(chan uint16, 1)
+	clientHandlerTable := make(HandlerTable)
+	clientHandlerTable[metadata.PacketPing] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		pings <- incoming.Metadata.Id
+		return
+	}
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	s, err := NewServer(serverHandlerTable, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	s.SetOverloadPolicy(OverloadPolicy{MaxInFlight: 2, RetryAfter: retryAfter})
+	s.SetOperationPriority(metadata.PacketPing, PriorityCritical)
+
+	c, err := NewClient(clientHandlerTable, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	overloaded := make(chan *OverloadedError, 1)
+	c.SetOverloadedHandler(func(_ context.Context, err *OverloadedError) {
+		overloaded <- err
+	})
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+
+	go s.ServeConn(serverConn)
+	err = c.FromConn(clientConn)
+	require.NoError(t, err)
+
+	p := packet.Get()
+	p.Metadata.Operation = metadata.PacketProbe
+	for i := 0; i < 3; i++ {
+		p.Metadata.Id = uint16(i)
+		err = c.WritePacket(p)
+		require.NoError(t, err)
+	}
+
+	select {
+	case err := <-overloaded:
+		assert.Equal(t, uint16(2), err.Id)
+		assert.Equal(t, retryAfter, err.RetryAfter)
+		assert.True(t, errors.Is(err, Overloaded))
+	case <-time.After(DefaultDeadline):
+		t.Fatal("timed out waiting for overloaded error")
+	}
+	assert.Equal(t, uint64(1), s.Shed())
+	assert.Equal(t, uint64(2), s.InFlight())
+
+	// Critical operations are handled even while the server is overloaded
+	p.Metadata.Operation = metadata.PacketPing
+	p.Metadata.Id = 3
+	err = c.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	select {
+	case id := <-pings:
+		assert.Equal(t, uint16(3), id)
+	case <-time.After(DefaultDeadline):
+		t.Fatal("timed out waiting for ping")
+	}
+
+	close(release)
+	require.Eventually(t, func() bool {
+		return s.InFlight() == 0
+	}, DefaultDeadline, time.Millisecond*10)
+	assert.Equal(t, uint64(1), s.Shed())
+
+	err = c.Close()
+	assert.NoError(t, err)
+	err = s.Shutdown()
+	assert.NoError(t, err)
+}
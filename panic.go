@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+var (
+	// HandlerPanicked is wrapped by every PanicError, and can be checked for using errors.Is
+	HandlerPanicked = errors.New("handler panicked")
+)
+
+// PanicPolicy is used to select what happens to a connection when one of its handlers panics
+//
+//	PanicClose: close the connection, passing the *PanicError to the OnClosed function (default)
+//	PanicRespond: keep the connection open, and send an ERROR packet with the ErrorPanic code
+//	and the same Id as the packet whose handler panicked in place of a response
+//
+// The same applies to the other functions that are called for an incoming packet, which are the PacketContext
+// function, the NotFound handler, ActionHandlers, the UpdateContext function, and the Client's handlers for
+// OVERLOADED, TIMEOUT, ERROR and FINAL packets. Since ActionHandlers, the UpdateContext function and the
+// Client's handlers are called once there is no response left to send, panics in them only keep the
+// connection open with PanicRespond.
+type PanicPolicy int
+
+const (
+	// PanicClose closes the connection whose handler panicked (default)
+	PanicClose = PanicPolicy(iota)
+
+	// PanicRespond sends an ERROR packet in place of the response and keeps the connection open
+	PanicRespond
+)
+
+// PanicError is the error for a handler that panicked, which is recovered
+// instead of crashing the process
+type PanicError struct {
+	// Operation is the operation of the packet whose handler panicked
+	Operation uint16
+
+	// Value is the value that the handler panicked with
+	Value any
+
+	// Stack is the stack trace of the handler when it panicked
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("%s while handling operation %d: %v", HandlerPanicked, e.Operation, e.Value)
+}
+
+func (e *PanicError) Unwrap() []error {
+	if err, ok := e.Value.(error); ok {
+		return []error{HandlerPanicked, err}
+	}
+	return []error{HandlerPanicked}
+}
+
+// handlerResult is the result of running a handler, which has a nil outgoing
+// packet and the NONE action if the handler panicked
+type handlerResult struct {
+	outgoing *packet.Packet
+	action   Action
+	panic    *PanicError
+}
+
+// recoverPanic calls f, and returns the *PanicError for the packet with the given operation if f panics. Every
+// function that is called for an incoming packet (see PanicPolicy) is called using recoverPanic.
+func recoverPanic(operation uint16, f func()) (err *PanicError) {
+	defer func() {
+		if value := recover(); value != nil {
+			err = &PanicError{
+				Operation: operation,
+				Value:     value,
+				Stack:     debug.Stack(),
+			}
+		}
+	}()
+	f()
+	return nil
+}
+
+// callHandler runs the handler for the incoming packet, recovering from any panic in the handler
+func callHandler(ctx context.Context, handler Handler, incoming *packet.Packet) (result handlerResult) {
+	if err := recoverPanic(incoming.Metadata.Operation, func() {
+		result.outgoing, result.action = handler(ctx, incoming)
+	}); err != nil {
+		return handlerResult{panic: err}
+	}
+	return
+}
+
+// callPacketContext returns the context for the incoming packet from the PacketContext function (if it is not nil),
+// recovering from any panic in the PacketContext function, in which case ctx is returned along with the *PanicError
+func callPacketContext(ctx context.Context, packetContext func(context.Context, *packet.Packet) context.Context, incoming *packet.Packet) (context.Context, *PanicError) {
+	if packetContext == nil {
+		return ctx, nil
+	}
+	packetCtx := ctx
+	err := recoverPanic(incoming.Metadata.Operation, func() {
+		packetCtx = packetContext(ctx, incoming)
+	})
+	return packetCtx, err
+}
@@ -0,0 +1,478 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+	"github.com/loopholelabs/testing/conn/pair"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+var errTestPanic = errors.New("test panic")
+
+func TestServerPanic(t *testing.T) {
+	t.Parallel()
+
+	newServer := func(t *testing.T) (*Server, chan error) {
+		serverHandlerTable := make(HandlerTable)
+		serverHandlerTable[metadata.PacketProbe] = func(_ context.Context, _ *packet.Packet) (outgoing *packet.Packet, action Action) {
+			panic(errTestPanic)
+		}
+		serverHandlerTable[metadata.PacketPing] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+			outgoing = incoming
+			return
+		}
+
+		emptyLogger := logging.Test(t, logging.Noop, t.Name())
+		s, err := NewServer(serverHandlerTable, context.Background(), WithLogger(emptyLogger))
+		require.NoError(t, err)
+
+		closed := make(chan error, 1)
+		err = s.SetOnClosed(func(_ *Async, err error) {
+			closed <- err
+		})
+		require.NoError(t, err)
+		return s, closed
+	}
+
+	t.Run("close", func(t *testing.T) {
+		t.Parallel()
+
+		s, closed := newServer(t)
+
+		serverConn, clientConn, err := pair.New()
+		require.NoError(t, err)
+
+		go s.ServeConn(serverConn)
+
+		emptyLogger := logging.Test(t, logging.Noop, t.Name())
+		c := NewAsync(clientConn, emptyLogger)
+
+		p := packet.Get()
+		p.Metadata.Operation = metadata.PacketProbe
+		err = c.WritePacket(p)
+		require.NoError(t, err)
+		packet.Put(p)
+
+		select {
+		case err := <-closed:
+			assert.ErrorIs(t, err, HandlerPanicked)
+			assert.ErrorIs(t, err, errTestPanic)
+			var panicErr *PanicError
+			require.ErrorAs(t, err, &panicErr)
+			assert.Equal(t, metadata.PacketProbe, panicErr.Operation)
+			assert.Contains(t, string(panicErr.Stack), "TestServerPanic")
+		case <-time.After(DefaultDeadline):
+			t.Fatal("timed out waiting for connection to close")
+		}
+		assert.Equal(t, uint64(1), s.Panics())
+
+		_, err = c.ReadPacket()
+		require.ErrorIs(t, err, ConnectionClosed)
+
+		err = c.Close()
+		assert.NoError(t, err)
+		err = s.Shutdown()
+		assert.NoError(t, err)
+	})
+
+	t.Run("respond", func(t *testing.T) {
+		t.Parallel()
+
+		s, closed := newServer(t)
+		s.SetPanicPolicy(PanicRespond)
+
+		pings := make(chan uint16, 1)
+		clientHandlerTable := make(HandlerTable)
+		clientHandlerTable[metadata.PacketPing] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+			pings <- incoming.Metadata.Id
+			return
+		}
+
+		emptyLogger := logging.Test(t, logging.Noop, t.Name())
+		c, err := NewClient(clientHandlerTable, context.Background(), WithLogger(emptyLogger))
+		require.NoError(t, err)
+
+		remoteErrors := make(chan *RemoteError, 1)
+		c.SetErrorHandler(func(_ context.Context, err *RemoteError) {
+			remoteErrors <- err
+		})
+
+		serverConn, clientConn, err := pair.New()
+		require.NoError(t, err)
+
+		go s.ServeConn(serverConn)
+		err = c.FromConn(clientConn)
+		require.NoError(t, err)
+
+		p := packet.Get()
+		p.Metadata.Id = 1
+		p.Metadata.Operation = metadata.PacketProbe
+		err = c.WritePacket(p)
+		require.NoError(t, err)
+
+		p.Metadata.Id = 2
+		p.Metadata.Operation = metadata.PacketPing
+		err = c.WritePacket(p)
+		require.NoError(t, err)
+		packet.Put(p)
+
+		select {
+		case err := <-remoteErrors:
+			assert.Equal(t, uint16(1), err.Id)
+			assert.Equal(t, ErrorPanic, err.Code)
+			assert.ErrorIs(t, err, HandlerPanicked)
+		case <-time.After(DefaultDeadline):
+			t.Fatal("timed out waiting for ERROR packet")
+		}
+
+		select {
+		case id := <-pings:
+			assert.Equal(t, uint16(2), id)
+		case <-time.After(DefaultDeadline):
+			t.Fatal("timed out waiting for ping")
+		}
+		assert.Equal(t, uint64(1), s.Panics())
+		assert.Empty(t, closed)
+
+		err = c.Close()
+		assert.NoError(t, err)
+		err = s.Shutdown()
+		assert.NoError(t, err)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		t.Parallel()
+
+		serverHandlerTable := make(HandlerTable)
+		serverHandlerTable[metadata.PacketProbe] = func(ctx context.Context, _ *packet.Packet) (outgoing *packet.Packet, action Action) {
+			<-ctx.Done()
+			time.Sleep(time.Millisecond * 10)
+			panic(errTestPanic)
+		}
+
+		emptyLogger := logging.Test(t, logging.Noop, t.Name())
+		s, err := NewServer(serverHandlerTable, context.Background(), WithLogger(emptyLogger))
+		require.NoError(t, err)
+
+		s.SetHandlerTimeout(time.Millisecond * 10)
+
+		serverConn, clientConn, err := pair.New()
+		require.NoError(t, err)
+
+		go s.ServeConn(serverConn)
+
+		c := NewAsync(clientConn, emptyLogger)
+
+		p := packet.Get()
+		p.Metadata.Operation = metadata.PacketProbe
+		err = c.WritePacket(p)
+		require.NoError(t, err)
+		packet.Put(p)
+
+		p, err = c.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, TIMEOUT, p.Metadata.Operation)
+		packet.Put(p)
+
+		// Panics in handlers that have already timed out are reported without closing the connection
+		require.Eventually(t, func() bool {
+			return s.Panics() == 1
+		}, DefaultDeadline, time.Millisecond*10)
+		assert.False(t, c.Closed())
+
+		err = c.Close()
+		assert.NoError(t, err)
+		err = s.Shutdown()
+		assert.NoError(t, err)
+	})
+}
+
+func TestClientPanic(t *testing.T) {
+	t.Parallel()
+
+	for _, policy := range []PanicPolicy{PanicClose, PanicRespond} {
+		policy := policy
+		t.Run(map[PanicPolicy]string{PanicClose: "close", PanicRespond: "respond"}[policy], func(t *testing.T) {
+			t.Parallel()
+
+			clientHandlerTable := make(HandlerTable)
+			clientHandlerTable[metadata.PacketPing] = func(_ context.Context, _ *packet.Packet) (outgoing *packet.Packet, action Action) {
+				panic("test panic")
+			}
+
+			emptyLogger := logging.Test(t, logging.Noop, t.Name())
+			c, err := NewClient(clientHandlerTable, context.Background(), WithLogger(emptyLogger))
+			require.NoError(t, err)
+
+			c.SetPanicPolicy(policy)
+
+			serverConn, clientConn, err := pair.New()
+			require.NoError(t, err)
+
+			err = c.FromConn(clientConn)
+			require.NoError(t, err)
+
+			server := NewAsync(serverConn, emptyLogger)
+
+			p := packet.Get()
+			p.Metadata.Id = 16
+			p.Metadata.Operation = metadata.PacketPing
+			err = server.WritePacket(p)
+			require.NoError(t, err)
+			packet.Put(p)
+
+			if policy == PanicClose {
+				_, err = server.ReadPacket()
+				require.ErrorIs(t, err, ConnectionClosed)
+				assert.True(t, c.Closed())
+			} else {
+				p, err = server.ReadPacket()
+				require.NoError(t, err)
+				remoteErr := RemoteErrorFromPacket(p)
+				require.NotNil(t, remoteErr)
+				assert.Equal(t, uint16(16), remoteErr.Id)
+				assert.Equal(t, ErrorPanic, remoteErr.Code)
+				assert.Equal(t, HandlerPanicked.Error(), remoteErr.Message)
+				packet.Put(p)
+				assert.False(t, c.Closed())
+			}
+			assert.Equal(t, uint64(1), c.Panics())
+
+			_ = c.Close()
+			_ = server.Close()
+		})
+	}
+}
+
+func TestServerCallbackPanic(t *testing.T) {
+	t.Parallel()
+
+	echo := func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		outgoing = incoming
+		return
+	}
+	panicking := func(_ context.Context, _ *packet.Packet) (outgoing *packet.Packet, action Action) {
+		panic(errTestPanic)
+	}
+
+	// responds is true for the functions that are called before the response is sent
+	cases := []struct {
+		name     string
+		responds bool
+		setup    func(t *testing.T, s *Server, handlerTable HandlerTable)
+	}{
+		{"PacketContext", true, func(_ *testing.T, s *Server, handlerTable HandlerTable) {
+			handlerTable[metadata.PacketPing] = echo
+			s.PacketContext = func(_ context.Context, _ *packet.Packet) context.Context {
+				panic(errTestPanic)
+			}
+		}},
+		{"NotFound", true, func(_ *testing.T, s *Server, _ HandlerTable) {
+			s.SetNotFoundHandler(panicking)
+		}},
+		{"ActionHandler", false, func(t *testing.T, s *Server, handlerTable HandlerTable) {
+			handlerTable[metadata.PacketPing] = func(ctx context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+				outgoing, _ = echo(ctx, incoming)
+				action = testAction
+				return
+			}
+			err := s.SetActionHandler(testAction, func(_ context.Context, _ *Async) Action {
+				panic(errTestPanic)
+			})
+			require.NoError(t, err)
+		}},
+		{"UpdateContext", false, func(_ *testing.T, s *Server, handlerTable HandlerTable) {
+			handlerTable[metadata.PacketPing] = func(ctx context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+				outgoing, _ = echo(ctx, incoming)
+				action = UPDATE
+				return
+			}
+			s.UpdateContext = func(_ context.Context, _ *Async) context.Context {
+				panic(errTestPanic)
+			}
+		}},
+	}
+
+	for _, c := range cases {
+		c := c
+		for _, policy := range []PanicPolicy{PanicClose, PanicRespond} {
+			policy := policy
+			t.Run(c.name+"/"+map[PanicPolicy]string{PanicClose: "close", PanicRespond: "respond"}[policy], func(t *testing.T) {
+				t.Parallel()
+
+				emptyLogger := logging.Test(t, logging.Noop, t.Name())
+				s, err := NewServer(make(HandlerTable), context.Background(), WithLogger(emptyLogger))
+				require.NoError(t, err)
+
+				handlerTable := make(HandlerTable)
+				c.setup(t, s, handlerTable)
+				err = s.SetHandlerTable(handlerTable)
+				require.NoError(t, err)
+				s.SetPanicPolicy(policy)
+
+				closed := make(chan error, 1)
+				err = s.SetOnClosed(func(_ *Async, err error) {
+					closed <- err
+				})
+				require.NoError(t, err)
+
+				serverConn, clientConn, err := pair.New()
+				require.NoError(t, err)
+
+				go s.ServeConn(serverConn)
+
+				conn := NewAsync(clientConn, emptyLogger)
+
+				p := packet.Get()
+				p.Metadata.Id = 32
+				p.Metadata.Operation = metadata.PacketPing
+				err = conn.WritePacket(p)
+				require.NoError(t, err)
+				packet.Put(p)
+
+				if !c.responds {
+					// The response is sent before the function that panics is called
+					p, err = conn.ReadPacket()
+					require.NoError(t, err)
+					assert.Equal(t, metadata.PacketPing, p.Metadata.Operation)
+					packet.Put(p)
+				}
+
+				if policy == PanicClose {
+					select {
+					case err := <-closed:
+						assert.ErrorIs(t, err, errTestPanic)
+						var panicErr *PanicError
+						require.ErrorAs(t, err, &panicErr)
+						assert.Equal(t, metadata.PacketPing, panicErr.Operation)
+					case <-time.After(DefaultDeadline):
+						t.Fatal("timed out waiting for connection to close")
+					}
+					_, err = conn.ReadPacket()
+					require.ErrorIs(t, err, ConnectionClosed)
+				} else {
+					if c.responds {
+						p, err = conn.ReadPacket()
+						require.NoError(t, err)
+						remoteErr := RemoteErrorFromPacket(p)
+						require.NotNil(t, remoteErr)
+						assert.Equal(t, uint16(32), remoteErr.Id)
+						assert.Equal(t, ErrorPanic, remoteErr.Code)
+						packet.Put(p)
+					}
+					require.Eventually(t, func() bool {
+						return s.Panics() == 1
+					}, DefaultDeadline, time.Millisecond*10)
+					assert.False(t, conn.Closed())
+				}
+				assert.Equal(t, uint64(1), s.Panics())
+
+				_ = conn.Close()
+				err = s.Shutdown()
+				assert.NoError(t, err)
+			})
+		}
+	}
+}
+
+func TestClientCallbackPanic(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		operation uint16
+		setup     func(t *testing.T, c *Client, handlerTable HandlerTable)
+	}{
+		"PacketContext": {metadata.PacketPing, func(_ *testing.T, c *Client, handlerTable HandlerTable) {
+			handlerTable[metadata.PacketPing] = versionHandler("", nil, nil)
+			c.PacketContext = func(_ context.Context, _ *packet.Packet) context.Context {
+				panic(errTestPanic)
+			}
+		}},
+		"NotFound": {metadata.PacketPing, func(_ *testing.T, c *Client, _ HandlerTable) {
+			c.SetNotFoundHandler(func(_ context.Context, _ *packet.Packet) (outgoing *packet.Packet, action Action) {
+				panic(errTestPanic)
+			})
+		}},
+		"ActionHandler": {metadata.PacketPing, func(t *testing.T, c *Client, handlerTable HandlerTable) {
+			handlerTable[metadata.PacketPing] = func(_ context.Context, _ *packet.Packet) (outgoing *packet.Packet, action Action) {
+				action = testAction
+				return
+			}
+			err := c.SetActionHandler(testAction, func(_ context.Context, _ *Async) Action {
+				panic(errTestPanic)
+			})
+			require.NoError(t, err)
+		}},
+		"UpdateContext": {metadata.PacketPing, func(_ *testing.T, c *Client, handlerTable HandlerTable) {
+			handlerTable[metadata.PacketPing] = func(_ context.Context, _ *packet.Packet) (outgoing *packet.Packet, action Action) {
+				action = UPDATE
+				return
+			}
+			c.UpdateContext = func(_ context.Context, _ *Async) context.Context {
+				panic(errTestPanic)
+			}
+		}},
+		"ErrorHandler": {ERROR, func(_ *testing.T, c *Client, _ HandlerTable) {
+			c.SetErrorHandler(func(_ context.Context, _ *RemoteError) {
+				panic(errTestPanic)
+			})
+		}},
+	}
+
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			emptyLogger := logging.Test(t, logging.Noop, t.Name())
+			c, err := NewClient(make(HandlerTable), context.Background(), WithLogger(emptyLogger))
+			require.NoError(t, err)
+
+			handlerTable := make(HandlerTable)
+			tc.setup(t, c, handlerTable)
+			err = c.SetHandlerTable(handlerTable)
+			require.NoError(t, err)
+
+			serverConn, clientConn, err := pair.New()
+			require.NoError(t, err)
+
+			err = c.FromConn(clientConn)
+			require.NoError(t, err)
+
+			server := NewAsync(serverConn, emptyLogger)
+
+			var p *packet.Packet
+			if tc.operation == ERROR {
+				p = errorPacket(16, ErrorUnknownOperation, UnknownOperation.Error())
+				err = server.writePacket(p)
+			} else {
+				p = packet.Get()
+				p.Metadata.Id = 16
+				p.Metadata.Operation = tc.operation
+				err = server.WritePacket(p)
+			}
+			require.NoError(t, err)
+			packet.Put(p)
+
+			// The connection is closed because of the default PanicPolicy
+			_, err = server.ReadPacket()
+			require.ErrorIs(t, err, ConnectionClosed)
+			assert.True(t, c.Closed())
+			assert.Equal(t, uint64(1), c.Panics())
+
+			_ = c.Close()
+			_ = server.Close()
+		})
+	}
+}
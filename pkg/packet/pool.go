@@ -4,8 +4,12 @@ package packet
 
 import (
 	"github.com/loopholelabs/common/pkg/pool"
+	"github.com/loopholelabs/polyglot/v2"
 )
 
+// MaxPooledContentSize is the largest content buffer that Release returns to the pool along with its packet
+const MaxPooledContentSize = 1 << 16
+
 var (
 	packetPool = NewPool()
 )
@@ -21,3 +25,14 @@ func Get() (s *Packet) {
 func Put(p *Packet) {
 	packetPool.Put(p)
 }
+
+// Release returns the packet to the pool in the same way as Put, except that its content buffer is dropped
+// (and replaced with a new one) if it is larger than MaxPooledContentSize. It should be used instead of Put
+// for packets with large payloads, such as those that were read directly into their content buffer, so
+// that the pool does not keep the large buffers in memory.
+func Release(p *Packet) {
+	if p.Content.Cap() > MaxPooledContentSize {
+		p.Content = polyglot.NewBuffer()
+	}
+	packetPool.Put(p)
+}
@@ -53,3 +53,20 @@ func TestRecycle(t *testing.T) {
 
 	pool.Put(p)
 }
+
+func TestRelease(t *testing.T) {
+	p := Get()
+	p.Content.Write(make([]byte, MaxPooledContentSize+1))
+	large := p.Content
+	Release(p)
+	assert.NotSame(t, large, p.Content)
+	assert.Equal(t, 0, p.Content.Len())
+	assert.LessOrEqual(t, p.Content.Cap(), MaxPooledContentSize)
+
+	p = Get()
+	p.Content.Write(make([]byte, 32))
+	small := p.Content
+	Release(p)
+	assert.Same(t, small, p.Content)
+	assert.Equal(t, 0, p.Content.Len())
+}
@@ -0,0 +1,141 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package transfer
+
+import (
+	"errors"
+	"hash/crc32"
+	"io"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// AcceptFunc is called by Receive with the file being offered by the sender. To accept the file, it must return
+// the io.WriterAt that the file should be written to and the offset that the transfer should start at, which is
+// the number of bytes of the file that have already been received if an earlier transfer is being resumed.
+// To reject the file, it must return an error, which is sent back to the sender.
+type AcceptFunc func(offer *Offer) (w io.WriterAt, offset uint64, err error)
+
+// Receive waits for a file to be offered by the sender on the other end of the stream and, if accept
+// accepts it, writes the file to the io.WriterAt returned by accept. Every chunk is verified against
+// its checksum before being written (unless the sender disabled checksums, see WithoutChecksums),
+// and Receive returns once the whole file has been written.
+//
+// If the transfer was rejected by accept, the error returned by accept is returned. Offers whose chunk size is
+// larger than the MaxContentLength of the stream's connection are rejected with InvalidChunkSize without
+// calling accept.
+func Receive(stream *frisbee.Stream, accept AcceptFunc, options ...Option) (*Offer, error) {
+	opts := loadOptions(options...)
+
+	kind, d, p, err := readMessage(stream)
+	if err != nil {
+		return nil, err
+	}
+	offer := new(Offer)
+	if kind == offerMessage {
+		offer.Name, err = d.String()
+		if err == nil {
+			offer.Size, err = d.Uint64()
+		}
+		if err == nil {
+			offer.ChunkSize, err = d.Uint32()
+		}
+		if err == nil {
+			offer.Checksums, err = d.Bool()
+		}
+		if err != nil {
+			err = errors.Join(UnexpectedMessage, err)
+		}
+	} else {
+		err = UnexpectedMessage
+	}
+	packet.Put(p)
+	if err != nil {
+		return nil, abort(stream, err)
+	}
+
+	var w io.WriterAt
+	var offset uint64
+	if maxContentLength := stream.Conn().MaxContentLength(); offer.ChunkSize == 0 || (maxContentLength > 0 && int64(offer.ChunkSize) > int64(maxContentLength)) {
+		err = InvalidChunkSize
+	} else {
+		w, offset, err = accept(offer)
+	}
+	if err == nil && offset > offer.Size {
+		err = InvalidOffset
+	}
+	if err != nil {
+		p, e := newMessage(rejectMessage)
+		e.String(err.Error())
+		_ = writeMessage(stream, p)
+		return offer, err
+	}
+
+	p, e := newMessage(acceptMessage)
+	e.Uint64(offset)
+	if err = writeMessage(stream, p); err != nil {
+		return offer, err
+	}
+
+	for {
+		kind, d, p, err = readMessage(stream)
+		if err != nil {
+			return offer, err
+		}
+		switch kind {
+		case chunkMessage:
+			var chunkOffset uint64
+			var length, checksum uint32
+			chunkOffset, err = d.Uint64()
+			if err == nil {
+				length, err = d.Uint32()
+			}
+			if err == nil {
+				checksum, err = d.Uint32()
+			}
+			packet.Put(p)
+			if err != nil {
+				return offer, abort(stream, errors.Join(UnexpectedMessage, err))
+			}
+			if chunkOffset != offset || length == 0 || length > offer.ChunkSize || offset+uint64(length) > offer.Size {
+				return offer, abort(stream, InvalidChunk)
+			}
+
+			p, err = stream.ReadPacket()
+			if err != nil {
+				return offer, err
+			}
+			if p.Metadata.ContentLength != length {
+				err = InvalidChunk
+			} else if offer.Checksums && crc32.Checksum(p.Content.Bytes(), table) != checksum {
+				err = ChecksumMismatch
+			} else {
+				_, err = w.WriteAt(p.Content.Bytes(), int64(offset))
+			}
+			packet.Release(p)
+			if err != nil {
+				return offer, abort(stream, err)
+			}
+
+			offset += uint64(length)
+			if opts.Progress != nil {
+				opts.Progress(offset, offer.Size)
+			}
+		case doneMessage:
+			packet.Put(p)
+			if offset != offer.Size {
+				return offer, abort(stream, Incomplete)
+			}
+			p, _ = newMessage(completeMessage)
+			return offer, writeMessage(stream, p)
+		case abortMessage:
+			err = remoteError(Aborted, d)
+			packet.Put(p)
+			return offer, err
+		default:
+			packet.Put(p)
+			return offer, abort(stream, UnexpectedMessage)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package transfer
+
+import (
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// Send offers the given file to the receiver on the other end of the stream under the given name, and then
+// sends the file starting at the offset that the receiver accepts it at. It returns once the receiver
+// has confirmed that the whole file was written, or with an error if the transfer was rejected or failed.
+//
+// The chunks are sent straight from the file (using sendfile when the underlying connection is a plain TCP
+// connection), which changes the file's offset for I/O while it is being sent, although the original offset is
+// restored before Send returns. The checksum of each chunk is computed by reading the chunk with ReadAt before
+// it is sent, so the file must not be written to while it is being sent.
+func Send(stream *frisbee.Stream, name string, file *os.File, options ...Option) (err error) {
+	opts := loadOptions(options...)
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	size := uint64(info.Size())
+
+	p, e := newMessage(offerMessage)
+	e.String(name).Uint64(size).Uint32(opts.ChunkSize).Bool(!opts.DisableChecksums)
+	if err = writeMessage(stream, p); err != nil {
+		return err
+	}
+
+	kind, d, p, err := readMessage(stream)
+	if err != nil {
+		return err
+	}
+	var offset uint64
+	switch kind {
+	case acceptMessage:
+		offset, err = d.Uint64()
+	case rejectMessage:
+		err = remoteError(Rejected, d)
+	default:
+		err = UnexpectedMessage
+	}
+	packet.Put(p)
+	if err != nil {
+		return err
+	}
+	if offset > size {
+		return abort(stream, InvalidOffset)
+	}
+
+	original, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return abort(stream, err)
+	}
+	defer func() {
+		if _, seekErr := file.Seek(original, io.SeekStart); err == nil {
+			err = seekErr
+		}
+	}()
+
+	var buf []byte
+	if !opts.DisableChecksums {
+		buf = make([]byte, min(uint64(opts.ChunkSize), size-offset))
+	}
+	for offset < size {
+		n := min(uint64(opts.ChunkSize), size-offset)
+		var checksum uint32
+		if !opts.DisableChecksums {
+			if _, err = file.ReadAt(buf[:n], int64(offset)); err != nil {
+				return abort(stream, err)
+			}
+			checksum = crc32.Checksum(buf[:n], table)
+		}
+		if _, err = file.Seek(int64(offset), io.SeekStart); err != nil {
+			return abort(stream, err)
+		}
+
+		p, e = newMessage(chunkMessage)
+		e.Uint64(offset).Uint32(uint32(n)).Uint32(checksum)
+		if err = writeMessage(stream, p); err != nil {
+			return closedError(stream, err)
+		}
+
+		if err = stream.WriteFrom(file, uint32(n)); err != nil {
+			return closedError(stream, err)
+		}
+
+		offset += n
+		if opts.Progress != nil {
+			opts.Progress(offset, size)
+		}
+	}
+
+	p, _ = newMessage(doneMessage)
+	if err = writeMessage(stream, p); err != nil {
+		return closedError(stream, err)
+	}
+
+	kind, d, p, err = readMessage(stream)
+	if err != nil {
+		return err
+	}
+	switch kind {
+	case completeMessage:
+	case abortMessage:
+		err = remoteError(Aborted, d)
+	default:
+		err = UnexpectedMessage
+	}
+	packet.Put(p)
+	return err
+}
+
+// closedError returns the reason that the receiver aborted the transfer if the stream
+// was closed by the receiver after aborting, and err otherwise
+func closedError(stream *frisbee.Stream, err error) error {
+	if !errors.Is(err, frisbee.StreamClosed) {
+		return err
+	}
+	kind, d, p, readErr := readMessage(stream)
+	if readErr != nil {
+		return err
+	}
+	if kind == abortMessage {
+		err = remoteError(Aborted, d)
+	}
+	packet.Put(p)
+	return err
+}
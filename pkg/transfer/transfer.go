@@ -0,0 +1,172 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package transfer implements resumable file transfers on top of frisbee Streams.
+//
+// A transfer starts with the sender offering a file to the receiver, which either rejects it or accepts it
+// starting at a given offset. The file is then sent in chunks, each of which is preceded by a header that
+// contains the offset, length and checksum of the chunk, and the receiver confirms once the whole file has been
+// written. If a transfer is interrupted (for example because the underlying connection was lost), it can be
+// resumed over a new Stream by having the receiver accept the offer at the number of bytes it has already written.
+//
+// The content of each chunk is written straight from the file using Stream.WriteFrom, so it is never copied
+// through a packet, and it is sent using sendfile when the underlying connection is a plain TCP connection. By
+// default the sender also reads each chunk with ReadAt to compute its checksum before sending it, which can be
+// disabled with WithoutChecksums so that the content of the chunks is never read by the sender.
+//
+// The receiver rejects offers whose chunk size is larger than the MaxContentLength of its connection
+// (see frisbee.WithMaxContentLength), since the chunks could otherwise never be received.
+//
+// Since stream packets are dropped by connections that do not have a stream handler set,
+// the sender's connection must also have a stream handler set.
+package transfer
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/loopholelabs/polyglot/v2"
+
+	"github.com/loopholelabs/frisbee-go"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+var (
+	Rejected          = errors.New("file transfer rejected")
+	Aborted           = errors.New("file transfer aborted")
+	Incomplete        = errors.New("file transfer incomplete")
+	InvalidOffset     = errors.New("invalid file transfer offset")
+	InvalidChunk      = errors.New("invalid file transfer chunk")
+	InvalidChunkSize  = errors.New("invalid file transfer chunk size")
+	ChecksumMismatch  = errors.New("file transfer chunk checksum mismatch")
+	UnexpectedMessage = errors.New("unexpected file transfer message")
+)
+
+const (
+	// DefaultChunkSize is the default size of the chunks that a file is sent in
+	DefaultChunkSize = 1 << 20
+)
+
+const (
+	offerMessage = uint8(iota)
+	acceptMessage
+	rejectMessage
+	chunkMessage
+	doneMessage
+	completeMessage
+	abortMessage
+)
+
+var table = crc32.MakeTable(crc32.Castagnoli)
+
+// Offer describes a file that is being offered by a sender
+type Offer struct {
+	Name      string
+	Size      uint64
+	ChunkSize uint32
+
+	// Checksums is false if the sender disabled the checksums of the chunks (see WithoutChecksums)
+	Checksums bool
+}
+
+// ProgressFunc is called after every chunk has been transferred with the number of bytes
+// of the file that have been transferred (including any that were skipped when resuming) and the size of the file
+type ProgressFunc func(transferred uint64, size uint64)
+
+// Option is used to generate the transfer options
+type Option func(opts *Options)
+
+// Options is used to configure a file transfer
+//
+// The default values are:
+//
+//	var DefaultOptions = &Options {
+//		ChunkSize:        DefaultChunkSize,
+//		Progress:         nil,
+//		DisableChecksums: false,
+//	}
+//
+// ChunkSize is only used by the sender, and is the maximum number of bytes that will be sent in a single chunk.
+// DisableChecksums is also only used by the sender, and the receiver learns whether chunks have checksums from the offer.
+type Options struct {
+	ChunkSize        uint32
+	Progress         ProgressFunc
+	DisableChecksums bool
+}
+
+func loadOptions(options ...Option) *Options {
+	opts := new(Options)
+	for _, option := range options {
+		option(opts)
+	}
+
+	if opts.ChunkSize == 0 {
+		opts.ChunkSize = DefaultChunkSize
+	}
+
+	return opts
+}
+
+// WithChunkSize sets the maximum number of bytes that will be sent in a single chunk, which must not be
+// larger than the MaxContentLength of the receiver's connection
+func WithChunkSize(chunkSize uint32) Option {
+	return func(opts *Options) {
+		opts.ChunkSize = chunkSize
+	}
+}
+
+// WithoutChecksums disables the checksums of the chunks, so that the sender does not have to read the
+// content of the chunks before sending them
+func WithoutChecksums() Option {
+	return func(opts *Options) {
+		opts.DisableChecksums = true
+	}
+}
+
+// WithProgress sets the function that will be called after every chunk has been transferred
+func WithProgress(progress ProgressFunc) Option {
+	return func(opts *Options) {
+		opts.Progress = progress
+	}
+}
+
+func newMessage(kind uint8) (*packet.Packet, *polyglot.BufferEncoder) {
+	p := packet.Get()
+	return p, polyglot.Encoder(p.Content).Uint8(kind)
+}
+
+func writeMessage(stream *frisbee.Stream, p *packet.Packet) error {
+	p.Metadata.ContentLength = uint32(p.Content.Len())
+	err := stream.WritePacket(p)
+	packet.Put(p)
+	return err
+}
+
+func readMessage(stream *frisbee.Stream) (uint8, *polyglot.BufferDecoder, *packet.Packet, error) {
+	p, err := stream.ReadPacket()
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	d := polyglot.Decoder(p.Content.Bytes())
+	kind, err := d.Uint8()
+	if err != nil {
+		packet.Put(p)
+		return 0, nil, nil, errors.Join(UnexpectedMessage, err)
+	}
+	return kind, d, p, nil
+}
+
+func abort(stream *frisbee.Stream, err error) error {
+	p, e := newMessage(abortMessage)
+	e.String(err.Error())
+	_ = writeMessage(stream, p)
+	return err
+}
+
+func remoteError(kind error, d *polyglot.BufferDecoder) error {
+	reason, err := d.String()
+	if err != nil {
+		return errors.Join(kind, UnexpectedMessage, err)
+	}
+	return fmt.Errorf("%w: %s", kind, reason)
+}
@@ -0,0 +1,277 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package transfer
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/logging"
+	"github.com/loopholelabs/testing/conn/pair"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/frisbee-go"
+)
+
+const testChunkSize = 1 << 16
+
+// runTransfer sends the given file from the sender connection to the receiver connection,
+// and returns the results of Send and Receive
+func runTransfer(t *testing.T, sender net.Conn, receiver net.Conn, file *os.File, sendOptions []Option, accept AcceptFunc, receiveOptions ...Option) (error, *Offer, error) {
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	receiverStreamCh := make(chan *frisbee.Stream, 1)
+	senderConn := frisbee.NewAsync(sender, emptyLogger, func(*frisbee.Stream) {})
+	receiverConn := frisbee.NewAsync(receiver, emptyLogger, func(stream *frisbee.Stream) {
+		receiverStreamCh <- stream
+	})
+	defer func() {
+		_ = senderConn.Close()
+		_ = receiverConn.Close()
+	}()
+
+	senderStream := senderConn.NewStream(0)
+	sendErrCh := make(chan error, 1)
+	go func() {
+		sendErrCh <- Send(senderStream, "artifact", file, sendOptions...)
+	}()
+
+	var receiverStream *frisbee.Stream
+	select {
+	case receiverStream = <-receiverStreamCh:
+	case <-time.After(frisbee.DefaultDeadline):
+		t.Fatal("timed out waiting for receiver stream")
+	}
+
+	offer, receiveErr := Receive(receiverStream, accept, receiveOptions...)
+
+	var sendErr error
+	select {
+	case sendErr = <-sendErrCh:
+	case <-time.After(frisbee.DefaultDeadline):
+		t.Fatal("timed out waiting for sender")
+	}
+
+	return sendErr, offer, receiveErr
+}
+
+func createFile(t *testing.T, name string, data []byte) *os.File {
+	f, err := os.Create(filepath.Join(t.TempDir(), name))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = f.Close()
+	})
+	_, err = f.Write(data)
+	require.NoError(t, err)
+	return f
+}
+
+func randomData(t *testing.T, size int) []byte {
+	data := make([]byte, size)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	return data
+}
+
+func TestTransfer(t *testing.T) {
+	t.Parallel()
+
+	const size = testChunkSize*3 + testChunkSize/2
+
+	run := func(t *testing.T, sender net.Conn, receiver net.Conn) {
+		data := randomData(t, size)
+		source := createFile(t, "source", data)
+		destination := createFile(t, "destination", nil)
+
+		var sent, received []uint64
+		sendOptions := []Option{WithChunkSize(testChunkSize), WithProgress(func(n uint64, total uint64) {
+			assert.Equal(t, uint64(size), total)
+			sent = append(sent, n)
+		})}
+		sendErr, offer, receiveErr := runTransfer(t, sender, receiver, source, sendOptions, func(offer *Offer) (io.WriterAt, uint64, error) {
+			return destination, 0, nil
+		}, WithProgress(func(n uint64, total uint64) {
+			assert.Equal(t, uint64(size), total)
+			received = append(received, n)
+		}))
+		require.NoError(t, sendErr)
+		require.NoError(t, receiveErr)
+
+		assert.Equal(t, "artifact", offer.Name)
+		assert.Equal(t, uint64(size), offer.Size)
+		assert.Equal(t, uint32(testChunkSize), offer.ChunkSize)
+		assert.True(t, offer.Checksums)
+		assert.Equal(t, []uint64{testChunkSize, testChunkSize * 2, testChunkSize * 3, size}, sent)
+		assert.Equal(t, sent, received)
+
+		content, err := os.ReadFile(destination.Name())
+		require.NoError(t, err)
+		assert.Equal(t, data, content)
+	}
+
+	t.Run("pipe", func(t *testing.T) {
+		t.Parallel()
+		sender, receiver := net.Pipe()
+		run(t, sender, receiver)
+	})
+
+	t.Run("tcp", func(t *testing.T) {
+		t.Parallel()
+		sender, receiver, err := pair.New()
+		require.NoError(t, err)
+		run(t, sender, receiver)
+	})
+}
+
+func TestTransferWithoutChecksums(t *testing.T) {
+	t.Parallel()
+
+	const size = testChunkSize*2 + 100
+
+	run := func(t *testing.T, sender net.Conn, receiver net.Conn) {
+		data := randomData(t, size)
+		source := createFile(t, "source", data)
+		destination := createFile(t, "destination", nil)
+
+		// Send must not change the offset of the file that it was given
+		_, err := source.Seek(7, io.SeekStart)
+		require.NoError(t, err)
+
+		sendErr, offer, receiveErr := runTransfer(t, sender, receiver, source, []Option{WithChunkSize(testChunkSize), WithoutChecksums()}, func(offer *Offer) (io.WriterAt, uint64, error) {
+			return destination, 0, nil
+		})
+		require.NoError(t, sendErr)
+		require.NoError(t, receiveErr)
+		assert.False(t, offer.Checksums)
+
+		offset, err := source.Seek(0, io.SeekCurrent)
+		require.NoError(t, err)
+		assert.Equal(t, int64(7), offset)
+
+		content, err := os.ReadFile(destination.Name())
+		require.NoError(t, err)
+		assert.Equal(t, data, content)
+	}
+
+	t.Run("pipe", func(t *testing.T) {
+		t.Parallel()
+		sender, receiver := net.Pipe()
+		run(t, sender, receiver)
+	})
+
+	t.Run("tcp", func(t *testing.T) {
+		t.Parallel()
+		sender, receiver, err := pair.New()
+		require.NoError(t, err)
+		run(t, sender, receiver)
+	})
+}
+
+func TestTransferResume(t *testing.T) {
+	t.Parallel()
+
+	const size = testChunkSize*3 + 512
+	const offset = testChunkSize + 1024
+
+	sender, receiver, err := pair.New()
+	require.NoError(t, err)
+
+	data := randomData(t, size)
+	source := createFile(t, "source", data)
+	destination := createFile(t, "destination", data[:offset])
+
+	var transferred []uint64
+	sendErr, _, receiveErr := runTransfer(t, sender, receiver, source, []Option{WithChunkSize(testChunkSize)}, func(offer *Offer) (io.WriterAt, uint64, error) {
+		info, err := destination.Stat()
+		if err != nil {
+			return nil, 0, err
+		}
+		return destination, uint64(info.Size()), nil
+	}, WithProgress(func(n uint64, _ uint64) {
+		transferred = append(transferred, n)
+	}))
+	require.NoError(t, sendErr)
+	require.NoError(t, receiveErr)
+
+	assert.Equal(t, []uint64{offset + testChunkSize, size}, transferred)
+
+	received, err := os.ReadFile(destination.Name())
+	require.NoError(t, err)
+	assert.Equal(t, data, received)
+}
+
+func TestTransferReject(t *testing.T) {
+	t.Parallel()
+
+	sender, receiver := net.Pipe()
+	source := createFile(t, "source", randomData(t, 512))
+
+	rejectErr := errors.New("no space left")
+	sendErr, offer, receiveErr := runTransfer(t, sender, receiver, source, nil, func(offer *Offer) (io.WriterAt, uint64, error) {
+		return nil, 0, rejectErr
+	})
+	require.ErrorIs(t, sendErr, Rejected)
+	assert.ErrorContains(t, sendErr, rejectErr.Error())
+	require.ErrorIs(t, receiveErr, rejectErr)
+	assert.Equal(t, uint64(512), offer.Size)
+
+	sender, receiver = net.Pipe()
+	sendErr, _, receiveErr = runTransfer(t, sender, receiver, source, nil, func(offer *Offer) (io.WriterAt, uint64, error) {
+		return createFile(t, "destination", nil), offer.Size + 1, nil
+	})
+	require.ErrorIs(t, sendErr, Rejected)
+	require.ErrorIs(t, receiveErr, InvalidOffset)
+}
+
+func TestTransferChunkSizeExceeded(t *testing.T) {
+	t.Parallel()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	sender, receiver, err := pair.New()
+	require.NoError(t, err)
+
+	receiverStreamCh := make(chan *frisbee.Stream, 1)
+	senderConn := frisbee.NewAsync(sender, emptyLogger, func(*frisbee.Stream) {})
+	receiverConn := frisbee.NewAsyncWithOptions(receiver, &frisbee.Options{Logger: emptyLogger, MaxContentLength: testChunkSize}, func(stream *frisbee.Stream) {
+		receiverStreamCh <- stream
+	})
+	defer func() {
+		_ = senderConn.Close()
+		_ = receiverConn.Close()
+	}()
+
+	source := createFile(t, "source", randomData(t, 512))
+	sendErrCh := make(chan error, 1)
+	go func() {
+		sendErrCh <- Send(senderConn.NewStream(0), "artifact", source, WithChunkSize(testChunkSize+1))
+	}()
+
+	var receiverStream *frisbee.Stream
+	select {
+	case receiverStream = <-receiverStreamCh:
+	case <-time.After(frisbee.DefaultDeadline):
+		t.Fatal("timed out waiting for receiver stream")
+	}
+
+	_, receiveErr := Receive(receiverStream, func(offer *Offer) (io.WriterAt, uint64, error) {
+		t.Error("accept should not be called for an offer with an invalid chunk size")
+		return nil, 0, nil
+	})
+	require.ErrorIs(t, receiveErr, InvalidChunkSize)
+
+	select {
+	case err = <-sendErrCh:
+		require.ErrorIs(t, err, Rejected)
+		assert.ErrorContains(t, err, InvalidChunkSize.Error())
+	case <-time.After(frisbee.DefaultDeadline):
+		t.Fatal("timed out waiting for sender")
+	}
+}
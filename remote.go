@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/loopholelabs/polyglot/v2"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+var (
+	// UnknownOperation is returned by RemoteError.Unwrap for the ErrorUnknownOperation code
+	UnknownOperation = errors.New("unknown operation")
+)
+
+// ErrorCode identifies the reason that an ERROR packet was sent in place of a response
+type ErrorCode uint16
+
+const (
+	// ErrorPanic is sent when the handler for a packet panicked (see SetPanicPolicy)
+	ErrorPanic = ErrorCode(iota + 1)
+
+	// ErrorUnknownOperation is sent when there is no handler for the packet's operation (see SetNotFoundHandler)
+	ErrorUnknownOperation
+)
+
+// RemoteError is the error for a packet whose handler on the peer failed, and is decoded from an ERROR packet
+type RemoteError struct {
+	// Id is the Id of the packet whose handler failed
+	Id uint16
+
+	// Code is the reason that the handler failed
+	Code ErrorCode
+
+	// Message describes the failure
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("remote error %d for packet %d: %s", e.Code, e.Id, e.Message)
+}
+
+// Unwrap returns the error that corresponds to the RemoteError's Code, so that errors.Is can be used to check
+// for specific failures (for example HandlerPanicked or UnknownOperation), or nil if the Code is unknown
+func (e *RemoteError) Unwrap() error {
+	switch e.Code {
+	case ErrorPanic:
+		return HandlerPanicked
+	case ErrorUnknownOperation:
+		return UnknownOperation
+	}
+	return nil
+}
+
+// RemoteErrorFromPacket returns the RemoteError for an ERROR packet, or nil if the packet is not an ERROR packet.
+// This is useful for Async connections, which receive ERROR packets from ReadPacket.
+func RemoteErrorFromPacket(p *packet.Packet) *RemoteError {
+	if p.Metadata.Operation != ERROR {
+		return nil
+	}
+	e := &RemoteError{
+		Id: p.Metadata.Id,
+	}
+	d := polyglot.Decoder(p.Content.Bytes())
+	code, err := d.Uint16()
+	if err == nil {
+		e.Code = ErrorCode(code)
+		e.Message, _ = d.String()
+	}
+	return e
+}
+
+// errorPacket returns an ERROR packet for the packet with the given Id
+func errorPacket(id uint16, code ErrorCode, message string) *packet.Packet {
+	p := packet.Get()
+	p.Metadata.Id = id
+	p.Metadata.Operation = ERROR
+	polyglot.Encoder(p.Content).Uint16(uint16(code)).String(message)
+	p.Metadata.ContentLength = uint32(p.Content.Len())
+	return p
+}
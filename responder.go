@@ -0,0 +1,153 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+var (
+	ResponderFinished    = errors.New("responder has already sent its final response")
+	ResponderUnavailable = errors.New("responder is not available for this connection")
+)
+
+// ResponderHandler is an alternative to a Handler that does not have to respond before it returns. Instead, it is
+// given a Responder that is bound to the connection and the incoming packet's Id, which can be used to send zero,
+// one or many responses from any goroutine, either before or after the ResponderHandler has returned.
+//
+// ResponderHandlers are added to a HandlerTable by wrapping them with Respond. The returned Action is used in the
+// same way as the Action returned by a Handler, and the incoming packet must not be used once the ResponderHandler
+// has returned, since it is returned to the packet pool. The context is canceled once the ResponderHandler returns
+// if a handler timeout is set (see Server.SetHandlerTimeout), so work that responds later should not depend on it.
+type ResponderHandler func(ctx context.Context, incoming *packet.Packet, responder *Responder) (action Action)
+
+// Respond returns a Handler that calls the given ResponderHandler with a Responder for each incoming packet,
+// so that it can be used in a HandlerTable
+func Respond(handler ResponderHandler) Handler {
+	return func(ctx context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		writer, _ := ctx.Value(responseWriterKey{}).(responseWriter)
+		return nil, handler(ctx, incoming, &Responder{
+			writer: writer,
+			id:     incoming.Metadata.Id,
+		})
+	}
+}
+
+// responseWriterKey is the context key for the responseWriter of the connection that a packet was received on
+type responseWriterKey struct{}
+
+// responseWriter writes the responses sent by a Responder to its connection
+type responseWriter interface {
+	// writeResponse writes a response packet, which must not have a reserved operation
+	writeResponse(p *packet.Packet) error
+
+	// writeFinal writes the FINAL packet for the packet with the given Id
+	writeFinal(id uint16) error
+}
+
+// withResponseWriter returns a copy of ctx that carries the given responseWriter for Respond
+func withResponseWriter(ctx context.Context, writer responseWriter) context.Context {
+	return context.WithValue(ctx, responseWriterKey{}, writer)
+}
+
+// Responder sends the responses for a single incoming packet, and is safe to use from multiple goroutines.
+//
+// Every response sent by the Responder has the same Id as the incoming packet, and the final response is
+// followed by a FINAL packet with the same Id, so that the peer knows that no more responses will be sent.
+type Responder struct {
+	mu       sync.Mutex
+	writer   responseWriter
+	id       uint16
+	finished bool
+}
+
+// Id returns the Id of the packet that the Responder is responding to
+func (r *Responder) Id() uint16 {
+	return r.id
+}
+
+// Send sends a partial response, which can be followed by any number of other responses before Finish is called.
+// The response's Id is set to the Id of the incoming packet, and its operation must not be a reserved value.
+//
+// The packet is not returned to the packet pool, and can be reused as soon as Send returns.
+func (r *Responder) Send(p *packet.Packet) error {
+	if p.Metadata.ContentLength != uint32(p.Content.Len()) {
+		return InvalidContentLength
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.finished {
+		return ResponderFinished
+	}
+	if r.writer == nil {
+		return ResponderUnavailable
+	}
+	p.Metadata.Id = r.id
+	return r.writer.writeResponse(p)
+}
+
+// Finish sends the final response followed by a FINAL packet, after which the Responder can no longer be used.
+// If p is nil, only the FINAL packet is sent, which can be used to finish without responding or after the last
+// response has already been sent with Send.
+//
+// The packet is not returned to the packet pool, and can be reused as soon as Finish returns.
+func (r *Responder) Finish(p *packet.Packet) error {
+	if p != nil && p.Metadata.ContentLength != uint32(p.Content.Len()) {
+		return InvalidContentLength
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.finished {
+		return ResponderFinished
+	}
+	if r.writer == nil {
+		return ResponderUnavailable
+	}
+	r.finished = true
+	if p != nil {
+		p.Metadata.Id = r.id
+		if err := r.writer.writeResponse(p); err != nil {
+			return err
+		}
+	}
+	return r.writer.writeFinal(r.id)
+}
+
+// Finished returns whether the Responder has sent its final response
+func (r *Responder) Finished() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.finished
+}
+
+// asyncResponseWriter is the responseWriter for a connection served by a Server
+type asyncResponseWriter struct {
+	conn     *Async
+	preWrite func()
+}
+
+func (w *asyncResponseWriter) writeResponse(p *packet.Packet) error {
+	w.preWrite()
+	return w.conn.WritePacket(p)
+}
+
+func (w *asyncResponseWriter) writeFinal(id uint16) error {
+	p := finalPacket(id)
+	w.preWrite()
+	err := w.conn.writePacket(p)
+	packet.Put(p)
+	return err
+}
+
+// finalPacket returns a FINAL packet for the packet with the given Id
+func finalPacket(id uint16) *packet.Packet {
+	p := packet.Get()
+	p.Metadata.Id = id
+	p.Metadata.Operation = FINAL
+	p.Metadata.ContentLength = 0
+	return p
+}
@@ -0,0 +1,179 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+	"github.com/loopholelabs/testing/conn/pair"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+func TestServerResponder(t *testing.T) {
+	t.Parallel()
+
+	const responses = 3
+
+	finished := make(chan error, 1)
+	serverHandlerTable := make(HandlerTable)
+	serverHandlerTable[metadata.PacketPing] = Respond(func(_ context.Context, incoming *packet.Packet, responder *Responder) (action Action) {
+		assert.Equal(t, incoming.Metadata.Id, responder.Id())
+		// The responses are sent after the handler has returned
+		go func() {
+			p := packet.Get()
+			p.Metadata.Operation = metadata.PacketPing
+			for i := 0; i < responses; i++ {
+				p.Content.Reset()
+				p.Content.Write([]byte{byte(i)})
+				p.Metadata.ContentLength = uint32(p.Content.Len())
+				if i == responses-1 {
+					assert.NoError(t, responder.Finish(p))
+				} else {
+					assert.NoError(t, responder.Send(p))
+				}
+			}
+			packet.Put(p)
+			assert.True(t, responder.Finished())
+			finished <- responder.Send(packet.Get())
+		}()
+		return
+	})
+	serverHandlerTable[metadata.PacketProbe] = Respond(func(_ context.Context, _ *packet.Packet, responder *Responder) (action Action) {
+		// Finishing without a response only sends the FINAL packet
+		assert.NoError(t, responder.Finish(nil))
+		return
+	})
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	s, err := NewServer(serverHandlerTable, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+
+	go s.ServeConn(serverConn)
+
+	c := NewAsync(clientConn, emptyLogger)
+
+	p := packet.Get()
+	p.Metadata.Id = 32
+	p.Metadata.Operation = metadata.PacketPing
+	err = c.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	for i := 0; i < responses; i++ {
+		p, err = c.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, uint16(32), p.Metadata.Id)
+		assert.Equal(t, metadata.PacketPing, p.Metadata.Operation)
+		assert.Equal(t, []byte{byte(i)}, p.Content.Bytes())
+		packet.Put(p)
+	}
+
+	p, err = c.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(32), p.Metadata.Id)
+	assert.Equal(t, FINAL, p.Metadata.Operation)
+	assert.Equal(t, uint32(0), p.Metadata.ContentLength)
+	packet.Put(p)
+
+	select {
+	case err := <-finished:
+		assert.ErrorIs(t, err, ResponderFinished)
+	case <-time.After(DefaultDeadline):
+		t.Fatal("timed out waiting for responder")
+	}
+
+	p = packet.Get()
+	p.Metadata.Id = 64
+	p.Metadata.Operation = metadata.PacketProbe
+	err = c.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	p, err = c.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(64), p.Metadata.Id)
+	assert.Equal(t, FINAL, p.Metadata.Operation)
+	packet.Put(p)
+
+	err = c.Close()
+	assert.NoError(t, err)
+	err = s.Shutdown()
+	assert.NoError(t, err)
+}
+
+func TestClientResponder(t *testing.T) {
+	t.Parallel()
+
+	clientHandlerTable := make(HandlerTable)
+	clientHandlerTable[metadata.PacketPing] = Respond(func(_ context.Context, incoming *packet.Packet, responder *Responder) (action Action) {
+		p := packet.Get()
+		p.Metadata.Operation = RESERVED9
+		assert.ErrorIs(t, responder.Send(p), InvalidOperation)
+		p.Metadata.Operation = metadata.PacketPing
+		assert.NoError(t, responder.Send(p))
+		assert.NoError(t, responder.Finish(nil))
+		packet.Put(p)
+		return
+	})
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	c, err := NewClient(clientHandlerTable, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	finals := make(chan uint16, 1)
+	c.SetFinalHandler(func(_ context.Context, id uint16) {
+		finals <- id
+	})
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+
+	err = c.FromConn(clientConn)
+	require.NoError(t, err)
+
+	server := NewAsync(serverConn, emptyLogger)
+
+	p := packet.Get()
+	p.Metadata.Id = 16
+	p.Metadata.Operation = metadata.PacketPing
+	err = server.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	p, err = server.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(16), p.Metadata.Id)
+	assert.Equal(t, metadata.PacketPing, p.Metadata.Operation)
+	packet.Put(p)
+
+	p, err = server.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(16), p.Metadata.Id)
+	assert.Equal(t, FINAL, p.Metadata.Operation)
+
+	// FINAL packets received by the client are passed to the final handler
+	err = server.writePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	select {
+	case id := <-finals:
+		assert.Equal(t, uint16(16), id)
+	case <-time.After(DefaultDeadline):
+		t.Fatal("timed out waiting for FINAL packet")
+	}
+
+	_ = c.Close()
+	_ = server.Close()
+}
@@ -0,0 +1,47 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const reusePortSupported = true
+
+// listenReusePort opens the given number of TCP listeners on the same address with SO_REUSEPORT set, so that
+// the kernel distributes incoming connections between them. If the address does not specify a port, the
+// port chosen for the first listener is used for all of them.
+func listenReusePort(addr string, shards int) ([]net.Listener, error) {
+	lc := net.ListenConfig{
+		Control: func(_, _ string, c syscall.RawConn) error {
+			var err error
+			controlErr := c.Control(func(fd uintptr) {
+				err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+			})
+			if controlErr != nil {
+				return controlErr
+			}
+			return err
+		},
+	}
+
+	listeners := make([]net.Listener, 0, shards)
+	for i := 0; i < shards; i++ {
+		listener, err := lc.Listen(context.Background(), "tcp", addr)
+		if err != nil {
+			for _, listener = range listeners {
+				_ = listener.Close()
+			}
+			return nil, err
+		}
+		if i == 0 {
+			addr = listener.Addr().String()
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
@@ -0,0 +1,86 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+	"github.com/loopholelabs/testing/conn"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+func TestServerAcceptShards(t *testing.T) {
+	t.Parallel()
+
+	const shards = 4
+	const clients = 32
+
+	serverHandlerTable := make(HandlerTable)
+	serverHandlerTable[metadata.PacketPing] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		outgoing = incoming
+		return
+	}
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	s, err := NewServer(serverHandlerTable, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	err = s.SetAcceptShards(shards)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		err := s.Start(conn.Listen)
+		require.NoError(t, err)
+		wg.Done()
+	}()
+
+	<-s.started()
+	require.Len(t, s.listeners, shards)
+	listenAddr := s.listener.Addr().String()
+	for _, listener := range s.listeners {
+		assert.Equal(t, listenAddr, listener.Addr().String())
+	}
+
+	var clientWg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		clientWg.Add(1)
+		go func(id uint16) {
+			defer clientWg.Done()
+			c, err := ConnectAsync(listenAddr, DefaultDeadline, emptyLogger, nil)
+			require.NoError(t, err)
+
+			p := packet.Get()
+			p.Metadata.Id = id
+			p.Metadata.Operation = metadata.PacketPing
+			p.Content.Write([]byte("shard"))
+			p.Metadata.ContentLength = uint32(p.Content.Len())
+			err = c.WritePacket(p)
+			require.NoError(t, err)
+			packet.Put(p)
+
+			p, err = c.ReadPacket()
+			require.NoError(t, err)
+			assert.Equal(t, id, p.Metadata.Id)
+			assert.Equal(t, []byte("shard"), p.Content.Bytes())
+			packet.Put(p)
+
+			err = c.Close()
+			assert.NoError(t, err)
+		}(uint16(i))
+	}
+	clientWg.Wait()
+
+	err = s.Shutdown()
+	assert.NoError(t, err)
+	wg.Wait()
+}
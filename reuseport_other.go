@@ -0,0 +1,15 @@
+//go:build !linux
+
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"net"
+)
+
+const reusePortSupported = false
+
+func listenReusePort(_ string, _ int) ([]net.Listener, error) {
+	return nil, ReusePortUnsupported
+}
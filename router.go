@@ -0,0 +1,184 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+)
+
+var (
+	RouterConflict   = errors.New("operation is already used by another handler table mounted on the router")
+	RouterOutOfRange = errors.New("operation is outside of the range that the handler table is mounted at")
+	RouterNameInUse  = errors.New("a handler table with the same name is already mounted on the router")
+	RouterNotMounted = errors.New("no handler table with the given name is mounted on the router")
+)
+
+// Router merges HandlerTables that are developed independently (for example, by different teams) into a single
+// HandlerTable for a Server or Client. Each HandlerTable is mounted under a unique name, and the operations in it
+// are relative to where it is mounted, so that they can be numbered from 0 without any coordination.
+//
+// Conflicting operations and reserved operations (0 to RESERVED9) are detected as soon as a HandlerTable is
+// mounted, and mounting it fails without changing the Router. Routers can also be mounted on other Routers.
+type Router struct {
+	handlerTable HandlerTable
+	owners       map[uint16]string
+	mounts       map[string]*mount
+}
+
+// mount is a HandlerTable (or Router) that is mounted on a Router
+type mount struct {
+	offset uint16
+
+	// ranges are the operation ranges that are reserved for the mount, so that other
+	// mounts cannot use any of the operations in them even if they have no handler
+	ranges []operationRange
+}
+
+// operationRange is an inclusive range of operations
+type operationRange struct {
+	start uint16
+	end   uint16
+}
+
+func (r operationRange) contains(operation uint16) bool {
+	return operation >= r.start && operation <= r.end
+}
+
+func (r operationRange) overlaps(other operationRange) bool {
+	return r.start <= other.end && other.start <= r.end
+}
+
+// NewRouter returns a new Router with nothing mounted on it
+func NewRouter() *Router {
+	return &Router{
+		handlerTable: make(HandlerTable),
+		owners:       make(map[uint16]string),
+		mounts:       make(map[string]*mount),
+	}
+}
+
+// Mount mounts the HandlerTable at the given offset, so that operation n in the HandlerTable is handled
+// as operation offset+n. Only the operations that have a handler are used by the mount.
+func (r *Router) Mount(name string, offset uint16, handlerTable HandlerTable) error {
+	return r.mount(name, offset, handlerTable, nil)
+}
+
+// MountRange mounts the HandlerTable at the inclusive range of operations from start to end, so that operation n
+// in the HandlerTable is handled as operation start+n. The whole range is reserved for the HandlerTable, even for
+// operations that have no handler, so that it can add handlers later without conflicting with other mounts, and
+// RouterOutOfRange is returned if the HandlerTable has an operation that does not fit in the range.
+func (r *Router) MountRange(name string, start uint16, end uint16, handlerTable HandlerTable) error {
+	if end < start {
+		return fmt.Errorf("%w: range %d-%d of %q is empty", RouterOutOfRange, start, end, name)
+	}
+	for relative := range handlerTable {
+		if relative > end-start {
+			return fmt.Errorf("%w: operation %d of %q does not fit in range %d-%d", RouterOutOfRange, relative, name, start, end)
+		}
+	}
+	return r.mount(name, start, handlerTable, []operationRange{{start: start, end: end}})
+}
+
+// MountRouter mounts every HandlerTable that is mounted on the given Router at the given offset, keeping the
+// ranges that were reserved with MountRange (moved by the offset). Later changes to the given Router have no effect.
+func (r *Router) MountRouter(name string, offset uint16, router *Router) error {
+	var ranges []operationRange
+	for _, m := range router.mounts {
+		for _, reserved := range m.ranges {
+			if int(reserved.end)+int(offset) > math.MaxUint16 {
+				return fmt.Errorf("%w: range %d-%d of %q does not fit at offset %d", RouterOutOfRange, reserved.start, reserved.end, name, offset)
+			}
+			ranges = append(ranges, operationRange{start: reserved.start + offset, end: reserved.end + offset})
+		}
+	}
+	return r.mount(name, offset, router.handlerTable, ranges)
+}
+
+// mount adds the handlers from the HandlerTable to the Router (at the offset) and reserves the given ranges,
+// once it has checked that none of them conflict with the existing mounts or use reserved operations
+func (r *Router) mount(name string, offset uint16, handlerTable HandlerTable, ranges []operationRange) error {
+	if _, ok := r.mounts[name]; ok {
+		return fmt.Errorf("%w: %q", RouterNameInUse, name)
+	}
+
+	for _, reserved := range ranges {
+		if reserved.start <= RESERVED9 {
+			return fmt.Errorf("%w: range %d-%d of %q includes reserved operations", InvalidHandlerTable, reserved.start, reserved.end, name)
+		}
+		for other, m := range r.mounts {
+			for _, otherReserved := range m.ranges {
+				if reserved.overlaps(otherReserved) {
+					return fmt.Errorf("%w: range %d-%d of %q overlaps range %d-%d of %q", RouterConflict, reserved.start, reserved.end, name, otherReserved.start, otherReserved.end, other)
+				}
+			}
+		}
+		for operation, other := range r.owners {
+			if reserved.contains(operation) {
+				return fmt.Errorf("%w: range %d-%d of %q includes operation %d of %q", RouterConflict, reserved.start, reserved.end, name, operation, other)
+			}
+		}
+	}
+
+	handlers := make(HandlerTable, len(handlerTable))
+	for relative, handler := range handlerTable {
+		if int(relative)+int(offset) > math.MaxUint16 {
+			return fmt.Errorf("%w: operation %d of %q does not fit at offset %d", RouterOutOfRange, relative, name, offset)
+		}
+		operation := relative + offset
+		if operation <= RESERVED9 {
+			return fmt.Errorf("%w: operation %d of %q is reserved", InvalidHandlerTable, operation, name)
+		}
+		if other, ok := r.owners[operation]; ok {
+			return fmt.Errorf("%w: operation %d of %q is also used by %q", RouterConflict, operation, name, other)
+		}
+		for other, m := range r.mounts {
+			if inRanges(m.ranges, operation) {
+				return fmt.Errorf("%w: operation %d of %q is in the range of %q", RouterConflict, operation, name, other)
+			}
+		}
+		handlers[operation] = handler
+	}
+
+	for operation, handler := range handlers {
+		r.handlerTable[operation] = handler
+		r.owners[operation] = name
+	}
+	r.mounts[name] = &mount{
+		offset: offset,
+		ranges: ranges,
+	}
+	return nil
+}
+
+// inRanges returns whether the operation is in any of the ranges
+func inRanges(ranges []operationRange, operation uint16) bool {
+	for _, reserved := range ranges {
+		if reserved.contains(operation) {
+			return true
+		}
+	}
+	return false
+}
+
+// Operation returns the operation that operation n of the HandlerTable (or Router) mounted with the given name
+// is handled as, which can be used by clients to send packets to it, or RouterNotMounted if nothing is mounted
+// with that name
+func (r *Router) Operation(name string, n uint16) (uint16, error) {
+	m, ok := r.mounts[name]
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", RouterNotMounted, name)
+	}
+	if int(n)+int(m.offset) > math.MaxUint16 {
+		return 0, fmt.Errorf("%w: operation %d of %q does not fit at offset %d", RouterOutOfRange, n, name, m.offset)
+	}
+	return m.offset + n, nil
+}
+
+// HandlerTable returns a copy of the merged HandlerTable of everything mounted on the Router,
+// which can be used with NewServer, NewClient or SetHandlerTable
+func (r *Router) HandlerTable() HandlerTable {
+	return maps.Clone(r.handlerTable)
+}
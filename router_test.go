@@ -0,0 +1,143 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+	"github.com/loopholelabs/testing/conn/pair"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+func TestRouter(t *testing.T) {
+	t.Parallel()
+
+	handler := versionHandler("", nil, nil)
+
+	r := NewRouter()
+	err := r.Mount("users", 16, HandlerTable{0: handler, 1: handler})
+	require.NoError(t, err)
+
+	err = r.MountRange("billing", 32, 47, HandlerTable{0: handler})
+	require.NoError(t, err)
+
+	// Operations that are already used by another mount conflict
+	err = r.Mount("conflict", 17, HandlerTable{0: handler})
+	assert.ErrorIs(t, err, RouterConflict)
+
+	// Operations in a reserved range conflict even if they have no handler
+	err = r.Mount("conflict", 40, HandlerTable{0: handler})
+	assert.ErrorIs(t, err, RouterConflict)
+
+	err = r.MountRange("conflict", 44, 50, HandlerTable{})
+	assert.ErrorIs(t, err, RouterConflict)
+
+	err = r.MountRange("conflict", 10, 16, HandlerTable{})
+	assert.ErrorIs(t, err, RouterConflict)
+
+	// Reserved operations are rejected
+	err = r.Mount("conflict", 0, HandlerTable{RESERVED9: handler})
+	assert.ErrorIs(t, err, InvalidHandlerTable)
+
+	err = r.MountRange("conflict", RESERVED9, 12, HandlerTable{})
+	assert.ErrorIs(t, err, InvalidHandlerTable)
+
+	// Operations must fit in the range and must not overflow
+	err = r.MountRange("conflict", 64, 65, HandlerTable{2: handler})
+	assert.ErrorIs(t, err, RouterOutOfRange)
+
+	err = r.MountRange("conflict", 65, 64, HandlerTable{})
+	assert.ErrorIs(t, err, RouterOutOfRange)
+
+	err = r.Mount("conflict", math.MaxUint16, HandlerTable{1: handler})
+	assert.ErrorIs(t, err, RouterOutOfRange)
+
+	err = r.Mount("users", 64, HandlerTable{0: handler})
+	assert.ErrorIs(t, err, RouterNameInUse)
+
+	// Failed mounts do not change the router
+	_, err = r.Operation("conflict", 0)
+	assert.ErrorIs(t, err, RouterNotMounted)
+
+	handlerTable := r.HandlerTable()
+	assert.Len(t, handlerTable, 3)
+	assert.Contains(t, handlerTable, uint16(16))
+	assert.Contains(t, handlerTable, uint16(17))
+	assert.Contains(t, handlerTable, uint16(32))
+
+	operation, err := r.Operation("billing", 3)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(35), operation)
+
+	// Routers can be mounted on other routers, keeping their reserved ranges
+	root := NewRouter()
+	err = root.MountRouter("api", 100, r)
+	require.NoError(t, err)
+
+	err = root.Mount("conflict", 140, HandlerTable{0: handler})
+	assert.ErrorIs(t, err, RouterConflict)
+
+	err = root.Mount("admin", 200, HandlerTable{0: handler})
+	require.NoError(t, err)
+
+	handlerTable = root.HandlerTable()
+	assert.Len(t, handlerTable, 4)
+	assert.Contains(t, handlerTable, uint16(116))
+	assert.Contains(t, handlerTable, uint16(117))
+	assert.Contains(t, handlerTable, uint16(132))
+	assert.Contains(t, handlerTable, uint16(200))
+
+	operation, err = root.Operation("api", 16)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(116), operation)
+}
+
+func TestRouterServer(t *testing.T) {
+	t.Parallel()
+
+	r := NewRouter()
+	err := r.Mount("v1", 16, HandlerTable{0: versionHandler("v1", nil, nil)})
+	require.NoError(t, err)
+	err = r.Mount("v2", 32, HandlerTable{0: versionHandler("v2", nil, nil)})
+	require.NoError(t, err)
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	s, err := NewServer(r.HandlerTable(), context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+
+	go s.ServeConn(serverConn)
+
+	c := NewAsync(clientConn, emptyLogger)
+
+	for _, version := range []string{"v1", "v2"} {
+		operation, err := r.Operation(version, 0)
+		require.NoError(t, err)
+
+		p := packet.Get()
+		p.Metadata.Operation = operation
+		err = c.WritePacket(p)
+		require.NoError(t, err)
+		packet.Put(p)
+
+		p, err = c.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, operation, p.Metadata.Operation)
+		assert.Equal(t, version, string(p.Content.Bytes()))
+		packet.Put(p)
+	}
+
+	err = c.Close()
+	assert.NoError(t, err)
+	err = s.Shutdown()
+	assert.NoError(t, err)
+}
@@ -6,7 +6,12 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"fmt"
+	"io"
+	"maps"
 	"net"
+	"runtime"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -19,6 +24,11 @@ var (
 	OnClosedNil = errors.New("OnClosed function cannot be nil")
 	PreWriteNil = errors.New("PreWrite function cannot be nil")
 	ListenerNil = errors.New("listener cannot be nil")
+	ExecutorNil = errors.New("executor cannot be nil")
+
+	EventLoopUnsupported  = errors.New("event loop is not supported on this platform")
+	EventLoopIncompatible = errors.New("server settings are not supported by the event loop")
+	ReusePortUnsupported  = errors.New("SO_REUSEPORT sharding is not supported on this platform")
 )
 
 var (
@@ -33,16 +43,43 @@ var (
 
 // Server accepts connections from frisbee Clients and can send and receive frisbee Packets
 type Server struct {
-	listener      net.Listener
-	handlerTable  HandlerTable
-	shutdown      atomic.Bool
-	options       *Options
-	wg            sync.WaitGroup
-	connections   map[*Async]struct{}
-	connectionsMu sync.Mutex
-	startedCh     chan struct{}
-	concurrency   uint64
-	limiter       chan struct{}
+	listener       net.Listener
+	listeners      []net.Listener
+	acceptShards   int
+	handlers       atomic.Pointer[handlerTables]
+	handlersMu     sync.Mutex
+	operations     *operationNumbers
+	batchHandlers  map[uint16]batchOperation
+	actionHandlers actionHandlers
+	notFound       *notFound
+	shutdown       atomic.Bool
+	options        *Options
+	wg             sync.WaitGroup
+	connections    map[io.Closer]struct{}
+	connectionsMu  sync.Mutex
+	startedCh      chan struct{}
+	executor       Executor
+	limiter        *limiter
+	orderer        *orderer
+	timeouts       *timeouts
+	panicPolicy    PanicPolicy
+	panics         atomic.Uint64
+	overload       *overload
+	inFlight       atomic.Uint64
+	shed           atomic.Uint64
+
+	interceptors            []Interceptor
+	streamOpenInterceptors  []StreamOpenInterceptor
+	streamCloseInterceptors []StreamCloseInterceptor
+
+	eventLoopWorkers int
+	eventLoop        *eventLoop
+
+	// customExecutor, customOnClosed and customStreamHandler record which of the settings
+	// that are not supported by the event loop have been changed from their defaults
+	customExecutor      bool
+	customOnClosed      bool
+	customStreamHandler bool
 
 	baseContext       context.Context
 	baseContextCancel context.CancelFunc
@@ -82,13 +119,16 @@ func NewServer(handlerTable HandlerTable, ctx context.Context, opts ...Option) (
 
 	s := &Server{
 		options:           options,
-		connections:       make(map[*Async]struct{}),
+		connections:       make(map[io.Closer]struct{}),
 		startedCh:         make(chan struct{}),
 		baseContext:       baseContext,
 		baseContextCancel: baseContextCancel,
 		onClosed:          defaultOnClosed,
 		preWrite:          defaultPreWrite,
 		streamHandler:     defaultStreamHandler,
+		executor:          NewGoroutineExecutor(0),
+		notFound:          newNotFound(),
+		operations:        newOperationNumbers(),
 	}
 
 	return s, s.SetHandlerTable(handlerTable)
@@ -100,6 +140,7 @@ func (s *Server) SetOnClosed(f func(*Async, error)) error {
 		return OnClosedNil
 	}
 	s.onClosed = f
+	s.customOnClosed = true
 	return nil
 }
 
@@ -119,30 +160,165 @@ func (s *Server) SetStreamHandler(f func(context.Context, *Stream)) error {
 		if s.StreamContext != nil {
 			streamCtx = s.StreamContext(streamCtx, stream)
 		}
-		f(streamCtx, stream)
+		handleStream(streamCtx, stream, f, s.streamOpenInterceptors, s.streamCloseInterceptors)
 	}
+	s.customStreamHandler = true
 	return nil
 }
 
-// SetHandlerTable sets the handler table for the server.
+// SetInterceptors sets the chain of Interceptors that wraps the handler for every incoming packet, replacing
+// any Interceptors that were set before. The first Interceptor is the outermost one, and is called first.
+// Interceptors are kept when the handler table is replaced with SetHandlerTable.
 //
 // This function should not be called once the server has started.
+func (s *Server) SetInterceptors(interceptors ...Interceptor) {
+	s.interceptors = interceptors
+	s.handlers.Store(s.handlers.Load().withInterceptors(interceptors))
+}
+
+// SetStreamInterceptors sets the StreamOpenInterceptors that are called (in order) whenever a new stream is opened
+// by a client, and the StreamCloseInterceptors that are called once such a stream has been closed, replacing any
+// that were set before. They are only called for streams handled by the stream handler set with SetStreamHandler.
+//
+// This function should not be called once the server has started.
+func (s *Server) SetStreamInterceptors(open []StreamOpenInterceptor, close []StreamCloseInterceptor) {
+	s.streamOpenInterceptors = open
+	s.streamCloseInterceptors = close
+}
+
+// SetHandlerTable sets the handler table for the server, and returns InvalidHandlerTable without changing it
+// if the handler table uses a reserved operation, or an operation from NamedOperationStart onwards while the
+// server has a NamedHandlerTable.
+//
+// This function can be called while the server is running to add, remove or replace handlers without closing any
+// connections. The handler table is copied, and the copy is swapped in atomically, so packets are handled by
+// either the old or the new handler table, and handlers that are already running when it is swapped finish as
+// they would have with the old handler table. Later changes to the given handler table have no effect.
 func (s *Server) SetHandlerTable(handlerTable HandlerTable) error {
-	for i := uint16(0); i < RESERVED9; i++ {
-		if _, ok := handlerTable[i]; ok {
-			return InvalidHandlerTable
+	s.handlersMu.Lock()
+	defer s.handlersMu.Unlock()
+	var named *namedHandlers
+	if current := s.handlers.Load(); current != nil {
+		named = current.named
+	}
+	handlers, err := newHandlerTables(handlerTable, named, s.interceptors)
+	if err != nil {
+		return err
+	}
+	s.handlers.Store(handlers)
+	return nil
+}
+
+// SetNamedHandlerTable sets the NamedHandlerTable for the server, whose handlers are registered by name instead
+// of by operation. Each name is given an operation from NamedOperationStart onwards, which Clients learn while
+// connecting (see Client.SetOperationNames), and keeps it for the lifetime of the server, even if the
+// NamedHandlerTable is replaced, so that clients never reach a different handler with an operation they
+// negotiated earlier. If namedHandlerTable is nil, the named handlers are removed.
+//
+// InvalidOperationName is returned if a name is empty, InvalidHandlerTable is returned if the server's
+// HandlerTable uses an operation from NamedOperationStart onwards, and OperationNamesExhausted is returned
+// if there are no operations left for new names. The server's handler tables are not changed if an error
+// is returned.
+//
+// This function can be called while the server is running, in the same way as SetHandlerTable.
+func (s *Server) SetNamedHandlerTable(namedHandlerTable NamedHandlerTable) error {
+	s.handlersMu.Lock()
+	defer s.handlersMu.Unlock()
+	var named *namedHandlers
+	if namedHandlerTable != nil {
+		// The handler table is checked before any names are given operations, since they keep them permanently
+		if err := validateHandlerTable(s.handlers.Load().table, &namedHandlers{}); err != nil {
+			return err
 		}
+		var err error
+		named, err = s.operations.assign(namedHandlerTable)
+		if err != nil {
+			return err
+		}
+	}
+	handlers, err := newHandlerTables(s.handlers.Load().table, named, s.interceptors)
+	if err != nil {
+		return err
 	}
+	s.handlers.Store(handlers)
+	return nil
+}
 
-	s.handlerTable = handlerTable
+// Operations returns the operation that was given to each name in the server's NamedHandlerTable
+func (s *Server) Operations() map[string]uint16 {
+	return maps.Clone(s.handlers.Load().operations())
+}
+
+// SetBatchHandler sets the BatchHandler for the given operation, which is called with batches of up to limit packets
+// (or DefaultBatchLimit packets if limit is 0) that have already been received for the operation on a connection,
+// instead of calling a Handler for each packet. Packets for the operation are handled by the BatchHandler even
+// if the HandlerTable also has a Handler for it, and if handler is nil, the operation's BatchHandler is removed.
+//
+// Each batch counts as a single task for the Executor and any concurrency limits, and BatchHandlers are not
+// wrapped by Interceptors or affected by the PacketContext, SetOrderingKey or handler timeouts. Panics in a
+// BatchHandler are handled according to the PanicPolicy, with an ERROR packet sent for every packet in the batch
+// if the policy is PanicRespond. Connections served by the event loop (see SetEventLoop) batch the packets that
+// are received by a single read from the connection.
+//
+// This function should not be called once the server has started.
+func (s *Server) SetBatchHandler(operation uint16, limit int, handler BatchHandler) error {
+	if operation <= RESERVED9 {
+		return InvalidOperation
+	}
+	if handler == nil {
+		delete(s.batchHandlers, operation)
+		return nil
+	}
+	if limit <= 0 {
+		limit = DefaultBatchLimit
+	}
+	if s.batchHandlers == nil {
+		s.batchHandlers = make(map[uint16]batchOperation)
+	}
+	s.batchHandlers[operation] = batchOperation{handler: handler, limit: limit}
 	return nil
 }
 
-// GetHandlerTable gets the handler table for the server.
+// SetActionHandler sets the ActionHandler for a custom Action, which is called whenever a handler returns that
+// Action. If handler is nil, the ActionHandler for the Action is removed, and custom actions without an ActionHandler
+// are ignored. InvalidAction is returned if action is one of the built-in actions.
+//
+// This function should not be called once the server has started.
+func (s *Server) SetActionHandler(action Action, handler ActionHandler) error {
+	return s.actionHandlers.set(action, handler)
+}
+
+// SetNotFoundHandler sets the Handler that is called for packets whose operation has no handler in the HandlerTable
+// (and no BatchHandler), which can be used to forward them elsewhere or to respond with a custom error. If handler
+// is nil, which is the default, the packets are dropped (see SetUnknownOperationErrors). The NotFound handler is not
+// wrapped by Interceptors, and is never called for packets with a reserved operation, which are always dropped.
+//
+// This function should not be called once the server has started.
+func (s *Server) SetNotFoundHandler(handler Handler) {
+	s.notFound.handler = handler
+}
+
+// SetUnknownOperationErrors sets whether an ERROR packet with the ErrorUnknownOperation code is sent in place of a
+// response for packets whose operation has no handler (when there is no NotFound handler), so that clients learn
+// straight away that the server does not support the operation instead of waiting for a response that will never
+// arrive. This is disabled by default, since clients that are older than the ERROR packet receive it from
+// Async.ReadPacket (or drop it, if they use a Client) as a packet with the operation 5, which used to be reserved.
 //
 // This function should not be called once the server has started.
+func (s *Server) SetUnknownOperationErrors(enabled bool) {
+	s.notFound.errors = enabled
+}
+
+// UnknownOperations returns the number of packets that have been received for each operation that had no
+// handler, including packets with a reserved operation that the server does not handle
+func (s *Server) UnknownOperations() map[uint16]uint64 {
+	return s.notFound.totals()
+}
+
+// GetHandlerTable gets the handler table for the server, which must not be modified (SetHandlerTable should be
+// used to change it instead).
 func (s *Server) GetHandlerTable() HandlerTable {
-	return s.handlerTable
+	return s.handlers.Load().table
 }
 
 // SetConcurrency sets the maximum number of concurrent goroutines that will be created
@@ -154,10 +330,309 @@ func (s *Server) GetHandlerTable() HandlerTable {
 //
 // This function should not be called once the server has started.
 func (s *Server) SetConcurrency(concurrency uint64) {
-	s.concurrency = concurrency
-	if s.concurrency > 1 {
-		s.limiter = make(chan struct{}, s.concurrency)
+	if concurrency == 1 {
+		s.executor = NewInlineExecutor()
+	} else {
+		s.executor = NewGoroutineExecutor(concurrency)
+	}
+	s.customExecutor = concurrency > 1
+}
+
+// SetExecutor sets the Executor that will be used by the server to run the handlers for incoming packets,
+// replacing any concurrency set with SetConcurrency. By default, every packet is handled in its own goroutine.
+//
+// This function should not be called once the server has started.
+func (s *Server) SetExecutor(executor Executor) error {
+	if executor == nil {
+		return ExecutorNil
+	}
+	s.executor = executor
+	s.customExecutor = true
+	return nil
+}
+
+// SetAdaptiveConcurrency configures the server to limit the number of packets that can be handled at the same time
+// (across all connections) using an AdaptiveExecutor, which adjusts the limit automatically based on the observed
+// latency of the handlers and the time packets spend waiting for the limit, instead of the static limit set with
+// SetConcurrency. The current limit can be read with ConcurrencyLimit.
+//
+// This function should not be called once the server has started.
+func (s *Server) SetAdaptiveConcurrency(options AdaptiveOptions) {
+	s.executor = NewAdaptiveExecutor(options)
+	s.customExecutor = true
+}
+
+// ConcurrencyLimit returns the current concurrency limit if the server is using an AdaptiveExecutor
+// (see SetAdaptiveConcurrency), and 0 otherwise
+func (s *Server) ConcurrencyLimit() uint64 {
+	if e, ok := s.executor.(*AdaptiveExecutor); ok {
+		return e.Limit()
+	}
+	return 0
+}
+
+// SetOperationConcurrency limits the number of packets with the given operation that can be handled at the
+// same time (across all connections), in addition to the limit set with SetConcurrency. Packets that cannot
+// be handled because the limit has been reached are queued until a handler for the operation completes,
+// without blocking the handling of packets for other operations. Setting the concurrency to 0 removes the limit.
+//
+// Since queued packets are handled once the limit allows it, packets from the same connection may be handled
+// out of order when limits are used, even if the Executor would otherwise handle them in order.
+//
+// This function should not be called once the server has started.
+func (s *Server) SetOperationConcurrency(operation uint16, concurrency uint64) {
+	if s.limiter == nil {
+		s.limiter = newLimiter()
+	}
+	s.limiter.setOperation(operation, concurrency)
+}
+
+// SetConnectionConcurrency limits the number of packets from each connection that can be handled at the same time,
+// in addition to the limit set with SetConcurrency and any operation limits set with SetOperationConcurrency.
+// Packets that cannot be handled because the limit has been reached are queued until a handler for the connection
+// completes. Setting the concurrency to 0 removes the limit.
+//
+// This function should not be called once the server has started.
+func (s *Server) SetConnectionConcurrency(concurrency uint64) {
+	if s.limiter == nil {
+		s.limiter = newLimiter()
+	}
+	s.limiter.setConnection(concurrency)
+}
+
+// Queued returns the number of packets that are currently queued because an operation
+// or connection concurrency limit has been reached
+func (s *Server) Queued() uint64 {
+	if s.limiter == nil {
+		return 0
+	}
+	return s.limiter.queuedTotal()
+}
+
+// QueuedOperation returns the number of packets with the given operation that are
+// currently queued because the operation's concurrency limit has been reached
+func (s *Server) QueuedOperation(operation uint16) uint64 {
+	if s.limiter == nil {
+		return 0
+	}
+	return s.limiter.queuedOperation(operation)
+}
+
+// QueuedConnection returns the number of packets from the given connection that are
+// currently queued because the connection concurrency limit has been reached
+func (s *Server) QueuedConnection(conn *Async) uint64 {
+	if s.limiter == nil {
+		return 0
+	}
+	return s.limiter.queuedConnection(conn)
+}
+
+// SetOrderingKey configures the server to handle packets from the same connection that have the same key in the
+// order that they were received, one at a time, while packets with different keys are still handled concurrently
+// by the Executor. The key for each packet is returned by the given function, and if it is nil, packets are
+// ordered by their Metadata.Id.
+//
+// Ordering only applies to packets from the same connection, and is kept even if an operation
+// or connection concurrency limit (see SetOperationConcurrency) causes packets to be queued.
+//
+// This function should not be called once the server has started.
+func (s *Server) SetOrderingKey(key func(*packet.Packet) uint64) {
+	s.orderer = newOrderer(key)
+}
+
+// QueuedOrdered returns the number of packets that are currently waiting for an
+// earlier packet with the same ordering key (see SetOrderingKey) to be handled
+func (s *Server) QueuedOrdered() uint64 {
+	if s.orderer == nil {
+		return 0
+	}
+	return s.orderer.queuedTotal()
+}
+
+// SetHandlerTimeout sets the default timeout for the handlers of incoming packets, which is used for every operation
+// that does not have its own timeout set with SetOperationTimeout. The handler's context is canceled once the timeout
+// expires, and if the handler has not returned by then, a TIMEOUT packet with the same Id is sent back to the peer
+// in place of its response and the connection moves on to the next packet without waiting for the handler.
+// Whatever the handler returns after timing out is discarded. A timeout of 0 disables the default timeout.
+//
+// Since handlers that time out keep running in the background, they must return once their context is canceled.
+// SetWatchdog can be used to find handlers that do not.
+//
+// This function should not be called once the server has started.
+func (s *Server) SetHandlerTimeout(timeout time.Duration) {
+	if s.timeouts == nil {
+		s.timeouts = newTimeouts()
+	}
+	s.timeouts.setTimeout(timeout)
+}
+
+// SetOperationTimeout sets the timeout for the handler of the given operation, overriding the default timeout set
+// with SetHandlerTimeout. A timeout of 0 removes the operation's timeout so that the default timeout is used again.
+//
+// This function should not be called once the server has started.
+func (s *Server) SetOperationTimeout(operation uint16, timeout time.Duration) {
+	if s.timeouts == nil {
+		s.timeouts = newTimeouts()
+	}
+	s.timeouts.setOperation(operation, timeout)
+}
+
+// SetWatchdog sets the hard limit for handlers that have timed out (see SetHandlerTimeout). If a handler that has
+// timed out has still not returned once hardLimit has passed since it was started, it is assumed to be stuck and
+// an error is logged with its stack trace. A hard limit of 0 disables the watchdog, and it is also disabled for
+// operations whose timeout is not shorter than the hard limit.
+//
+// This function should not be called once the server has started.
+func (s *Server) SetWatchdog(hardLimit time.Duration) {
+	if s.timeouts == nil {
+		s.timeouts = newTimeouts()
+	}
+	s.timeouts.setHardLimit(hardLimit)
+}
+
+// TimedOut returns the total number of handlers that have timed out
+func (s *Server) TimedOut() uint64 {
+	return s.timeouts.timedOutTotal()
+}
+
+// Stuck returns the number of handlers that have exceeded the watchdog's hard limit (see SetWatchdog)
+// and have still not returned
+func (s *Server) Stuck() uint64 {
+	return s.timeouts.stuckTotal()
+}
+
+// SetPanicPolicy sets what happens to a connection when one of its handlers panics. Panics in handlers are always
+// recovered so that they cannot crash the process, and are logged (with their stack trace) and counted. By default,
+// the connection is closed and the *PanicError is passed to the OnClosed function.
+//
+// This function should not be called once the server has started.
+func (s *Server) SetPanicPolicy(policy PanicPolicy) {
+	s.panicPolicy = policy
+}
+
+// Panics returns the total number of panics that have been recovered from handlers
+func (s *Server) Panics() uint64 {
+	return s.panics.Load()
+}
+
+// SetOverloadPolicy configures the server to shed incoming packets once it is overloaded, according to the given
+// OverloadPolicy, instead of letting them pile up. Shed packets are not handled, and an OVERLOADED packet with the
+// same Id is sent back to the client instead so that it can retry the packet later or elsewhere. Clients receive
+// these as an *OverloadedError (see Client.SetOverloadedHandler).
+//
+// The operation priorities set with SetOperationPriority decide which packets are shed first.
+//
+// This function should not be called once the server has started.
+func (s *Server) SetOverloadPolicy(policy OverloadPolicy) {
+	if s.overload == nil {
+		s.overload = newOverload()
+	}
+	s.overload.setPolicy(policy)
+}
+
+// SetOperationPriority sets the Priority of packets with the given operation, which decides how early they are
+// shed by the OverloadPolicy set with SetOverloadPolicy. Operations have PriorityNormal unless this is used.
+//
+// This function should not be called once the server has started.
+func (s *Server) SetOperationPriority(operation uint16, priority Priority) {
+	if s.overload == nil {
+		s.overload = newOverload()
+	}
+	s.overload.setPriority(operation, priority)
+}
+
+// InFlight returns the number of packets that have been read from a connection but whose handlers have not yet completed
+func (s *Server) InFlight() uint64 {
+	return s.inFlight.Load()
+}
+
+// Shed returns the total number of packets that have been shed because of the OverloadPolicy
+func (s *Server) Shed() uint64 {
+	return s.shed.Load()
+}
+
+// SetEventLoop configures the server to serve plain TCP connections using an epoll-based event loop
+// instead of creating an Async connection (and its goroutines and buffers) for every connection, which
+// significantly reduces the memory and scheduling overhead of serving large numbers of mostly-idle connections.
+//
+// The event loop multiplexes connections onto the given number of worker goroutines (or runtime.NumCPU() if
+// workers is 0), and handlers are run directly on the worker goroutines, so packets from each connection are
+// handled one at a time and in order (as with a concurrency of 1), and a blocked handler will block every other
+// connection on the same worker. Buffers are only allocated for a connection while it has a partially
+// received packet or data that could not be written immediately.
+//
+// Since there is no Async connection for the connections served by the event loop, it cannot be used together with
+// settings that need one or that change how handlers are run: an Executor (other than a concurrency of 1),
+// operation or connection concurrency limits, handler timeouts, an OverloadPolicy, a stream handler, or the
+// ConnContext, UpdateContext or onClosed functions. EventLoopIncompatible is returned (listing the settings) by
+// SetEventLoop and Start if any of these are used, and streams opened by clients are closed straight away, as they are
+// by the default stream handler. ActionHandlers are called with a nil connection, and a Responder (see Respond) can
+// only be used before its ResponderHandler returns, and returns ResponderUnavailable after. Connections that are not
+// plain TCP connections (such as TLS connections) are served as usual.
+//
+// As with Async connections, a connection served by the event loop is sent a PING if nothing has been read from it
+// for the PingInterval, and is closed if nothing has been read from it (or none of its unwritten data could be
+// written) for the Deadline. Since these are checked by the worker goroutines, handlers must not block for longer
+// than the PingInterval, otherwise the connections on the same worker are not pinged in time and the deadlines of
+// every connection on the worker are restarted once the handler returns.
+//
+// The event loop is only supported on Linux, and EventLoopUnsupported is returned on other platforms.
+//
+// This function should not be called once the server has started.
+func (s *Server) SetEventLoop(workers int) error {
+	if !eventLoopSupported {
+		return EventLoopUnsupported
+	}
+	if workers <= 0 {
+		workers = runtime.NumCPU()
 	}
+	s.eventLoopWorkers = workers
+	return s.eventLoopCompatible()
+}
+
+// eventLoopCompatible returns EventLoopIncompatible (listing the settings) if the server uses any settings that
+// are not supported by the event loop
+func (s *Server) eventLoopCompatible() error {
+	var incompatible []string
+	for _, setting := range []struct {
+		name string
+		used bool
+	}{
+		{"Executor", s.customExecutor},
+		{"concurrency limits", s.limiter != nil},
+		{"handler timeouts", s.timeouts != nil},
+		{"OverloadPolicy", s.overload != nil},
+		{"stream handler", s.customStreamHandler},
+		{"ConnContext", s.ConnContext != nil},
+		{"UpdateContext", s.UpdateContext != nil},
+		{"onClosed", s.customOnClosed},
+	} {
+		if setting.used {
+			incompatible = append(incompatible, setting.name)
+		}
+	}
+	if len(incompatible) > 0 {
+		return fmt.Errorf("%w: %s", EventLoopIncompatible, strings.Join(incompatible, ", "))
+	}
+	return nil
+}
+
+// SetAcceptShards configures the server to open the given number of listeners on the same address using
+// SO_REUSEPORT when Start is called, and to run a separate accept loop for each of them. The kernel then
+// spreads incoming connections between the listeners, so that accepting connections is no longer a bottleneck
+// when large numbers of clients connect at the same time. All the shards share the server's connections
+// and are closed by Shutdown.
+//
+// Sharding is only supported on Linux, and ReusePortUnsupported is returned on other platforms. It is
+// not used by StartWithListener, since the server does not control how the listener was created.
+//
+// This function should not be called once the server has started.
+func (s *Server) SetAcceptShards(shards int) error {
+	if !reusePortSupported {
+		return ReusePortUnsupported
+	}
+	s.acceptShards = shards
+	return nil
 }
 
 // Start will start the frisbee server and its reactor goroutines
@@ -165,17 +640,32 @@ func (s *Server) SetConcurrency(concurrency uint64) {
 // onClosed, OnShutdown, or preWrite functions have not been defined, it will
 // use the default functions for these.
 func (s *Server) Start(addr string) error {
-	var listener net.Listener
-	var err error
-	if s.options.TLSConfig != nil {
-		listener, err = tls.Listen("tcp", addr, s.options.TLSConfig)
+	var listeners []net.Listener
+	if s.acceptShards > 1 {
+		var err error
+		listeners, err = listenReusePort(addr, s.acceptShards)
+		if err != nil {
+			return err
+		}
+		if s.options.TLSConfig != nil {
+			for i, listener := range listeners {
+				listeners[i] = tls.NewListener(listener, s.options.TLSConfig)
+			}
+		}
 	} else {
-		listener, err = net.Listen("tcp", addr)
-	}
-	if err != nil {
-		return err
+		var listener net.Listener
+		var err error
+		if s.options.TLSConfig != nil {
+			listener, err = tls.Listen("tcp", addr, s.options.TLSConfig)
+		} else {
+			listener, err = net.Listen("tcp", addr)
+		}
+		if err != nil {
+			return err
+		}
+		listeners = []net.Listener{listener}
 	}
-	return s.StartWithListener(listener)
+	return s.start(listeners)
 }
 
 // StartWithListener will start the frisbee server and its reactor goroutines
@@ -186,10 +676,59 @@ func (s *Server) StartWithListener(listener net.Listener) error {
 	if listener == nil {
 		return ListenerNil
 	}
-	s.listener = listener
-	s.wg.Add(1)
+	return s.start([]net.Listener{listener})
+}
+
+// start runs an accept loop for each of the given listeners, and returns once all of them have stopped.
+// If one of the accept loops fails, the other listeners are closed and the first error is returned.
+func (s *Server) start(listeners []net.Listener) error {
+	s.listener = listeners[0]
+	s.listeners = listeners
+	if s.eventLoopWorkers > 0 {
+		err := s.eventLoopCompatible()
+		if err == nil {
+			s.eventLoop, err = newEventLoop(s, s.eventLoopWorkers)
+		}
+		if err != nil {
+			s.closeListeners()
+			return err
+		}
+	}
+	s.wg.Add(len(listeners))
 	close(s.startedCh)
-	return s.handleListener()
+
+	if len(listeners) == 1 {
+		return s.handleListener(listeners[0])
+	}
+
+	errCh := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			err := s.handleListener(listener)
+			if err != nil {
+				s.closeListeners()
+			}
+			errCh <- err
+		}(listener)
+	}
+	var err error
+	for range listeners {
+		if shardErr := <-errCh; err == nil && shardErr != nil && !errors.Is(shardErr, net.ErrClosed) {
+			err = shardErr
+		}
+	}
+	return err
+}
+
+// closeListeners closes all the server's listeners and returns the first error
+func (s *Server) closeListeners() error {
+	var err error
+	for _, listener := range s.listeners {
+		if closeErr := listener.Close(); err == nil && closeErr != nil && !errors.Is(closeErr, net.ErrClosed) {
+			err = closeErr
+		}
+	}
+	return err
 }
 
 // started returns a channel that will be closed when the server has successfully started
@@ -199,10 +738,10 @@ func (s *Server) started() <-chan struct{} {
 	return s.startedCh
 }
 
-func (s *Server) handleListener() error {
+func (s *Server) handleListener(listener net.Listener) error {
 	var backoff time.Duration
 	for {
-		newConn, err := s.listener.Accept()
+		newConn, err := listener.Accept()
 		if err != nil {
 			if s.shutdown.Load() {
 				s.wg.Done()
@@ -230,176 +769,270 @@ func (s *Server) handleListener() error {
 		}
 		backoff = 0
 
-		s.ServeConn(newConn)
+		if s.eventLoop == nil || !s.eventLoop.serve(newConn) {
+			s.ServeConn(newConn)
+		}
 	}
 }
 
-func (s *Server) createHandler(conn *Async, closed *atomic.Bool, wg *sync.WaitGroup, ctx context.Context, cancel context.CancelFunc) func(*packet.Packet) {
+func (s *Server) createHandler(conn *Async, closed *atomic.Bool, wg *sync.WaitGroup, ctx *handlerContext, handlers *connectionHandlers, cancel context.CancelFunc) func(*packet.Packet) {
+	closeConn := func(err error) {
+		_ = conn.Close()
+		if closed.CompareAndSwap(false, true) {
+			s.onClosed(conn, err)
+		}
+		cancel()
+	}
+	panicked := func(err *PanicError) {
+		s.reportPanic(conn.RemoteAddr(), err)
+	}
 	return func(p *packet.Packet) {
-		handlerFunc := s.handlerTable[p.Metadata.Operation]
-		if handlerFunc != nil {
-			packetCtx := ctx
-			if s.PacketContext != nil {
-				packetCtx = s.PacketContext(packetCtx, p)
-			}
-			outgoing, action := handlerFunc(packetCtx, p)
-			if outgoing != nil && outgoing.Metadata.ContentLength == uint32(outgoing.Content.Len()) {
-				s.preWrite()
-				err := conn.WritePacket(outgoing)
-				if outgoing != p {
-					packet.Put(outgoing)
-				}
+		defer wg.Done()
+		handlerFunc := handlers.lookup(s.handlers.Load(), p.Metadata.Operation)
+		if handlerFunc == nil {
+			var outgoing *packet.Packet
+			if handlerFunc, outgoing = s.notFound.lookup(s.Logger(), p); handlerFunc == nil {
 				packet.Put(p)
-				if err != nil {
-					_ = conn.Close()
-					if closed.CompareAndSwap(false, true) {
-						s.onClosed(conn, err)
+				if outgoing != nil {
+					s.preWrite()
+					err := conn.writePacket(outgoing)
+					packet.Put(outgoing)
+					if err != nil {
+						closeConn(err)
 					}
-					cancel()
-					wg.Done()
-					return
 				}
-			} else {
+				return
+			}
+		}
+		id, operation := p.Metadata.Id, p.Metadata.Operation
+		write := conn.WritePacket
+		packetCtx, panicErr := callPacketContext(ctx.load(), s.PacketContext, p)
+		result, ok := handlerResult{panic: panicErr}, true
+		if panicErr == nil {
+			result, ok = s.timeouts.run(packetCtx, s.Logger(), handlerFunc, p, panicked)
+		}
+		switch {
+		case !ok:
+			// The handler still owns the incoming packet, so only the TIMEOUT packet is sent and released
+			p = nil
+			result.outgoing = timeoutPacket(id)
+			write = conn.writePacket
+		case result.panic != nil:
+			s.reportPanic(conn.RemoteAddr(), result.panic)
+			if s.panicPolicy != PanicRespond {
 				packet.Put(p)
+				closeConn(result.panic)
+				return
 			}
-			switch action {
-			case NONE:
-			case CLOSE:
-				_ = conn.Close()
-				if closed.CompareAndSwap(false, true) {
-					s.onClosed(conn, nil)
-				}
-				cancel()
+			result.outgoing = errorPacket(id, ErrorPanic, HandlerPanicked.Error())
+			write = conn.writePacket
+		}
+		outgoing := result.outgoing
+		if outgoing != nil && outgoing.Metadata.ContentLength == uint32(outgoing.Content.Len()) {
+			s.preWrite()
+			err := write(outgoing)
+			if outgoing != p {
+				packet.Put(outgoing)
+			}
+			packet.Put(p)
+			if err != nil {
+				closeConn(err)
+				return
 			}
 		} else {
 			packet.Put(p)
 		}
-		wg.Done()
+		s.applyAction(packetCtx, conn, ctx, operation, result.action, closeConn)
 	}
 }
 
-func (s *Server) handleSinglePacket(frisbeeConn *Async, connCtx context.Context) {
-	var p *packet.Packet
-	var outgoing *packet.Packet
-	var action Action
-	var handlerFunc Handler
-	var err error
-	p, err = frisbeeConn.ReadPacket()
-	if err != nil {
-		_ = frisbeeConn.Close()
-		s.onClosed(frisbeeConn, err)
-		return
+func (s *Server) createBatchHandler(conn *Async, closed *atomic.Bool, wg *sync.WaitGroup, ctx *handlerContext, cancel context.CancelFunc) func(uint16, []*packet.Packet) {
+	closeConn := func(err error) {
+		_ = conn.Close()
+		if closed.CompareAndSwap(false, true) {
+			s.onClosed(conn, err)
+		}
+		cancel()
 	}
-	for {
-		handlerFunc = s.handlerTable[p.Metadata.Operation]
-		if handlerFunc != nil {
-			packetCtx := connCtx
-			if s.PacketContext != nil {
-				packetCtx = s.PacketContext(packetCtx, p)
+	return func(operation uint16, incoming []*packet.Packet) {
+		defer wg.Done()
+		batchCtx := ctx.load()
+		result := callBatchHandler(batchCtx, s.batchHandlers[operation].handler, operation, incoming)
+		write := conn.WritePacket
+		if result.panic != nil {
+			s.reportPanic(conn.RemoteAddr(), result.panic)
+			if s.panicPolicy != PanicRespond {
+				releaseBatch(incoming, nil)
+				closeConn(result.panic)
+				return
 			}
-			outgoing, action = handlerFunc(packetCtx, p)
+			result.outgoing = panicPackets(incoming)
+			write = conn.writePacket
+		}
+		var err error
+		for _, outgoing := range result.outgoing {
 			if outgoing != nil && outgoing.Metadata.ContentLength == uint32(outgoing.Content.Len()) {
 				s.preWrite()
-				err = frisbeeConn.WritePacket(outgoing)
-				if outgoing != p {
-					packet.Put(outgoing)
-				}
-				packet.Put(p)
-				if err != nil {
-					_ = frisbeeConn.Close()
-					s.onClosed(frisbeeConn, err)
-					return
+				if err = write(outgoing); err != nil {
+					break
 				}
-			} else {
-				packet.Put(p)
 			}
-			switch action {
-			case NONE:
-			case CLOSE:
-				_ = frisbeeConn.Close()
-				s.onClosed(frisbeeConn, nil)
-				return
-			}
-		} else {
-			packet.Put(p)
 		}
-		p, err = frisbeeConn.ReadPacket()
+		releaseBatch(incoming, result.outgoing)
 		if err != nil {
-			_ = frisbeeConn.Close()
-			s.onClosed(frisbeeConn, err)
+			closeConn(err)
 			return
 		}
+		s.applyAction(batchCtx, conn, ctx, operation, result.action, closeConn)
 	}
 }
 
-func (s *Server) handleUnlimitedPacket(frisbeeConn *Async, connCtx context.Context) {
-	p, err := frisbeeConn.ReadPacket()
+// applyAction applies the Action returned by a handler that was called with ctx for a packet with the given operation
+// received on the given connection, whose handlers are called with handlerCtx. Panics in the ActionHandler or the
+// UpdateContext function close the connection unless the PanicPolicy is PanicRespond.
+func (s *Server) applyAction(ctx context.Context, conn *Async, handlerCtx *handlerContext, operation uint16, action Action, closeConn func(error)) {
+	action, err := s.actionHandlers.resolve(ctx, conn, operation, action)
+	if err == nil && action == UPDATE {
+		err = handlerCtx.update(s.UpdateContext, conn, operation)
+	}
 	if err != nil {
-		_ = frisbeeConn.Close()
-		s.onClosed(frisbeeConn, err)
+		s.reportPanic(conn.RemoteAddr(), err)
+		if s.panicPolicy != PanicRespond {
+			closeConn(err)
+		}
 		return
 	}
+	switch action {
+	case CLOSE:
+		closeConn(nil)
+	case SHUTDOWN:
+		s.shutdownFromHandler()
+	}
+}
+
+// shutdownFromHandler shuts down the server from a new goroutine, since Shutdown waits for every handler to return
+func (s *Server) shutdownFromHandler() {
+	s.Logger().Debug().Msg("Shutting down server because of SHUTDOWN action")
+	go func() {
+		if err := s.Shutdown(); err != nil {
+			s.Logger().Error().Err(err).Msg("error while shutting down server")
+		}
+	}()
+}
+
+// reportPanic logs and counts a panic that was recovered from a handler for the connection with the given remote address
+func (s *Server) reportPanic(remote net.Addr, err *PanicError) {
+	s.panics.Add(1)
+	s.Logger().Error().Err(err).Str("remote", remote.String()).Msgf("recovered from handler panic:\n%s", err.Stack)
+}
+
+// handlePackets reads packets from the given connection and runs their handlers using the server's Executor
+// until the connection is closed
+func (s *Server) handlePackets(frisbeeConn *Async, connCtx context.Context) {
 	wg := new(sync.WaitGroup)
 	var closed atomic.Bool
-	connCtx, cancel := context.WithCancel(connCtx)
-	handle := s.createHandler(frisbeeConn, &closed, wg, connCtx, cancel)
-	for {
+	connCtx, cancel := context.WithCancel(withResponseWriter(connCtx, &asyncResponseWriter{conn: frisbeeConn, preWrite: s.preWrite}))
+	handlers := &connectionHandlers{interceptors: &s.interceptors}
+	handlerCtx := newHandlerContext(withConnectionHandlers(connCtx, handlers))
+	handle := s.createHandler(frisbeeConn, &closed, wg, handlerCtx, handlers, cancel)
+	handleBatch := s.createBatchHandler(frisbeeConn, &closed, wg, handlerCtx, cancel)
+	submitBatch := func(operation uint16, batch []*packet.Packet) {
 		wg.Add(1)
-		go handle(p)
-		p, err = frisbeeConn.ReadPacket()
-		if err != nil {
+		s.inFlight.Add(uint64(len(batch)))
+		s.submit(frisbeeConn, operation, func() {
+			handleBatch(operation, batch)
+			s.inFlight.Add(^uint64(len(batch) - 1))
+		})
+	}
+	pending := make(batches)
+	for {
+		// Batches are handled once every packet that has already been received has been added to them
+		if len(pending) > 0 && frisbeeConn.incoming.Length() == 0 {
+			pending.flush(submitBatch)
+		}
+		p, err := frisbeeConn.ReadPacket()
+		if err != nil || closed.Load() {
+			if p != nil {
+				packet.Put(p)
+			}
+			pending.release()
 			_ = frisbeeConn.Close()
 			if closed.CompareAndSwap(false, true) {
 				s.onClosed(frisbeeConn, err)
 			}
 			cancel()
 			wg.Wait()
+			if s.limiter != nil {
+				s.limiter.removeConnection(frisbeeConn)
+			}
+			s.executor.Release(frisbeeConn)
 			return
 		}
-	}
-}
-
-func (s *Server) handleLimitedPacket(frisbeeConn *Async, connCtx context.Context) {
-	p, err := frisbeeConn.ReadPacket()
-	if err != nil {
-		_ = frisbeeConn.Close()
-		s.onClosed(frisbeeConn, err)
-		return
-	}
-	wg := new(sync.WaitGroup)
-	var closed atomic.Bool
-	connCtx, cancel := context.WithCancel(connCtx)
-	handler := s.createHandler(frisbeeConn, &closed, wg, connCtx, cancel)
-	handle := func(p *packet.Packet) {
-		handler(p)
-		<-s.limiter
-	}
-	for {
-		select {
-		case s.limiter <- struct{}{}:
-			wg.Add(1)
-			go handle(p)
-			p, err = frisbeeConn.ReadPacket()
+		if p.Metadata.Operation == OPERATIONS {
+			if err = s.respondOperations(frisbeeConn.writePacket, p); err != nil {
+				_ = frisbeeConn.Close()
+			}
+			continue
+		}
+		if s.overload != nil && s.overload.shed(p.Metadata.Operation, s.inFlight.Load(), uint64(frisbeeConn.incoming.Length())) {
+			s.shed.Add(1)
+			s.overload.encode(p)
+			s.preWrite()
+			err = frisbeeConn.writePacket(p)
+			packet.Put(p)
 			if err != nil {
 				_ = frisbeeConn.Close()
-				if closed.CompareAndSwap(false, true) {
-					s.onClosed(frisbeeConn, err)
-				}
-				cancel()
-				wg.Wait()
-				return
 			}
-		case <-connCtx.Done():
-			_ = frisbeeConn.Close()
-			if closed.CompareAndSwap(false, true) {
-				s.onClosed(frisbeeConn, err)
+			continue
+		}
+		if batch, ok := s.batchHandlers[p.Metadata.Operation]; ok {
+			if full := pending.add(p, batch.limit); full != nil {
+				submitBatch(p.Metadata.Operation, full)
 			}
-			wg.Wait()
-			return
+			continue
+		}
+		wg.Add(1)
+		s.inFlight.Add(1)
+		task := func() {
+			handle(p)
+			s.inFlight.Add(^uint64(0))
+		}
+		if s.orderer != nil {
+			operation := p.Metadata.Operation
+			s.orderer.execute(frisbeeConn, s.orderer.key(p), task, func(task func()) {
+				s.submit(frisbeeConn, operation, task)
+			})
+		} else {
+			s.submit(frisbeeConn, p.Metadata.Operation, task)
 		}
 	}
 }
 
+// respondOperations writes the response to an OPERATIONS packet with the given write function, and releases the
+// packet. Requests that cannot be decoded are dropped, and the client will time out while connecting.
+func (s *Server) respondOperations(write func(*packet.Packet) error, p *packet.Packet) error {
+	outgoing, err := operationsResponse(p, s.handlers.Load().operations())
+	packet.Put(p)
+	if err != nil {
+		s.Logger().Debug().Err(err).Msg("dropping OPERATIONS packet that could not be decoded")
+		return nil
+	}
+	s.preWrite()
+	err = write(outgoing)
+	packet.Put(outgoing)
+	return err
+}
+
+// submit runs the task for a packet with the given operation using the server's Executor,
+// once it is allowed to run by any operation or connection concurrency limits
+func (s *Server) submit(conn *Async, operation uint16, task func()) {
+	if s.limiter != nil {
+		s.limiter.execute(s.executor, conn, operation, task)
+	} else {
+		s.executor.Execute(conn, task)
+	}
+}
+
 // ServeConn takes a net.Conn and starts a goroutine to handle it using the Server.
 func (s *Server) ServeConn(conn net.Conn) {
 	s.wg.Add(1)
@@ -428,7 +1061,7 @@ func (s *Server) serveConn(newConn net.Conn) {
 		}
 	}
 
-	frisbeeConn := NewAsync(newConn, s.Logger(), s.streamHandler)
+	frisbeeConn := NewAsyncWithOptions(newConn, s.options, s.streamHandler)
 	connCtx := s.baseContext
 	s.connectionsMu.Lock()
 	if s.shutdown.Load() {
@@ -440,14 +1073,7 @@ func (s *Server) serveConn(newConn net.Conn) {
 	if s.ConnContext != nil {
 		connCtx = s.ConnContext(connCtx, frisbeeConn)
 	}
-	switch s.concurrency {
-	case 0:
-		s.handleUnlimitedPacket(frisbeeConn, connCtx)
-	case 1:
-		s.handleSinglePacket(frisbeeConn, connCtx)
-	default:
-		s.handleLimitedPacket(frisbeeConn, connCtx)
-	}
+	s.handlePackets(frisbeeConn, connCtx)
 	s.connectionsMu.Lock()
 	if !s.shutdown.Load() {
 		delete(s.connections, frisbeeConn)
@@ -471,10 +1097,11 @@ func (s *Server) Shutdown() error {
 			delete(s.connections, c)
 		}
 		s.connectionsMu.Unlock()
-		defer s.wg.Wait()
-		if s.listener != nil {
-			return s.listener.Close()
+		if s.eventLoop != nil {
+			s.eventLoop.close()
 		}
+		defer s.wg.Wait()
+		return s.closeListeners()
 	}
 	return nil
 }
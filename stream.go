@@ -3,11 +3,11 @@
 package frisbee
 
 import (
+	"io"
 	"sync"
 	"sync/atomic"
 
-	"github.com/loopholelabs/common/pkg/queue"
-
+	"github.com/loopholelabs/frisbee-go/internal/queue"
 	"github.com/loopholelabs/frisbee-go/pkg/packet"
 )
 
@@ -20,16 +20,19 @@ type Stream struct {
 	id      uint16
 	conn    *Async
 	closed  atomic.Bool
+	closeCh chan struct{}
 	queue   *queue.Circular[packet.Packet, *packet.Packet]
+	dropped atomic.Uint64
 	staleMu sync.Mutex
 	stale   []*packet.Packet
 }
 
 func newStream(id uint16, conn *Async) *Stream {
 	return &Stream{
-		id:    id,
-		conn:  conn,
-		queue: queue.NewCircular[packet.Packet, *packet.Packet](DefaultStreamBufferSize),
+		id:      id,
+		conn:    conn,
+		closeCh: make(chan struct{}),
+		queue:   queue.NewCircular[packet.Packet, *packet.Packet](uint64(conn.options.StreamBufferSize)),
 	}
 }
 
@@ -79,7 +82,23 @@ func (s *Stream) WritePacket(p *packet.Packet) error {
 	}
 	p.Metadata.Id = s.id
 	p.Metadata.Operation = STREAM
-	return s.conn.writePacket(p, true)
+	return s.conn.writePacket(p)
+}
+
+// WriteFrom writes a single packet to the stream whose content is the next contentLength bytes read from r.
+// The content is copied from r by the connection's write loop, so if r is an *os.File and the underlying
+// connection is a plain TCP connection, the content is sent using sendfile without being copied into a packet.
+//
+// Unlike WritePacket, this function blocks until the packet has been written. If r returns fewer than
+// contentLength bytes, the connection is closed since the packet can no longer be framed correctly.
+func (s *Stream) WriteFrom(r io.Reader, contentLength uint32) error {
+	if s.closed.Load() {
+		return StreamClosed
+	}
+	if contentLength == 0 {
+		return InvalidStreamPacket
+	}
+	return s.conn.writeFrom(s.id, STREAM, r, contentLength)
 }
 
 // ID returns the stream's ID.
@@ -87,11 +106,22 @@ func (s *Stream) ID() uint16 {
 	return s.id
 }
 
+// Dropped returns the number of incoming packets that have been dropped by the stream's OverflowPolicy.
+func (s *Stream) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
 // Conn returns the connection that the stream is associated with.
 func (s *Stream) Conn() *Async {
 	return s.conn
 }
 
+// CloseChannel returns a channel that is closed once the stream has been closed, either locally,
+// by the peer, or because the connection was closed
+func (s *Stream) CloseChannel() <-chan struct{} {
+	return s.closeCh
+}
+
 // Close will close the stream and prevent any further reads or writes.
 func (s *Stream) Close() error {
 	return s.closeSend(true)
@@ -102,12 +132,13 @@ func (s *Stream) closeSend(lock bool) error {
 	if s.closed.CompareAndSwap(false, true) {
 		s.queue.Close()
 		s.stale = s.queue.Drain()
+		close(s.closeCh)
 		s.staleMu.Unlock()
 
 		p := packet.Get()
 		p.Metadata.Id = s.id
 		p.Metadata.Operation = STREAM
-		err := s.conn.writePacket(p, true)
+		err := s.conn.writePacket(p)
 		packet.Put(p)
 
 		if lock {
@@ -128,6 +159,7 @@ func (s *Stream) close() {
 	if s.closed.CompareAndSwap(false, true) {
 		s.queue.Close()
 		s.stale = s.queue.Drain()
+		close(s.closeCh)
 	}
 	s.staleMu.Unlock()
 }
@@ -4,7 +4,10 @@ package frisbee
 
 import (
 	"crypto/rand"
+	"io"
 	"net"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -12,6 +15,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/loopholelabs/logging"
+	"github.com/loopholelabs/testing/conn/pair"
 
 	"github.com/loopholelabs/frisbee-go/pkg/packet"
 )
@@ -266,3 +270,115 @@ func TestStreamConnClose(t *testing.T) {
 	err = readerConn.Close()
 	assert.NoError(t, err)
 }
+
+func TestStreamOverflow(t *testing.T) {
+	t.Parallel()
+
+	const testSize = 32
+	const queueSize = 7
+	const packetSize = 512
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	reader, writer := net.Pipe()
+
+	streamCh := make(chan *Stream, 1)
+	readerConn := NewAsyncWithOptions(reader, &Options{Logger: emptyLogger, StreamBufferSize: queueSize, StreamOverflow: OverflowDropOldest}, func(stream *Stream) {
+		streamCh <- stream
+	})
+	writerConn := NewAsync(writer, emptyLogger)
+
+	writerStream := writerConn.NewStream(0)
+
+	data := make([]byte, packetSize)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+
+	p := packet.Get()
+	p.Metadata.ContentLength = uint32(packetSize)
+	p.Content.Write(data)
+	for i := 0; i < testSize; i++ {
+		p.Content.Bytes()[0] = byte(i)
+		err = writerStream.WritePacket(p)
+		require.NoError(t, err)
+	}
+	packet.Put(p)
+
+	err = writerConn.Flush()
+	require.NoError(t, err)
+
+	readerStream := <-streamCh
+	require.Eventually(t, func() bool {
+		return readerStream.Dropped() == testSize-queueSize
+	}, DefaultDeadline, time.Millisecond*10)
+	assert.Equal(t, uint64(0), readerConn.Dropped())
+
+	for i := testSize - queueSize; i < testSize; i++ {
+		p, err = readerStream.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, byte(i), p.Content.Bytes()[0])
+		packet.Put(p)
+	}
+
+	err = readerConn.Close()
+	assert.NoError(t, err)
+	err = writerConn.Close()
+	assert.NoError(t, err)
+}
+
+func TestStreamWriteFrom(t *testing.T) {
+	t.Parallel()
+
+	const packetSize = 1 << 18
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	reader, writer, err := pair.New()
+	require.NoError(t, err)
+
+	streamCh := make(chan *Stream, 1)
+	readerConn := NewAsync(reader, emptyLogger, func(stream *Stream) {
+		streamCh <- stream
+	})
+	writerConn := NewAsync(writer, emptyLogger)
+
+	writerStream := writerConn.NewStream(0)
+
+	data := make([]byte, packetSize*2)
+	_, err = rand.Read(data)
+	require.NoError(t, err)
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "content"))
+	require.NoError(t, err)
+	_, err = f.Write(data)
+	require.NoError(t, err)
+	_, err = f.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+
+	err = writerStream.WriteFrom(f, packetSize)
+	require.NoError(t, err)
+	err = writerStream.WriteFrom(f, packetSize)
+	require.NoError(t, err)
+
+	readerStream := <-streamCh
+	for i := 0; i < 2; i++ {
+		p, err := readerStream.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, uint32(packetSize), p.Metadata.ContentLength)
+		assert.Equal(t, data[i*packetSize:(i+1)*packetSize], p.Content.Bytes())
+		packet.Put(p)
+	}
+
+	err = writerStream.WriteFrom(f, 1)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	require.Eventually(t, func() bool {
+		return writerConn.Closed()
+	}, DefaultDeadline, time.Millisecond*10)
+
+	err = f.Close()
+	assert.NoError(t, err)
+	err = readerConn.Close()
+	assert.NoError(t, err)
+	err = writerConn.Close()
+	assert.NoError(t, err)
+}
@@ -13,7 +13,6 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/loopholelabs/logging/loggers/noop"
 	"github.com/loopholelabs/logging/types"
 
 	"github.com/loopholelabs/frisbee-go/internal/dialer"
@@ -26,28 +25,40 @@ import (
 // meant to be used by frisbee client and server implementations
 type Sync struct {
 	sync.Mutex
-	conn   net.Conn
-	closed atomic.Bool
-	logger types.Logger
-	error  atomic.Value
-	ctxMu  sync.RWMutex
-	ctx    context.Context
+	conn     net.Conn
+	options  *Options
+	vectored bool
+	closed   atomic.Bool
+	logger   types.Logger
+	error    atomic.Value
+	ctxMu    sync.RWMutex
+	ctx      context.Context
 }
 
 // ConnectSync creates a new TCP connection (using net.Dial) and wraps it in a frisbee connection
 func ConnectSync(addr string, keepAlive time.Duration, logger types.Logger, TLSConfig *tls.Config) (*Sync, error) {
+	return ConnectSyncWithOptions(addr, loadOptions(WithKeepAlive(keepAlive), WithLogger(logger), WithTLS(TLSConfig)))
+}
+
+// ConnectSyncWithOptions creates a new TCP connection (using net.Dial) and wraps it in a frisbee connection
+// that is configured using the given Options
+func ConnectSyncWithOptions(addr string, options *Options) (*Sync, error) {
+	if options == nil {
+		options = loadOptions()
+	}
+
 	var conn net.Conn
 	var err error
 
 	d := dialer.NewRetry()
 
-	if TLSConfig != nil {
-		conn, err = d.DialTLS("tcp", addr, TLSConfig)
+	if options.TLSConfig != nil {
+		conn, err = d.DialTLS("tcp", addr, options.TLSConfig)
 	} else {
 		conn, err = d.Dial("tcp", addr)
 		if err == nil {
 			_ = conn.(*net.TCPConn).SetKeepAlive(true)
-			_ = conn.(*net.TCPConn).SetKeepAlivePeriod(keepAlive)
+			_ = conn.(*net.TCPConn).SetKeepAlivePeriod(options.KeepAlive)
 		}
 	}
 
@@ -55,19 +66,34 @@ func ConnectSync(addr string, keepAlive time.Duration, logger types.Logger, TLSC
 		return nil, err
 	}
 
-	return NewSync(conn, logger), nil
+	return NewSyncWithOptions(conn, options), nil
 }
 
 // NewSync takes an existing net.Conn object and wraps it in a frisbee connection
 func NewSync(c net.Conn, logger types.Logger) (conn *Sync) {
+	return NewSyncWithOptions(c, loadOptions(WithLogger(logger)))
+}
+
+// NewSyncWithOptions takes an existing net.Conn object and wraps it in a frisbee connection
+// that is configured using the given Options. Any unset fields in the Options will use their default values.
+func NewSyncWithOptions(c net.Conn, options *Options) (conn *Sync) {
+	if options == nil {
+		options = loadOptions()
+	} else {
+		options = loadOptions(WithOptions(*options))
+	}
+
 	conn = &Sync{
-		conn:   c,
-		logger: logger,
+		conn:    c,
+		options: options,
+		logger:  options.Logger,
 	}
 
-	if logger == nil {
-		conn.logger = noop.New(types.InfoLevel)
+	switch c.(type) {
+	case *net.TCPConn, *net.UnixConn:
+		conn.vectored = true
 	}
+
 	return
 }
 
@@ -125,46 +151,41 @@ func (c *Sync) RemoteAddr() net.Addr {
 
 // WritePacket takes a packet.Packet and sends it synchronously.
 //
+// The packet's metadata and content are sent with a single vectored write if the underlying net.Conn supports it.
+// Otherwise, they are copied into a single buffer and sent with a single write, unless the content is too large to be copied.
+//
 // If packet.Metadata.ContentLength == 0, then the content array must be nil. Otherwise, it is required that packet.Metadata.ContentLength == len(content).
 func (c *Sync) WritePacket(p *packet.Packet) error {
 	if int(p.Metadata.ContentLength) != p.Content.Len() {
 		return InvalidContentLength
 	}
 
-	var encodedMetadata [metadata.Size]byte
-
-	binary.BigEndian.PutUint16(encodedMetadata[metadata.IdOffset:metadata.IdOffset+metadata.IdSize], p.Metadata.Id)
-	binary.BigEndian.PutUint16(encodedMetadata[metadata.OperationOffset:metadata.OperationOffset+metadata.OperationSize], p.Metadata.Operation)
-	binary.BigEndian.PutUint32(encodedMetadata[metadata.ContentLengthOffset:metadata.ContentLengthOffset+metadata.ContentLengthSize], p.Metadata.ContentLength)
+	f := encodeFrame(p, c.vectored || int(p.Metadata.ContentLength) > c.options.BufferSize)
 
 	c.Lock()
 	if c.closed.Load() {
 		c.Unlock()
+		putFrame(f, c.options.BufferSize)
 		return ConnectionClosed
 	}
 
-	_, err := c.conn.Write(encodedMetadata[:])
+	var err error
+	if f.content != nil {
+		buffers := net.Buffers{f.buf, f.content}
+		_, err = buffers.WriteTo(c.conn)
+	} else {
+		_, err = c.conn.Write(f.buf)
+	}
+	putFrame(f, c.options.BufferSize)
 	if err != nil {
 		c.Unlock()
 		if c.closed.Load() {
-			c.Logger().Debug().Err(ConnectionClosed).Uint16("Packet ID", p.Metadata.Id).Msg("error while writing encoded metadata")
+			c.Logger().Debug().Err(ConnectionClosed).Uint16("Packet ID", p.Metadata.Id).Msg("error while writing packet")
 			return ConnectionClosed
 		}
-		c.Logger().Debug().Err(err).Uint16("Packet ID", p.Metadata.Id).Msg("error while writing encoded metadata")
+		c.Logger().Debug().Err(err).Uint16("Packet ID", p.Metadata.Id).Msg("error while writing packet")
 		return c.closeWithError(err)
 	}
-	if p.Metadata.ContentLength != 0 {
-		_, err = c.conn.Write(p.Content.Bytes()[:p.Metadata.ContentLength])
-		if err != nil {
-			c.Unlock()
-			if c.closed.Load() {
-				c.Logger().Debug().Err(ConnectionClosed).Uint16("Packet ID", p.Metadata.Id).Msg("error while writing encoded metadata")
-				return ConnectionClosed
-			}
-			c.Logger().Debug().Err(err).Uint16("Packet ID", p.Metadata.Id).Msg("error while writing encoded metadata")
-			return c.closeWithError(err)
-		}
-	}
 
 	c.Unlock()
 	return nil
@@ -193,6 +214,12 @@ func (c *Sync) ReadPacket() (*packet.Packet, error) {
 	p.Metadata.Operation = binary.BigEndian.Uint16(encodedPacket[metadata.OperationOffset : metadata.OperationOffset+metadata.OperationSize])
 	p.Metadata.ContentLength = binary.BigEndian.Uint32(encodedPacket[metadata.ContentLengthOffset : metadata.ContentLengthOffset+metadata.ContentLengthSize])
 
+	if c.options.MaxContentLength > 0 && int64(p.Metadata.ContentLength) > int64(c.options.MaxContentLength) {
+		c.Logger().Debug().Uint32("contentLength", p.Metadata.ContentLength).Msg("content length exceeds the maximum, calling closeWithError")
+		packet.Put(p)
+		return nil, c.closeWithError(ContentLengthExceeded)
+	}
+
 	if p.Metadata.ContentLength > 0 {
 		contentLength := int(p.Metadata.ContentLength)
 		p.Content.Grow(contentLength)
@@ -367,3 +367,44 @@ func BenchmarkSyncThroughputNetwork(b *testing.B) {
 	_ = readerConn.Close()
 	_ = writerConn.Close()
 }
+
+func TestSyncMaxContentLength(t *testing.T) {
+	t.Parallel()
+
+	reader, writer := net.Pipe()
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+
+	readerConn := NewSyncWithOptions(reader, &Options{Logger: emptyLogger, MaxContentLength: 1024})
+	writerConn := NewSync(writer, emptyLogger)
+
+	end := make(chan struct{}, 1)
+
+	go func() {
+		p := packet.Get()
+		p.Metadata.Operation = 32
+		p.Content.Write(make([]byte, 1024))
+		p.Metadata.ContentLength = 1024
+		assert.NoError(t, writerConn.WritePacket(p))
+
+		// The reader closes the connection before the content of this packet is read
+		p.Content.Write([]byte{0})
+		p.Metadata.ContentLength = 1025
+		_ = writerConn.WritePacket(p)
+		packet.Put(p)
+		end <- struct{}{}
+	}()
+
+	p, err := readerConn.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1024), p.Metadata.ContentLength)
+	packet.Put(p)
+
+	_, err = readerConn.ReadPacket()
+	assert.ErrorIs(t, err, ContentLengthExceeded)
+	assert.ErrorIs(t, readerConn.Error(), ContentLengthExceeded)
+	<-end
+
+	_ = readerConn.Close()
+	_ = writerConn.Close()
+}
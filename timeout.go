@@ -0,0 +1,201 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"bytes"
+	"context"
+	"runtime/pprof"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/loopholelabs/logging/types"
+
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+// timeouts enforces the handler timeouts for a Server or Client, and runs the watchdog for
+// handlers that keep running after they have timed out
+type timeouts struct {
+	mu         sync.RWMutex
+	timeout    time.Duration
+	operations map[uint16]time.Duration
+	hardLimit  time.Duration
+	timedOut   atomic.Uint64
+	stuck      atomic.Int64
+
+	// handlers is used to label the goroutines of handlers that are watched by the watchdog
+	handlers atomic.Uint64
+}
+
+// handlerLabel is the profiler label (see runtime/pprof) that the goroutines of handlers that
+// are watched by the watchdog are labeled with, so that they can be found once they are stuck
+const handlerLabel = "frisbee.handler"
+
+func newTimeouts() *timeouts {
+	return &timeouts{
+		operations: make(map[uint16]time.Duration),
+	}
+}
+
+func (t *timeouts) setTimeout(timeout time.Duration) {
+	t.mu.Lock()
+	t.timeout = timeout
+	t.mu.Unlock()
+}
+
+// setOperation sets the timeout for the given operation, removing it (so the default timeout is used) if timeout is 0
+func (t *timeouts) setOperation(operation uint16, timeout time.Duration) {
+	t.mu.Lock()
+	if timeout == 0 {
+		delete(t.operations, operation)
+	} else {
+		t.operations[operation] = timeout
+	}
+	t.mu.Unlock()
+}
+
+func (t *timeouts) setHardLimit(hardLimit time.Duration) {
+	t.mu.Lock()
+	t.hardLimit = hardLimit
+	t.mu.Unlock()
+}
+
+// get returns the timeout and hard limit for the given operation, where the hard
+// limit is 0 if it is not longer than the timeout since it could never be reached
+func (t *timeouts) get(operation uint16) (time.Duration, time.Duration) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	timeout, ok := t.operations[operation]
+	if !ok {
+		timeout = t.timeout
+	}
+	if t.hardLimit <= timeout {
+		return timeout, 0
+	}
+	return timeout, t.hardLimit
+}
+
+// run runs the handler for the incoming packet with the timeout for its operation, and returns the handler's result.
+// If the handler has not returned once the timeout expires, run returns false straight away without waiting for it,
+// and the incoming packet must not be used (or returned to the pool) by the caller since the handler still owns it.
+// If the handler then panics, panicked is called with the recovered panic.
+//
+// If t is nil, or the operation has no timeout, the handler is run directly.
+func (t *timeouts) run(ctx context.Context, logger types.Logger, handler Handler, incoming *packet.Packet, panicked func(*PanicError)) (handlerResult, bool) {
+	if t == nil {
+		return callHandler(ctx, handler, incoming), true
+	}
+	operation := incoming.Metadata.Operation
+	timeout, hardLimit := t.get(operation)
+	if timeout <= 0 {
+		return callHandler(ctx, handler, incoming), true
+	}
+
+	id := incoming.Metadata.Id
+	started := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	done := make(chan handlerResult, 1)
+	var label string
+	if hardLimit > 0 {
+		label = strconv.FormatUint(t.handlers.Add(1), 10)
+	}
+	go func() {
+		defer cancel()
+		if label == "" {
+			done <- callHandler(ctx, handler, incoming)
+			return
+		}
+		pprof.Do(ctx, pprof.Labels(handlerLabel, label), func(ctx context.Context) {
+			done <- callHandler(ctx, handler, incoming)
+		})
+	}()
+
+	select {
+	case result := <-done:
+		return result, true
+	case <-ctx.Done():
+	}
+	select {
+	case result := <-done:
+		return result, true
+	default:
+	}
+	if ctx.Err() != context.DeadlineExceeded || time.Since(started) < timeout {
+		// The context was canceled because the connection was closed, which the handler is expected to
+		// notice on its own, so it is waited for in the same way as if there were no timeout
+		return <-done, true
+	}
+
+	t.timedOut.Add(1)
+	logger.Debug().Uint16("id", id).Uint16("operation", operation).Msgf("handler timed out after %s", timeout)
+	go func() {
+		var result handlerResult
+		if hardLimit > 0 {
+			watchdog := time.NewTimer(hardLimit - time.Since(started))
+			select {
+			case result = <-done:
+				watchdog.Stop()
+			case <-watchdog.C:
+				t.stuck.Add(1)
+				logger.Error().Uint16("id", id).Uint16("operation", operation).
+					Msgf("handler has ignored its cancellation and has not returned after %s:\n%s", hardLimit, goroutineStack(label))
+				result = <-done
+				t.stuck.Add(-1)
+			}
+		} else {
+			result = <-done
+		}
+		logger.Debug().Uint16("id", id).Uint16("operation", operation).Msgf("handler returned %s after timing out, discarding its result", time.Since(started))
+		if result.panic != nil {
+			panicked(result.panic)
+		}
+		if result.outgoing != incoming {
+			packet.Put(result.outgoing)
+		}
+		packet.Put(incoming)
+	}()
+	return handlerResult{}, false
+}
+
+// timedOutTotal returns the total number of handlers that have timed out
+func (t *timeouts) timedOutTotal() uint64 {
+	if t == nil {
+		return 0
+	}
+	return t.timedOut.Load()
+}
+
+// stuckTotal returns the number of handlers that have exceeded the hard limit and have still not returned
+func (t *timeouts) stuckTotal() uint64 {
+	if t == nil {
+		return 0
+	}
+	return uint64(t.stuck.Load())
+}
+
+// timeoutPacket returns a TIMEOUT packet for the packet with the given Id
+func timeoutPacket(id uint16) *packet.Packet {
+	p := packet.Get()
+	p.Metadata.Id = id
+	p.Metadata.Operation = TIMEOUT
+	p.Metadata.ContentLength = 0
+	return p
+}
+
+// goroutineStack returns the stack trace of the handler goroutine with the given label (see handlerLabel), or the
+// stack traces of all goroutines if it cannot be found. The goroutine is only looked up once it is stuck, since
+// the goroutine profile stops the world, and labeling the goroutine is much cheaper than recording its ID.
+func goroutineStack(label string) []byte {
+	var buf bytes.Buffer
+	_ = pprof.Lookup("goroutine").WriteTo(&buf, 1)
+	labels := []byte("# labels: {\"" + handlerLabel + "\":\"" + label + "\"}")
+	for _, stack := range bytes.Split(buf.Bytes(), []byte("\n\n")) {
+		if bytes.Contains(stack, labels) {
+			return stack
+		}
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,200 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package frisbee
+
+import (
+	"context"
+	"runtime/pprof"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/logging"
+	"github.com/loopholelabs/testing/conn/pair"
+
+	"github.com/loopholelabs/frisbee-go/pkg/metadata"
+	"github.com/loopholelabs/frisbee-go/pkg/packet"
+)
+
+func TestGoroutineStack(t *testing.T) {
+	t.Parallel()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go pprof.Do(context.Background(), pprof.Labels(handlerLabel, "test"), func(context.Context) {
+		close(started)
+		<-release
+	})
+	<-started
+
+	stack := string(goroutineStack("test"))
+	assert.Contains(t, stack, "TestGoroutineStack.func1")
+	assert.NotContains(t, stack, "TestGoroutineStack+")
+	close(release)
+}
+
+func TestTimeoutsHardLimit(t *testing.T) {
+	t.Parallel()
+
+	timeouts := newTimeouts()
+	timeouts.setTimeout(time.Second)
+	timeouts.setOperation(metadata.PacketProbe, time.Second*3)
+	timeouts.setHardLimit(time.Second * 2)
+
+	timeout, hardLimit := timeouts.get(metadata.PacketPing)
+	assert.Equal(t, time.Second, timeout)
+	assert.Equal(t, time.Second*2, hardLimit)
+
+	// The watchdog is disabled for operations whose timeout is not shorter than the hard limit
+	timeout, hardLimit = timeouts.get(metadata.PacketProbe)
+	assert.Equal(t, time.Second*3, timeout)
+	assert.Zero(t, hardLimit)
+}
+
+func TestServerHandlerTimeout(t *testing.T) {
+	t.Parallel()
+
+	const timeout = time.Millisecond * 50
+
+	release := make(chan struct{})
+	serverHandlerTable := make(HandlerTable)
+	serverHandlerTable[metadata.PacketProbe] = func(ctx context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		_, ok := ctx.Deadline()
+		assert.True(t, ok)
+		<-ctx.Done()
+		// The handler ignores its cancellation until it is released
+		<-release
+		outgoing = incoming
+		action = CLOSE
+		return
+	}
+	serverHandlerTable[metadata.PacketPing] = func(ctx context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		_, ok := ctx.Deadline()
+		assert.False(t, ok)
+		outgoing = incoming
+		return
+	}
+
+	pings := make(chan uint16, 1)
+	clientHandlerTable := make(HandlerTable)
+	clientHandlerTable[metadata.PacketPing] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		pings <- incoming.Metadata.Id
+		return
+	}
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	s, err := NewServer(serverHandlerTable, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	// Packets are handled one at a time, so a stuck handler would otherwise block the connection
+	s.SetConcurrency(1)
+	s.SetOperationTimeout(metadata.PacketProbe, timeout)
+	s.SetWatchdog(timeout * 2)
+
+	c, err := NewClient(clientHandlerTable, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	timedOut := make(chan uint16, 1)
+	c.SetTimeoutHandler(func(_ context.Context, id uint16) {
+		timedOut <- id
+	})
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+
+	go s.ServeConn(serverConn)
+	err = c.FromConn(clientConn)
+	require.NoError(t, err)
+
+	p := packet.Get()
+	p.Metadata.Id = 1
+	p.Metadata.Operation = metadata.PacketProbe
+	err = c.WritePacket(p)
+	require.NoError(t, err)
+
+	p.Metadata.Id = 2
+	p.Metadata.Operation = metadata.PacketPing
+	err = c.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	select {
+	case id := <-timedOut:
+		assert.Equal(t, uint16(1), id)
+	case <-time.After(DefaultDeadline):
+		t.Fatal("timed out waiting for TIMEOUT packet")
+	}
+
+	select {
+	case id := <-pings:
+		assert.Equal(t, uint16(2), id)
+	case <-time.After(DefaultDeadline):
+		t.Fatal("timed out waiting for ping")
+	}
+	assert.Equal(t, uint64(1), s.TimedOut())
+
+	require.Eventually(t, func() bool {
+		return s.Stuck() == 1
+	}, DefaultDeadline, time.Millisecond*10)
+
+	// The result of the handler is discarded once it returns, including its CLOSE action
+	close(release)
+	require.Eventually(t, func() bool {
+		return s.Stuck() == 0
+	}, DefaultDeadline, time.Millisecond*10)
+	assert.False(t, c.Closed())
+
+	err = c.Close()
+	assert.NoError(t, err)
+	err = s.Shutdown()
+	assert.NoError(t, err)
+}
+
+func TestClientHandlerTimeout(t *testing.T) {
+	t.Parallel()
+
+	const timeout = time.Millisecond * 50
+
+	clientHandlerTable := make(HandlerTable)
+	clientHandlerTable[metadata.PacketPing] = func(_ context.Context, incoming *packet.Packet) (outgoing *packet.Packet, action Action) {
+		time.Sleep(timeout * 2)
+		outgoing = incoming
+		return
+	}
+
+	emptyLogger := logging.Test(t, logging.Noop, t.Name())
+	c, err := NewClient(clientHandlerTable, context.Background(), WithLogger(emptyLogger))
+	require.NoError(t, err)
+
+	c.SetHandlerTimeout(timeout)
+
+	serverConn, clientConn, err := pair.New()
+	require.NoError(t, err)
+
+	err = c.FromConn(clientConn)
+	require.NoError(t, err)
+
+	server := NewAsync(serverConn, emptyLogger)
+
+	p := packet.Get()
+	p.Metadata.Id = 64
+	p.Metadata.Operation = metadata.PacketPing
+	err = server.WritePacket(p)
+	require.NoError(t, err)
+	packet.Put(p)
+
+	p, err = server.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, TIMEOUT, p.Metadata.Operation)
+	assert.Equal(t, uint16(64), p.Metadata.Id)
+	assert.Equal(t, uint32(0), p.Metadata.ContentLength)
+	packet.Put(p)
+	assert.Equal(t, uint64(1), c.TimedOut())
+
+	err = c.Close()
+	assert.NoError(t, err)
+	err = server.Close()
+	assert.NoError(t, err)
+}